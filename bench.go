@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/feiyuw/simgo/protocols"
+)
+
+// repeatable flag of "key: value" headers
+type headerFlags map[string]string
+
+func (hf headerFlags) String() string {
+	return fmt.Sprint(map[string]string(hf))
+}
+
+func (hf headerFlags) Set(value string) error {
+	pos := strings.Index(value, ":")
+	if pos <= 0 {
+		return fmt.Errorf("header should be in key: value format")
+	}
+	hf[strings.TrimSpace(value[:pos])] = strings.TrimSpace(value[pos+1:])
+	return nil
+}
+
+// simgo bench -server 127.0.0.1:4999 -protos helloworld.proto -method helloworld.Greeter.SayHello -data '{"name": "{{randString 8}}"}' -rps 100 -duration 10s
+func runBench(args []string) error {
+	var (
+		server, protos, protosets, caFile, serverName string
+		useTLS, reflection                            bool
+		opts                                          = &protocols.BenchOptions{Options: &protocols.InvokeOptions{Headers: headerFlags{}}}
+		data                                          string
+	)
+
+	fs := flag.NewFlagSet("bench", flag.ExitOnError)
+	fs.StringVar(&server, "server", "127.0.0.1:4999", "address of gRPC server")
+	fs.StringVar(&protos, "protos", "", "proto files, separated by comma")
+	fs.StringVar(&protosets, "protosets", "", "compiled FileDescriptorSet files, separated by comma")
+	fs.BoolVar(&reflection, "reflection", false, "get services from server reflection, besides protos and protosets")
+	fs.StringVar(&caFile, "caFile", "", "CA bundle to verify server certificate, TLS is used if set")
+	fs.StringVar(&serverName, "serverName", "", "override server name used to verify server certificate")
+	fs.BoolVar(&useTLS, "tls", false, "use TLS with system CAs")
+	fs.StringVar(&opts.Method, "method", "", "full name of method, eg. helloworld.Greeter.SayHello")
+	fs.StringVar(&data, "data", "{}", "request data in json, rendered as template for each request")
+	fs.IntVar(&opts.RPS, "rps", 0, "requests per second, as fast as possible if 0")
+	fs.IntVar(&opts.Concurrency, "concurrency", 1, "number of concurrent workers")
+	fs.StringVar(&opts.Duration, "duration", "10s", "duration of benchmark")
+	fs.StringVar(&opts.Options.Timeout, "timeout", "", "deadline of each call, eg. 500ms")
+	fs.Var(headerFlags(opts.Options.Headers), "header", "outgoing metadata in key: value format, can be repeated")
+	fs.Parse(args)
+	opts.Data = data
+
+	if (protos == "" && protosets == "" && !reflection) || opts.Method == "" {
+		return fmt.Errorf("protos, protosets or reflection, and method should be set")
+	}
+	options := map[string]interface{}{"tls": useTLS, "reflection": reflection}
+	if protos != "" {
+		options["protos"] = strings.Split(protos, ",")
+	}
+	if protosets != "" {
+		options["protosets"] = strings.Split(protosets, ",")
+	}
+	if caFile != "" {
+		options["caFile"] = caFile
+	}
+	if serverName != "" {
+		options["serverName"] = serverName
+	}
+	client, err := protocols.NewRpcClient("grpc", server, options)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	// stop benchmark early by Ctrl-C, statistics of finished calls are still printed
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	go func() {
+		<-interrupt
+		cancel()
+	}()
+
+	result, err := client.(*protocols.GrpcClient).Bench(ctx, opts)
+	if err != nil {
+		return err
+	}
+	printBenchResult(result)
+	return nil
+}
+
+func printBenchResult(result *protocols.BenchResult) {
+	fmt.Printf("method:     %s\n", result.Method)
+	fmt.Printf("requests:   %d in %v, %d errors\n", result.Total, result.Duration, result.Errors)
+	fmt.Printf("throughput: %.2f/s\n", result.Throughput)
+	fmt.Println("latency:")
+	lat := result.Latency
+	for _, item := range []struct {
+		name  string
+		value time.Duration
+	}{{"min", lat.Min}, {"mean", lat.Mean}, {"p50", lat.P50}, {"p90", lat.P90}, {"p95", lat.P95}, {"p99", lat.P99}, {"max", lat.Max}} {
+		fmt.Printf("  %-5s %v\n", item.name, item.value)
+	}
+	fmt.Println("status codes:")
+	codes := make([]string, 0, len(result.Codes))
+	for code := range result.Codes {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	for _, code := range codes {
+		fmt.Printf("  %-18s %d\n", code, result.Codes[code])
+	}
+}
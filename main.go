@@ -2,18 +2,48 @@ package main
 
 import (
 	"flag"
+	"os"
+	"time"
 
 	"github.com/feiyuw/simgo/logger"
 	"github.com/feiyuw/simgo/ops"
+	"github.com/feiyuw/simgo/ops/server"
+	"github.com/feiyuw/simgo/storage"
 )
 
 var (
-	addr = ""
+	addr        = ""
+	storageType = ""
+	dataDir     = ""
+	scenario    = ""
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "bench" {
+		if err := runBench(os.Args[2:]); err != nil {
+			logger.Fatal("main", err)
+		}
+		return
+	}
+
 	flag.StringVar(&addr, "addr", ":1777", "OPS addr")
+	flag.StringVar(&storageType, "storage", "file", "storage of servers and clients, file or memory")
+	flag.StringVar(&dataDir, "data", "./data", "data directory of file storage")
+	flag.StringVar(&scenario, "scenario", "", "scenario file in yaml or json, loaded after restored from storage, restored servers and clients are skipped")
+	flag.DurationVar(&server.ScriptTimeout, "script-timeout", 5*time.Second, "running time limit of javascript handlers")
 	flag.Parse()
+	st, err := storage.New(storageType, dataDir)
+	if err != nil {
+		logger.Fatal("main", err)
+	}
+	if err = ops.Restore(st); err != nil {
+		logger.Fatal("main", err)
+	}
+	if scenario != "" {
+		if err = ops.LoadScenarioFile(scenario); err != nil {
+			logger.Fatal("main", err)
+		}
+	}
 	logger.Infof("main", "start OPS on %s", addr)
 	ops.Start(addr)
 }
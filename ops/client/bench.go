@@ -0,0 +1,38 @@
+package client
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/feiyuw/simgo/protocols"
+)
+
+type benchRequest struct {
+	ClientID uint64 `json:"clientId"`
+	protocols.BenchOptions
+}
+
+// drive a method of gRPC client for a duration and return statistics,
+// it is canceled if the request is closed by caller
+func Bench(c echo.Context) error {
+	req := new(benchRequest)
+	if err := c.Bind(req); err != nil {
+		return err
+	}
+
+	client, err := clientStorage.FindOne(req.ClientID)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, "client not found!")
+	}
+	gc, ok := client.RpcClient.(*protocols.GrpcClient)
+	if !ok {
+		return c.JSON(http.StatusBadRequest, "benchmark is supported by grpc clients only")
+	}
+
+	result, err := gc.Bench(c.Request().Context(), &req.BenchOptions)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+	return c.JSON(http.StatusOK, result)
+}
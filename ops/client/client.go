@@ -1,6 +1,7 @@
 package client
 
 import (
+	"fmt"
 	"net/http"
 
 	"github.com/labstack/echo/v4"
@@ -24,13 +25,27 @@ func New(c echo.Context) error {
 		return err
 	}
 
+	client.Id = 0 // always assign a new ID
+
+	if err := createClient(client); err != nil {
+		return err
+	}
+	client.save()
+	return c.JSON(http.StatusOK, nil)
+}
+
+// create rpc client of the definition
+func createClient(client *Client) error {
 	rpcClient, err := protocols.NewRpcClient(client.Protocol, client.Server, client.Options)
 	if err != nil {
 		return err
 	}
 	client.RpcClient = rpcClient
-	clientStorage.Add(client)
-	return c.JSON(http.StatusOK, nil)
+	if _, err = clientStorage.Add(client); err != nil {
+		rpcClient.Close()
+		return err
+	}
+	return nil
 }
 
 func Delete(c echo.Context) error {
@@ -38,17 +53,72 @@ func Delete(c echo.Context) error {
 	if err != nil {
 		return c.String(http.StatusBadRequest, "incorrect clientId")
 	}
-	if err := clientStorage.Remove(clientId); err != nil {
+	if err := Remove(clientId); err != nil {
 		return err
 	}
 
 	return c.JSON(http.StatusOK, nil)
 }
 
+// close client and remove it
+func Remove(clientId uint64) error {
+	return clientStorage.Remove(clientId)
+}
+
+// definition of client, used in scenario
+type Definition struct {
+	Protocol string                 `json:"protocol"`
+	Server   string                 `json:"server"`
+	Options  map[string]interface{} `json:"options,omitempty"`
+}
+
+// create client of the definition
+func Load(def *Definition) (uint64, error) {
+	client := &Client{Protocol: def.Protocol, Server: def.Server, Options: def.Options}
+	if err := createClient(client); err != nil {
+		return 0, fmt.Errorf("failed to create client of %s: %v", def.Server, err)
+	}
+	client.save()
+	return client.Id, nil
+}
+
+// ID of client of the protocol and server, false if not exists
+func Find(protocol string, server string) (uint64, bool) {
+	clients, _ := clientStorage.FindAll()
+	for _, client := range clients {
+		if client.Protocol == protocol && client.Server == server {
+			return client.Id, true
+		}
+	}
+	return 0, false
+}
+
+// definitions of all clients in ID order
+func Export() ([]*Definition, error) {
+	clients, err := clientStorage.FindAll()
+	if err != nil {
+		return nil, err
+	}
+
+	defs := make([]*Definition, len(clients))
+	for idx, client := range clients {
+		defs[idx] = &Definition{Protocol: client.Protocol, Server: client.Server, Options: client.Options}
+	}
+	return defs, nil
+}
+
 type rpcRequest struct {
 	ClientID uint64 `json:"clientId"`
 	Method   string `json:"method"`
 	Data     string `json:"data"`
+	// call options of gRPC clients, response headers, trailers and status are returned with them
+	Options *protocols.InvokeOptions `json:"options"`
+}
+
+// error response of invoke, result of gRPC clients is set if the error occurred before the call finished
+type invokeError struct {
+	Error  string                `json:"error"`
+	Result *protocols.GrpcResult `json:"result,omitempty"`
 }
 
 func Invoke(c echo.Context) error {
@@ -59,12 +129,20 @@ func Invoke(c echo.Context) error {
 
 	client, err := clientStorage.FindOne(req.ClientID)
 	if err != nil {
-		return c.JSON(http.StatusNotFound, "client not found!")
+		return c.JSON(http.StatusNotFound, &invokeError{Error: "client not found!"})
+	}
+
+	if gc, ok := client.RpcClient.(*protocols.GrpcClient); ok {
+		result, err := gc.Invoke(req.Method, req.Data, req.Options)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, &invokeError{Error: err.Error(), Result: protocols.NewGrpcErrorResult(err)})
+		}
+		return c.JSON(http.StatusOK, result)
 	}
 
 	resp, err := client.RpcClient.InvokeRPC(req.Method, req.Data)
 	if err != nil {
-		return c.JSON(http.StatusBadRequest, err.Error())
+		return c.JSON(http.StatusBadRequest, &invokeError{Error: err.Error()})
 	}
 
 	return c.JSON(http.StatusOK, resp)
@@ -8,11 +8,15 @@ import (
 	"strings"
 	"sync"
 	"testing"
+	"time"
 
 	"bou.ke/monkey"
 	"github.com/feiyuw/simgo/protocols"
+	"github.com/feiyuw/simgo/storage"
+	"github.com/jhump/protoreflect/dynamic"
 	"github.com/labstack/echo/v4"
 	. "github.com/smartystreets/goconvey/convey"
+	"google.golang.org/grpc"
 )
 
 type mockClient struct {
@@ -118,4 +122,138 @@ func TestClientRESTAPIs(t *testing.T) {
 		So(rec.Code, ShouldEqual, http.StatusOK)
 		So(rec.Body.String(), ShouldEqual, "null\n")
 	})
+
+	Convey("http client e2e test", t, func() {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"path": "` + r.URL.Path + `"}`))
+		}))
+		defer ts.Close()
+
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/clients", strings.NewReader(`{"server":"`+ts.URL+`","protocol":"http","options":{"timeout":"1s"}}`))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		So(New(e.NewContext(req, rec)), ShouldBeNil)
+		So(rec.Code, ShouldEqual, http.StatusOK)
+		clients, _ := clientStorage.FindAll()
+		clientId := clients[len(clients)-1].Id
+		defer clientStorage.Remove(clientId)
+
+		req = httptest.NewRequest(http.MethodPost, "/api/v1/clients/invoke", strings.NewReader(`{"clientId":`+strconv.FormatUint(clientId, 10)+`,"method":"GET /users/1","data":""}`))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec = httptest.NewRecorder()
+		So(Invoke(e.NewContext(req, rec)), ShouldBeNil)
+		So(rec.Code, ShouldEqual, http.StatusOK)
+		resp := map[string]interface{}{}
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		So(resp["status"], ShouldEqual, 200)
+		So(resp["body"].(map[string]interface{})["path"], ShouldEqual, "/users/1")
+	})
+
+	Convey("invoke errors are in the same shape", t, func() {
+		s, _ := protocols.NewGrpcServer(":5202", []string{"../../protocols/helloworld.proto"})
+		s.Start()
+		defer s.Close()
+		gc, err := protocols.NewGrpcClient("127.0.0.1:5202", []string{"../../protocols/helloworld.proto"}, grpc.WithInsecure())
+		So(err, ShouldBeNil)
+		clientId, _ := clientStorage.Add(&Client{Protocol: "grpc", Server: "127.0.0.1:5202", RpcClient: gc})
+		defer clientStorage.Remove(clientId)
+		invoke := func(body string) (int, *invokeError) {
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/clients/invoke", strings.NewReader(body))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			rec := httptest.NewRecorder()
+			So(Invoke(e.NewContext(req, rec)), ShouldBeNil)
+			resp := new(invokeError)
+			json.Unmarshal(rec.Body.Bytes(), resp)
+			return rec.Code, resp
+		}
+
+		code, resp := invoke(`{"clientId":` + strconv.FormatUint(clientId, 10) + `,"method":"helloworld.Greeter.Unknown","data":"{}"}`)
+		So(code, ShouldEqual, http.StatusBadRequest)
+		So(resp.Error, ShouldContainSubstring, `method named "Unknown"`)
+		So(resp.Result, ShouldNotBeNil)
+		So(resp.Result.Message, ShouldEqual, resp.Error)
+
+		code, resp = invoke(`{"clientId":99999,"method":"helloworld.Greeter.SayHello","data":"{}"}`)
+		So(code, ShouldEqual, http.StatusNotFound)
+		So(resp.Error, ShouldEqual, "client not found!")
+		So(resp.Result, ShouldBeNil)
+	})
+
+	Convey("restore clients from storage", t, func() {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		defer ts.Close()
+
+		st := storage.NewMemoryStorage()
+		st.Save("clients", 100, &clientRecord{Id: 100, Protocol: "http", Server: ts.URL})
+		st.Save("clients", 101, &clientRecord{Id: 101, Protocol: "unknown", Server: ts.URL})
+		So(Restore(st), ShouldBeNil)
+		defer clientStorage.Remove(100)
+		client, err := clientStorage.FindOne(100)
+		So(err, ShouldBeNil)
+		So(client.RpcClient, ShouldNotBeNil)
+		_, err = clientStorage.FindOne(101)
+		So(err, ShouldNotBeNil)
+
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/clients", strings.NewReader(`{"id":100,"server":"`+ts.URL+`","protocol":"http"}`))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		So(New(e.NewContext(req, rec)), ShouldBeNil)
+		clients, _ := clientStorage.FindAll()
+		newId := clients[len(clients)-1].Id
+		defer clientStorage.Remove(newId)
+		// IDs of failed clients are not reused, and they are kept in storage
+		So(newId, ShouldBeGreaterThan, 101)
+		values, _ := st.LoadAll("clients")
+		So(len(values), ShouldEqual, 3)
+
+		clientStorage.Remove(100)
+		values, _ = st.LoadAll("clients")
+		So(len(values), ShouldEqual, 2)
+	})
+}
+
+func TestBenchAPI(t *testing.T) {
+	e := echo.New()
+	protos := []string{"../../protocols/echo.proto"}
+	s, _ := protocols.NewGrpcServer(":5201", protos)
+	s.SetMethodHandler("grpc.examples.echo.Echo.UnaryEcho", func(in *dynamic.Message, out *dynamic.Message, stream grpc.ServerStream) error {
+		out.SetFieldByName("message", in.GetFieldByName("message"))
+		return nil
+	})
+	s.Start()
+	defer s.Close()
+	time.Sleep(time.Millisecond) // make sure server started
+
+	gc, _ := protocols.NewGrpcClient("127.0.0.1:5201", protos, grpc.WithInsecure())
+	clientId, _ := clientStorage.Add(&Client{Protocol: "grpc", Server: "127.0.0.1:5201", RpcClient: gc})
+	defer clientStorage.Remove(clientId)
+	cid := strconv.FormatUint(clientId, 10)
+
+	bench := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/clients/bench", strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		So(Bench(e.NewContext(req, rec)), ShouldBeNil)
+		return rec
+	}
+
+	Convey("bench gRPC method", t, func() {
+		rec := bench(`{"clientId":` + cid + `,"method":"grpc.examples.echo.Echo.UnaryEcho","data":{"message":"{{.Seq}}"},"rps":50,"concurrency":2,"duration":"100ms"}`)
+		So(rec.Code, ShouldEqual, http.StatusOK)
+		result := new(protocols.BenchResult)
+		json.Unmarshal(rec.Body.Bytes(), result)
+		So(result.Total, ShouldBeGreaterThan, 0)
+		So(result.Codes["OK"], ShouldEqual, result.Total)
+
+		rec = bench(`{"clientId":` + cid + `,"method":"grpc.examples.echo.Echo.UnaryEcho","duration":"x"}`)
+		So(rec.Code, ShouldEqual, http.StatusBadRequest)
+	})
+
+	Convey("bench of non gRPC client is not allowed", t, func() {
+		id, _ := clientStorage.Add(&Client{Protocol: "dubbo", Server: "127.0.0.1:1238", RpcClient: &mockClient{}})
+		defer clientStorage.Remove(id)
+		rec := bench(`{"clientId":` + strconv.FormatUint(id, 10) + `,"method":"hello","duration":"1s"}`)
+		So(rec.Code, ShouldEqual, http.StatusBadRequest)
+	})
 }
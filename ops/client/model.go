@@ -1,17 +1,30 @@
 package client
 
 import (
+	"encoding/json"
 	"errors"
 	"sort"
 	"sync"
 	"sync/atomic"
+	"time"
 
+	"github.com/feiyuw/simgo/logger"
 	"github.com/feiyuw/simgo/protocols"
+	"github.com/feiyuw/simgo/storage"
+)
+
+const (
+	storageKind = "clients"
 )
 
 var (
 	clientStorage = &clients{M: map[uint64]*Client{}}
 	nextClientID  uint64
+	// definitions of clients are saved here, set by Restore
+	store storage.Storage = storage.NewMemoryStorage()
+
+	sessionStorage = &sessions{M: map[uint64]*Session{}}
+	nextSessionID  uint64
 )
 
 type Client struct {
@@ -22,20 +35,53 @@ type Client struct {
 	RpcClient protocols.RpcClient
 }
 
+// persisted definition of client
+type clientRecord struct {
+	Id       uint64                 `json:"id"`
+	Protocol string                 `json:"protocol"`
+	Server   string                 `json:"server"`
+	Options  map[string]interface{} `json:"options"`
+}
+
+// save definition of client, error is logged only
+func (c *Client) save() {
+	record := &clientRecord{Id: c.Id, Protocol: c.Protocol, Server: c.Server, Options: c.Options}
+	if err := store.Save(storageKind, c.Id, record); err != nil {
+		logger.Errorf("ops/client", "failed to save client %d: %v", c.Id, err)
+	}
+}
+
 type clients struct {
 	sync.RWMutex
 
 	M map[uint64]*Client
 }
 
+// add client, a new ID is assigned if its ID is 0
 func (c *clients) Add(value *Client) (uint64, error) {
 	c.Lock()
 	defer c.Unlock()
-	value.Id = atomic.AddUint64(&nextClientID, 1)
+	if value.Id == 0 {
+		value.Id = atomic.AddUint64(&nextClientID, 1)
+	} else if _, exists := c.M[value.Id]; exists {
+		return 0, errors.New("client ID exists")
+	} else {
+		reserveClientID(value.Id)
+	}
 	c.M[value.Id] = value
 	return value.Id, nil
 }
 
+// make sure new IDs are greater than id
+func reserveClientID(id uint64) {
+	for {
+		next := atomic.LoadUint64(&nextClientID)
+		if id <= next || atomic.CompareAndSwapUint64(&nextClientID, next, id) {
+			return
+		}
+	}
+}
+
 func (c *clients) Remove(key uint64) error {
 	c.RLock()
 	client, exists := c.M[key]
@@ -47,7 +93,40 @@ func (c *clients) Remove(key uint64) error {
 				return err
 			}
 		}
+		c.Lock()
 		delete(c.M, key)
+		c.Unlock()
+		sessionStorage.RemoveByClient(key)
+		if err := store.Remove(storageKind, key); err != nil {
+			logger.Errorf("ops/client", "failed to remove client %d from storage: %v", key, err)
+		}
+	}
+	return nil
+}
+
+// restore clients from storage, changes are saved to it later,
+// clients failed to connect are logged with their definitions and kept in storage, so they are restored again by the next start
+func Restore(st storage.Storage) error {
+	store = st
+	values, err := st.LoadAll(storageKind)
+	if err != nil {
+		return err
+	}
+
+	for _, value := range values {
+		record := new(clientRecord)
+		if err := json.Unmarshal(value, record); err != nil {
+			logger.Errorf("ops/client", "invalid client definition: %v", err)
+			continue
+		}
+		// new clients should not get IDs of stored ones, even if failed to connect
+		reserveClientID(record.Id)
+		client := &Client{Id: record.Id, Protocol: record.Protocol, Server: record.Server, Options: record.Options}
+		if err := createClient(client); err != nil {
+			logger.Errorf("ops/client", "failed to restore client %d, kept in storage: %v, definition: %s", record.Id, err, value)
+			continue
+		}
+		logger.Infof("ops/client", "client %d restored", client.Id)
 	}
 	return nil
 }
@@ -77,3 +156,88 @@ func (c *clients) FindOne(key uint64) (*Client, error) {
 	}
 	return nil, errors.New("not found")
 }
+
+// interactive gRPC call of a client, received messages and the end of it are pushed to events
+type Session struct {
+	Id       uint64 `json:"id"`
+	ClientID uint64 `json:"clientId"`
+	Method   string `json:"method"`
+
+	session *protocols.GrpcSession
+	events  chan *sessionEvent
+	quit    chan struct{} // closed when session removed
+	ttl     time.Duration // removed after it when finished, see FinishedSessionTTL
+}
+
+type sessionEvent struct {
+	Type    string                 `json:"type"` // message, end or error
+	Message map[string]interface{} `json:"message,omitempty"`
+	Result  *protocols.GrpcResult  `json:"result,omitempty"` // result of the end
+	Error   string                 `json:"error,omitempty"`
+}
+
+type sessions struct {
+	sync.RWMutex
+
+	M map[uint64]*Session
+}
+
+func (s *sessions) Add(value *Session) uint64 {
+	s.Lock()
+	defer s.Unlock()
+	value.Id = atomic.AddUint64(&nextSessionID, 1)
+	s.M[value.Id] = value
+	return value.Id
+}
+
+// cancel session and remove it
+func (s *sessions) Remove(key uint64) {
+	s.Lock()
+	session, exists := s.M[key]
+	delete(s.M, key)
+	s.Unlock()
+
+	if exists {
+		session.session.Cancel()
+		close(session.quit)
+	}
+}
+
+// remove all sessions of client
+func (s *sessions) RemoveByClient(clientID uint64) {
+	s.RLock()
+	keys := []uint64{}
+	for key, session := range s.M {
+		if session.ClientID == clientID {
+			keys = append(keys, key)
+		}
+	}
+	s.RUnlock()
+
+	for _, key := range keys {
+		s.Remove(key)
+	}
+}
+
+func (s *sessions) FindAll() []*Session {
+	s.RLock()
+	defer s.RUnlock()
+	items := make([]*Session, 0, len(s.M))
+	for _, v := range s.M {
+		items = append(items, v)
+	}
+	sort.Slice(items, func(idx1, idx2 int) bool {
+		return items[idx1].Id < items[idx2].Id
+	})
+
+	return items
+}
+
+func (s *sessions) FindOne(key uint64) (*Session, error) {
+	s.RLock()
+	defer s.RUnlock()
+	if v, exists := s.M[key]; exists {
+		return v, nil
+	}
+	return nil, errors.New("not found")
+}
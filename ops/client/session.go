@@ -0,0 +1,251 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"golang.org/x/net/websocket"
+
+	"github.com/feiyuw/simgo/logger"
+	"github.com/feiyuw/simgo/protocols"
+	"github.com/feiyuw/simgo/utils"
+)
+
+const (
+	defaultRecvTimeout = 5 * time.Second
+)
+
+var (
+	// finished sessions are removed when their end is received, or after this time if not received
+	FinishedSessionTTL = time.Minute
+)
+
+type sessionRequest struct {
+	ClientID uint64                   `json:"clientId"`
+	Method   string                   `json:"method"`
+	Options  *protocols.InvokeOptions `json:"options"`
+}
+
+// message sent to session, by REST or websocket
+type sessionCommand struct {
+	SessionID uint64          `json:"sessionId"`
+	Action    string          `json:"action"` // send, closeSend or cancel, used by websocket only
+	Data      json.RawMessage `json:"data"`   // request message, a json object or a string of it
+}
+
+// open a session of gRPC client
+func OpenSession(c echo.Context) error {
+	req := new(sessionRequest)
+	if err := c.Bind(req); err != nil {
+		return err
+	}
+
+	client, err := clientStorage.FindOne(req.ClientID)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, "client not found!")
+	}
+	gc, ok := client.RpcClient.(*protocols.GrpcClient)
+	if !ok {
+		return c.String(http.StatusBadRequest, "invalid protocol")
+	}
+	gs, err := gc.OpenSession(req.Method, req.Options)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+
+	session := &Session{
+		ClientID: req.ClientID,
+		Method:   gs.Method,
+		session:  gs,
+		events:   make(chan *sessionEvent, 100),
+		quit:     make(chan struct{}),
+		ttl:      FinishedSessionTTL,
+	}
+	sessionStorage.Add(session)
+	go session.receive()
+
+	return c.JSON(http.StatusOK, session)
+}
+
+func QuerySessions(c echo.Context) error {
+	return c.JSON(http.StatusOK, sessionStorage.FindAll())
+}
+
+// cancel session and remove it
+func DeleteSession(c echo.Context) error {
+	sessionId, err := utils.AtoUint64(c.QueryParam("id"))
+	if err != nil {
+		return c.String(http.StatusBadRequest, "incorrect sessionId")
+	}
+	sessionStorage.Remove(sessionId)
+
+	return c.JSON(http.StatusOK, nil)
+}
+
+// send one request message
+func SendSessionMessage(c echo.Context) error {
+	cmd := new(sessionCommand)
+	if err := c.Bind(cmd); err != nil {
+		return err
+	}
+	session, err := sessionStorage.FindOne(cmd.SessionID)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, "session not found!")
+	}
+	if err := session.send(cmd.Data); err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+
+	return c.JSON(http.StatusOK, nil)
+}
+
+// half-close session, no more request message can be sent
+func CloseSendSession(c echo.Context) error {
+	cmd := new(sessionCommand)
+	if err := c.Bind(cmd); err != nil {
+		return err
+	}
+	session, err := sessionStorage.FindOne(cmd.SessionID)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, "session not found!")
+	}
+	if err := session.session.CloseSend(); err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+
+	return c.JSON(http.StatusOK, nil)
+}
+
+// receive the next event of session, wait at most timeout(5s by default), no content returned if nothing received
+func RecvSessionMessage(c echo.Context) error {
+	sessionId, err := utils.AtoUint64(c.QueryParam("id"))
+	if err != nil {
+		return c.String(http.StatusBadRequest, "incorrect sessionId")
+	}
+	timeout := defaultRecvTimeout
+	if s := c.QueryParam("timeout"); s != "" {
+		if timeout, err = time.ParseDuration(s); err != nil {
+			return c.String(http.StatusBadRequest, "incorrect timeout")
+		}
+	}
+	session, err := sessionStorage.FindOne(sessionId)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, "session not found!")
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request().Context(), timeout)
+	defer cancel()
+	event, ok := session.next(ctx.Done())
+	if !ok {
+		return c.NoContent(http.StatusNoContent)
+	}
+	return c.JSON(http.StatusOK, event)
+}
+
+// drive session by websocket, commands like {"action": "send", "data": {...}} are received,
+// and events of session are pushed until the end
+func SessionWebSocket(c echo.Context) error {
+	sessionId, err := utils.AtoUint64(c.QueryParam("id"))
+	if err != nil {
+		return c.String(http.StatusBadRequest, "incorrect sessionId")
+	}
+	session, err := sessionStorage.FindOne(sessionId)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, "session not found!")
+	}
+
+	websocket.Handler(func(ws *websocket.Conn) {
+		defer ws.Close()
+		ctx, cancel := context.WithCancel(c.Request().Context())
+		defer cancel()
+
+		// session -> websocket
+		go func() {
+			defer cancel()
+			for {
+				event, ok := session.next(ctx.Done())
+				if !ok {
+					return
+				}
+				if err := websocket.JSON.Send(ws, event); err != nil || event.Type != "message" {
+					return
+				}
+			}
+		}()
+
+		// websocket -> session
+		for {
+			cmd := new(sessionCommand)
+			if err := websocket.JSON.Receive(ws, cmd); err != nil {
+				return
+			}
+			if err := session.handle(cmd); err != nil {
+				websocket.JSON.Send(ws, &sessionEvent{Type: "error", Error: err.Error()})
+			}
+		}
+	}).ServeHTTP(c.Response(), c.Request())
+	return nil
+}
+
+// push received messages and the end to events, session is removed after its ttl when finished
+func (s *Session) receive() {
+	defer close(s.events)
+	for {
+		msg, err := s.session.Recv()
+		if err != nil {
+			logger.Infof("ops/client", "session %d of %s finished: %v", s.Id, s.Method, err)
+			time.AfterFunc(s.ttl, func() { sessionStorage.Remove(s.Id) })
+			return
+		}
+		select {
+		case s.events <- &sessionEvent{Type: "message", Message: msg}:
+		case <-s.quit:
+			return
+		}
+	}
+}
+
+// next event of session, false returned if stop closed before any event,
+// session is removed when its end is returned
+func (s *Session) next(stop <-chan struct{}) (*sessionEvent, bool) {
+	select {
+	case event, ok := <-s.events:
+		if ok {
+			return event, true
+		}
+		sessionStorage.Remove(s.Id)
+		return &sessionEvent{Type: "end", Result: s.session.Result()}, true
+	case <-s.quit:
+		return &sessionEvent{Type: "error", Error: "session removed"}, true
+	case <-stop:
+		return nil, false
+	}
+}
+
+func (s *Session) send(data json.RawMessage) error {
+	var str string
+
+	// string of json object is allowed, like data of rpcRequest
+	if err := json.Unmarshal(data, &str); err == nil {
+		return s.session.Send(str)
+	}
+	return s.session.Send([]byte(data))
+}
+
+func (s *Session) handle(cmd *sessionCommand) error {
+	switch cmd.Action {
+	case "send":
+		return s.send(cmd.Data)
+	case "closeSend":
+		return s.session.CloseSend()
+	case "cancel":
+		s.session.Cancel()
+		return nil
+	default:
+		return fmt.Errorf("unknown action: %s", cmd.Action)
+	}
+}
@@ -0,0 +1,184 @@
+package client
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/feiyuw/simgo/protocols"
+	"github.com/jhump/protoreflect/dynamic"
+	"github.com/labstack/echo/v4"
+	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/net/websocket"
+	"google.golang.org/grpc"
+)
+
+func TestSessionAPIs(t *testing.T) {
+	e := echo.New()
+	protos := []string{"../../protocols/echo.proto"}
+	s, _ := protocols.NewGrpcServer(":5200", protos)
+	s.SetMethodHandler("grpc.examples.echo.Echo.BidirectionalStreamingEcho", func(in *dynamic.Message, out *dynamic.Message, stream grpc.ServerStream) error {
+		for {
+			if err := stream.RecvMsg(in); err == io.EOF {
+				return nil
+			} else if err != nil {
+				return err
+			}
+			out.SetFieldByName("message", "echo "+in.GetFieldByName("message").(string))
+			if err := stream.SendMsg(out); err != nil {
+				return err
+			}
+		}
+	})
+	s.Start()
+	defer s.Close()
+	time.Sleep(time.Millisecond) // make sure server started
+
+	gc, _ := protocols.NewGrpcClient("127.0.0.1:5200", protos, grpc.WithInsecure())
+	clientId, _ := clientStorage.Add(&Client{Protocol: "grpc", Server: "127.0.0.1:5200", RpcClient: gc})
+	defer clientStorage.Remove(clientId)
+
+	openSession := func() uint64 {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/clients/sessions", strings.NewReader(`{"clientId":`+strconv.FormatUint(clientId, 10)+`,"method":"grpc.examples.echo.Echo.BidirectionalStreamingEcho"}`))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		So(OpenSession(e.NewContext(req, rec)), ShouldBeNil)
+		So(rec.Code, ShouldEqual, http.StatusOK)
+		session := new(Session)
+		json.Unmarshal(rec.Body.Bytes(), session)
+		So(session.Method, ShouldEqual, "grpc.examples.echo.Echo.BidirectionalStreamingEcho")
+		return session.Id
+	}
+	post := func(path, body string, handler echo.HandlerFunc) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		So(handler(e.NewContext(req, rec)), ShouldBeNil)
+		return rec
+	}
+	recv := func(sessionId uint64, timeout string) (*httptest.ResponseRecorder, *sessionEvent) {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/clients/sessions/recv?id="+strconv.FormatUint(sessionId, 10)+"&timeout="+timeout, nil)
+		rec := httptest.NewRecorder()
+		So(RecvSessionMessage(e.NewContext(req, rec)), ShouldBeNil)
+		event := new(sessionEvent)
+		json.Unmarshal(rec.Body.Bytes(), event)
+		return rec, event
+	}
+
+	Convey("drive session by REST APIs", t, func() {
+		sessionId := openSession()
+		defer sessionStorage.Remove(sessionId)
+		sid := strconv.FormatUint(sessionId, 10)
+
+		rec, _ := recv(sessionId, "10ms")
+		So(rec.Code, ShouldEqual, http.StatusNoContent)
+
+		rec = post("/api/v1/clients/sessions/send", `{"sessionId":`+sid+`,"data":{"message":"a"}}`, SendSessionMessage)
+		So(rec.Code, ShouldEqual, http.StatusOK)
+		rec, event := recv(sessionId, "1s")
+		So(rec.Code, ShouldEqual, http.StatusOK)
+		So(event.Type, ShouldEqual, "message")
+		So(event.Message["message"], ShouldEqual, "echo a")
+
+		rec = post("/api/v1/clients/sessions/send", `{"sessionId":`+sid+`,"data":"{\"message\":\"b\"}"}`, SendSessionMessage)
+		So(rec.Code, ShouldEqual, http.StatusOK)
+		_, event = recv(sessionId, "1s")
+		So(event.Message["message"], ShouldEqual, "echo b")
+
+		rec = post("/api/v1/clients/sessions/send", `{"sessionId":`+sid+`,"data":{"unknown":"b"}}`, SendSessionMessage)
+		So(rec.Code, ShouldEqual, http.StatusBadRequest)
+
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/clients/sessions", nil)
+		rec = httptest.NewRecorder()
+		So(QuerySessions(e.NewContext(req, rec)), ShouldBeNil)
+		sessions := []*Session{}
+		json.Unmarshal(rec.Body.Bytes(), &sessions)
+		So(len(sessions), ShouldEqual, 1)
+
+		rec = post("/api/v1/clients/sessions/close-send", `{"sessionId":`+sid+`}`, CloseSendSession)
+		So(rec.Code, ShouldEqual, http.StatusOK)
+		_, event = recv(sessionId, "1s")
+		So(event.Type, ShouldEqual, "end")
+		So(event.Result.CodeName, ShouldEqual, "OK")
+		So(len(event.Result.Messages), ShouldEqual, 2)
+
+		// removed when the end received
+		So(sessionStorage.FindAll(), ShouldBeEmpty)
+		rec, _ = recv(sessionId, "10ms")
+		So(rec.Code, ShouldEqual, http.StatusNotFound)
+	})
+
+	Convey("delete session", t, func() {
+		sessionId := openSession()
+		req := httptest.NewRequest(http.MethodDelete, "/api/v1/clients/sessions?id="+strconv.FormatUint(sessionId, 10), nil)
+		rec := httptest.NewRecorder()
+		So(DeleteSession(e.NewContext(req, rec)), ShouldBeNil)
+		So(sessionStorage.FindAll(), ShouldBeEmpty)
+		rec, _ = recv(sessionId, "10ms")
+		So(rec.Code, ShouldEqual, http.StatusNotFound)
+	})
+
+	Convey("finished sessions are removed even if the end is not received", t, func() {
+		defer func(ttl time.Duration) { FinishedSessionTTL = ttl }(FinishedSessionTTL)
+		FinishedSessionTTL = 10 * time.Millisecond
+		sessionId := openSession()
+		defer sessionStorage.Remove(sessionId)
+		rec := post("/api/v1/clients/sessions/close-send", `{"sessionId":`+strconv.FormatUint(sessionId, 10)+`}`, CloseSendSession)
+		So(rec.Code, ShouldEqual, http.StatusOK)
+		for idx := 0; idx < 100 && len(sessionStorage.FindAll()) > 0; idx++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+		So(sessionStorage.FindAll(), ShouldBeEmpty)
+	})
+
+	Convey("session of non gRPC client is not allowed", t, func() {
+		id, _ := clientStorage.Add(&Client{Protocol: "dubbo", Server: "127.0.0.1:1237", RpcClient: &mockClient{}})
+		defer clientStorage.Remove(id)
+		rec := post("/api/v1/clients/sessions", `{"clientId":`+strconv.FormatUint(id, 10)+`,"method":"hello"}`, OpenSession)
+		So(rec.Code, ShouldEqual, http.StatusBadRequest)
+	})
+
+	Convey("drive session by websocket", t, func() {
+		sessionId := openSession()
+		defer sessionStorage.Remove(sessionId)
+		e.GET("/api/v1/clients/sessions/ws", SessionWebSocket)
+		ts := httptest.NewServer(e)
+		defer ts.Close()
+
+		ws, err := websocket.Dial("ws"+strings.TrimPrefix(ts.URL, "http")+"/api/v1/clients/sessions/ws?id="+strconv.FormatUint(sessionId, 10), "", ts.URL)
+		So(err, ShouldBeNil)
+		defer ws.Close()
+
+		event := new(sessionEvent)
+		So(websocket.JSON.Send(ws, map[string]interface{}{"action": "send", "data": map[string]interface{}{"message": "a"}}), ShouldBeNil)
+		So(websocket.JSON.Receive(ws, event), ShouldBeNil)
+		So(event.Message["message"], ShouldEqual, "echo a")
+
+		event = new(sessionEvent)
+		So(websocket.JSON.Send(ws, map[string]interface{}{"action": "unknown"}), ShouldBeNil)
+		So(websocket.JSON.Receive(ws, event), ShouldBeNil)
+		So(event.Type, ShouldEqual, "error")
+
+		event = new(sessionEvent)
+		So(websocket.JSON.Send(ws, map[string]interface{}{"action": "closeSend"}), ShouldBeNil)
+		So(websocket.JSON.Receive(ws, event), ShouldBeNil)
+		So(event.Type, ShouldEqual, "end")
+		So(event.Result.Messages[0]["message"], ShouldEqual, "echo a")
+		So(sessionStorage.FindAll(), ShouldBeEmpty)
+	})
+
+	Convey("sessions are removed with client", t, func() {
+		gc2, _ := protocols.NewGrpcClient("127.0.0.1:5200", protos, grpc.WithInsecure())
+		id, _ := clientStorage.Add(&Client{Protocol: "grpc", Server: "127.0.0.1:5200", RpcClient: gc2})
+		rec := post("/api/v1/clients/sessions", `{"clientId":`+strconv.FormatUint(id, 10)+`,"method":"grpc.examples.echo.Echo.BidirectionalStreamingEcho"}`, OpenSession)
+		So(rec.Code, ShouldEqual, http.StatusOK)
+		So(len(sessionStorage.FindAll()), ShouldEqual, 1)
+		clientStorage.Remove(id)
+		So(sessionStorage.FindAll(), ShouldBeEmpty)
+	})
+}
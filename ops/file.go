@@ -1,11 +1,17 @@
 package ops
 
 import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 
 	"github.com/feiyuw/simgo/logger"
 	"github.com/labstack/echo/v4"
@@ -15,6 +21,8 @@ const (
 	UPLOADED_DIR = "./upload"
 )
 
+// upload a file, archives(.zip, .tar, .tar.gz and .tgz) are extracted to a directory,
+// and proto files in it are returned, so that the directory can be used as import path
 func uploadFile(c echo.Context) error {
 	// Source
 	file, err := c.FormFile("file")
@@ -27,6 +35,22 @@ func uploadFile(c echo.Context) error {
 	}
 	defer src.Close()
 
+	if isArchive(file.Filename) {
+		dir, err := ioutil.TempDir(UPLOADED_DIR, file.Filename+".")
+		if err != nil {
+			return err
+		}
+		if err = extractArchive(src, file.Size, file.Filename, dir); err != nil {
+			os.RemoveAll(dir)
+			return c.JSON(http.StatusBadRequest, err.Error())
+		}
+		protos, err := findProtos(dir)
+		if err != nil {
+			return err
+		}
+		return c.JSON(http.StatusOK, map[string]interface{}{"filepath": dir, "protos": protos})
+	}
+
 	// Destination
 	dst, err := ioutil.TempFile(UPLOADED_DIR, "*."+file.Filename)
 	if err != nil {
@@ -42,13 +66,146 @@ func uploadFile(c echo.Context) error {
 	return c.JSON(http.StatusOK, map[string]string{"filepath": dst.Name()})
 }
 
+// remove uploaded file or directory extracted from uploaded archive
 func removeFile(c echo.Context) error {
 	// avoid delete unexpected file
-	filePath := filepath.Join(UPLOADED_DIR, filepath.Base(c.QueryParam("filepath")))
+	filePath, err := uploadedPath(c.QueryParam("filepath"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
 	logger.Warn("ops/files", filePath)
-	if err := os.Remove(filePath); err != nil {
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		if !isExtractedDir(info.Name()) {
+			return c.JSON(http.StatusBadRequest, "only directories extracted from archives can be removed")
+		}
+		err = os.RemoveAll(filePath)
+	} else {
+		err = os.Remove(filePath)
+	}
+	if err != nil {
 		return err
 	}
 
 	return c.JSON(http.StatusOK, nil)
 }
+
+// path of uploaded file in UPLOADED_DIR, only the base name of filePath is used
+func uploadedPath(filePath string) (string, error) {
+	base := filepath.Base(filePath)
+	if base == "." || base == ".." || base == string(os.PathSeparator) {
+		return "", fmt.Errorf("invalid file path: %s", filePath)
+	}
+	target := filepath.Join(UPLOADED_DIR, base)
+	if rel, err := filepath.Rel(UPLOADED_DIR, target); err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return "", fmt.Errorf("invalid file path: %s", filePath)
+	}
+	return target, nil
+}
+
+// whether directory is created for extracting archive, see ioutil.TempDir in uploadFile
+func isExtractedDir(name string) bool {
+	pos := strings.LastIndex(name, ".")
+	return pos > 0 && isArchive(name[:pos])
+}
+
+func isArchive(name string) bool {
+	for _, ext := range []string{".zip", ".tar", ".tar.gz", ".tgz"} {
+		if strings.HasSuffix(name, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// extract zip or tar archive to dir, only regular files and directories are extracted
+func extractArchive(src io.ReaderAt, size int64, name, dir string) error {
+	if strings.HasSuffix(name, ".zip") {
+		zr, err := zip.NewReader(src, size)
+		if err != nil {
+			return fmt.Errorf("invalid zip file: %v", err)
+		}
+		for _, f := range zr.File {
+			if !f.Mode().IsRegular() {
+				continue
+			}
+			rc, err := f.Open()
+			if err != nil {
+				return err
+			}
+			err = extractFile(rc, f.Name, dir)
+			rc.Close()
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	var r io.Reader = io.NewSectionReader(src, 0, size)
+	if strings.HasSuffix(name, "gz") {
+		gr, err := gzip.NewReader(r)
+		if err != nil {
+			return fmt.Errorf("invalid gzip file: %v", err)
+		}
+		defer gr.Close()
+		r = gr
+	}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("invalid tar file: %v", err)
+		}
+		if hdr.Typeflag != tar.TypeReg && hdr.Typeflag != tar.TypeRegA {
+			continue
+		}
+		if err = extractFile(tr, hdr.Name, dir); err != nil {
+			return err
+		}
+	}
+}
+
+// write file of archive to dir, name should not be out of dir
+func extractFile(r io.Reader, name, dir string) error {
+	target := filepath.Join(dir, filepath.FromSlash(name))
+	if !strings.HasPrefix(target, filepath.Clean(dir)+string(os.PathSeparator)) {
+		return fmt.Errorf("invalid file path in archive: %s", name)
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	dst, err := os.Create(target)
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+	_, err = io.Copy(dst, r)
+	return err
+}
+
+// proto files in dir, paths are relative to dir and in slash format
+func findProtos(dir string) ([]string, error) {
+	protos := []string{}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() && strings.HasSuffix(path, ".proto") {
+			rel, err := filepath.Rel(dir, path)
+			if err != nil {
+				return err
+			}
+			protos = append(protos, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	sort.Strings(protos)
+	return protos, err
+}
@@ -0,0 +1,95 @@
+package ops
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+var archivedFiles = map[string]string{
+	"api/user.proto":     `syntax = "proto3"; package api; import "common/types.proto"; service Users { rpc Get(common.Id) returns (common.Id); }`,
+	"common/types.proto": `syntax = "proto3"; package common; message Id { string id = 1; }`,
+	"README.md":          "protos",
+}
+
+func zipArchive(files map[string]string) []byte {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range files {
+		w, _ := zw.Create(name)
+		w.Write([]byte(content))
+	}
+	zw.Close()
+	return buf.Bytes()
+}
+
+func tgzArchive(files map[string]string) []byte {
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gw)
+	tw.WriteHeader(&tar.Header{Name: "api/", Typeflag: tar.TypeDir, Mode: 0755})
+	for name, content := range files {
+		tw.WriteHeader(&tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(content))})
+		tw.Write([]byte(content))
+	}
+	tw.Close()
+	gw.Close()
+	return buf.Bytes()
+}
+
+func TestExtractArchive(t *testing.T) {
+	for _, archive := range []struct {
+		name string
+		data []byte
+	}{{"protos.zip", zipArchive(archivedFiles)}, {"protos.tar.gz", tgzArchive(archivedFiles)}} {
+		Convey("extract "+archive.name, t, func() {
+			dir, _ := ioutil.TempDir("", "simgo")
+			defer os.RemoveAll(dir)
+
+			So(isArchive(archive.name), ShouldBeTrue)
+			So(extractArchive(bytes.NewReader(archive.data), int64(len(archive.data)), archive.name, dir), ShouldBeNil)
+			content, err := ioutil.ReadFile(filepath.Join(dir, "common", "types.proto"))
+			So(err, ShouldBeNil)
+			So(string(content), ShouldEqual, archivedFiles["common/types.proto"])
+			protos, err := findProtos(dir)
+			So(err, ShouldBeNil)
+			So(protos, ShouldResemble, []string{"api/user.proto", "common/types.proto"})
+		})
+	}
+
+	Convey("files out of directory are rejected", t, func() {
+		dir, _ := ioutil.TempDir("", "simgo")
+		defer os.RemoveAll(dir)
+
+		data := zipArchive(map[string]string{"../evil.proto": "x"})
+		So(extractArchive(bytes.NewReader(data), int64(len(data)), "evil.zip", dir), ShouldNotBeNil)
+		data = tgzArchive(map[string]string{"a/../../evil.proto": "x"})
+		So(extractArchive(bytes.NewReader(data), int64(len(data)), "evil.tgz", dir), ShouldNotBeNil)
+		So(extractArchive(bytes.NewReader([]byte("x")), 1, "bad.zip", dir), ShouldNotBeNil)
+		So(isArchive("a.proto"), ShouldBeFalse)
+	})
+}
+
+func TestUploadedPath(t *testing.T) {
+	Convey("only files in upload directory can be removed", t, func() {
+		for _, name := range []string{"", "/", ".", "..", "a/..", "../"} {
+			_, err := uploadedPath(name)
+			So(err, ShouldNotBeNil)
+		}
+		target, err := uploadedPath("../../etc/123.a.proto")
+		So(err, ShouldBeNil)
+		So(target, ShouldEqual, filepath.Join(UPLOADED_DIR, "123.a.proto"))
+
+		So(isExtractedDir("protos.zip.123456"), ShouldBeTrue)
+		So(isExtractedDir("protos.tar.gz.123456"), ShouldBeTrue)
+		So(isExtractedDir("protos.123456"), ShouldBeFalse)
+		So(isExtractedDir("upload"), ShouldBeFalse)
+	})
+}
@@ -7,12 +7,21 @@ import (
 	"github.com/feiyuw/simgo/logger"
 	"github.com/feiyuw/simgo/ops/client"
 	"github.com/feiyuw/simgo/ops/server"
+	"github.com/feiyuw/simgo/storage"
 )
 
 var (
 	opsServer = echo.New()
 )
 
+// restore servers and clients saved in storage, should be called before Start
+func Restore(st storage.Storage) error {
+	if err := server.Restore(st); err != nil {
+		return err
+	}
+	return client.Restore(st)
+}
+
 func Start(addr string) {
 	opsServer.Use(middleware.Logger())
 	opsServer.Use(middleware.Recover())
@@ -26,17 +35,40 @@ func Start(addr string) {
 	opsServer.POST("/api/v1/clients", client.New)
 	opsServer.DELETE("/api/v1/clients", client.Delete)
 	opsServer.POST("/api/v1/clients/invoke", client.Invoke)
+	opsServer.POST("/api/v1/clients/bench", client.Bench)
 	opsServer.GET("/api/v1/clients/grpc/services", client.ListGrpcServices)
 	opsServer.GET("/api/v1/clients/grpc/methods", client.ListGrpcMethods)
+	opsServer.GET("/api/v1/clients/sessions", client.QuerySessions)
+	opsServer.POST("/api/v1/clients/sessions", client.OpenSession)
+	opsServer.DELETE("/api/v1/clients/sessions", client.DeleteSession)
+	opsServer.POST("/api/v1/clients/sessions/send", client.SendSessionMessage)
+	opsServer.POST("/api/v1/clients/sessions/close-send", client.CloseSendSession)
+	opsServer.GET("/api/v1/clients/sessions/recv", client.RecvSessionMessage)
+	opsServer.GET("/api/v1/clients/sessions/ws", client.SessionWebSocket)
 	//	servers
 	opsServer.GET("/api/v1/servers", server.Query)
 	opsServer.POST("/api/v1/servers", server.New)
 	opsServer.DELETE("/api/v1/servers", server.Delete)
 	opsServer.GET("/api/v1/servers/messages", server.FetchMessages)
+	opsServer.DELETE("/api/v1/servers/messages", server.ClearMessages)
+	opsServer.GET("/api/v1/servers/messages/stream", server.StreamMessages)
+	opsServer.GET("/api/v1/servers/messages/export", server.ExportMessages)
+	opsServer.POST("/api/v1/servers/verify", server.VerifyMessages)
+	opsServer.GET("/api/v1/servers/faults", server.ListFaults)
+	opsServer.POST("/api/v1/servers/faults", server.SetFault)
+	opsServer.DELETE("/api/v1/servers/faults", server.DeleteFault)
+	opsServer.GET("/api/v1/servers/health", server.ListServingStatuses)
+	opsServer.POST("/api/v1/servers/health", server.SetServingStatus)
 	opsServer.GET("/api/v1/servers/handlers", server.ListMethodHandlers)
 	opsServer.POST("/api/v1/servers/handlers", server.AddMethodHandler)
 	opsServer.DELETE("/api/v1/servers/handlers", server.DeleteMethodHandler)
 	opsServer.GET("/api/v1/servers/grpc/methods", server.ListGrpcMethods)
+	opsServer.GET("/api/v1/servers/records", server.FetchRecords)
+	opsServer.DELETE("/api/v1/servers/records", server.ClearRecords)
+	opsServer.POST("/api/v1/servers/records/replay", server.ReplayRecords)
+	//	scenarios
+	opsServer.POST("/api/v1/scenarios", loadScenario)
+	opsServer.GET("/api/v1/scenarios", exportScenario)
 	//	other
 	opsServer.POST("/api/v1/files", uploadFile)
 	opsServer.DELETE("/api/v1/files", removeFile)
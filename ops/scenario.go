@@ -0,0 +1,187 @@
+package ops
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"gopkg.in/yaml.v2"
+
+	"github.com/feiyuw/simgo/logger"
+	"github.com/feiyuw/simgo/ops/client"
+	"github.com/feiyuw/simgo/ops/server"
+)
+
+// servers with their method handlers and clients, in yaml or json format, see Scenario in README
+type Scenario struct {
+	Servers []*server.Definition `json:"servers"`
+	Clients []*client.Definition `json:"clients"`
+}
+
+// IDs of servers and clients created by scenario
+type scenarioResult struct {
+	Servers []uint64 `json:"servers"`
+	Clients []uint64 `json:"clients"`
+}
+
+// parse scenario in yaml or json, json is a subset of yaml
+func ParseScenario(content []byte) (*Scenario, error) {
+	var doc interface{}
+
+	if err := yaml.Unmarshal(content, &doc); err != nil {
+		return nil, fmt.Errorf("invalid scenario: %v", err)
+	}
+	// convert to json, so that json tags and types are used
+	b, err := json.Marshal(yamlToJSONValue(doc))
+	if err != nil {
+		return nil, fmt.Errorf("invalid scenario: %v", err)
+	}
+	sc := new(Scenario)
+	if err := json.Unmarshal(b, sc); err != nil {
+		return nil, fmt.Errorf("invalid scenario: %v", err)
+	}
+	return sc, nil
+}
+
+// create servers first and then clients, all created ones are removed if any failed,
+// existing servers of the same name and port and clients of the same server are skipped
+func LoadScenario(sc *Scenario) (*scenarioResult, error) {
+	result := &scenarioResult{Servers: []uint64{}, Clients: []uint64{}}
+	rollback := func() {
+		for _, clientId := range result.Clients {
+			client.Remove(clientId)
+		}
+		for _, serverId := range result.Servers {
+			server.Remove(serverId)
+		}
+	}
+
+	for _, def := range sc.Servers {
+		if def == nil {
+			continue
+		}
+		if serverId, exists := server.Find(def.Name, def.Port); exists {
+			logger.Infof("ops/scenario", "server %s on port %d exists as %d, skipped", def.Name, def.Port, serverId)
+			continue
+		}
+		serverId, err := server.Load(def)
+		if err != nil {
+			rollback()
+			return nil, err
+		}
+		result.Servers = append(result.Servers, serverId)
+	}
+	for _, def := range sc.Clients {
+		if def == nil {
+			continue
+		}
+		if clientId, exists := client.Find(def.Protocol, def.Server); exists {
+			logger.Infof("ops/scenario", "%s client of %s exists as %d, skipped", def.Protocol, def.Server, clientId)
+			continue
+		}
+		clientId, err := client.Load(def)
+		if err != nil {
+			rollback()
+			return nil, err
+		}
+		result.Clients = append(result.Clients, clientId)
+	}
+
+	logger.Infof("ops/scenario", "scenario loaded, %d servers and %d clients created", len(result.Servers), len(result.Clients))
+	return result, nil
+}
+
+// load scenario from yaml or json file
+func LoadScenarioFile(path string) error {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	sc, err := ParseScenario(content)
+	if err != nil {
+		return err
+	}
+	_, err = LoadScenario(sc)
+	return err
+}
+
+// scenario of all servers and clients
+func ExportScenario() (*Scenario, error) {
+	servers, err := server.Export()
+	if err != nil {
+		return nil, err
+	}
+	clients, err := client.Export()
+	if err != nil {
+		return nil, err
+	}
+	return &Scenario{Servers: servers, Clients: clients}, nil
+}
+
+func loadScenario(c echo.Context) error {
+	content, err := ioutil.ReadAll(c.Request().Body)
+	if err != nil {
+		return err
+	}
+	sc, err := ParseScenario(content)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+	result, err := LoadScenario(sc)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+	return c.JSON(http.StatusOK, result)
+}
+
+// export scenario in yaml(default) or json format
+func exportScenario(c echo.Context) error {
+	sc, err := ExportScenario()
+	if err != nil {
+		return err
+	}
+
+	switch format := c.QueryParam("format"); format {
+	case "json":
+		return c.JSON(http.StatusOK, sc)
+	case "", "yaml":
+		// convert from json, so that json tags are used as keys
+		b, err := json.Marshal(sc)
+		if err != nil {
+			return err
+		}
+		var doc interface{}
+		if err = json.Unmarshal(b, &doc); err != nil {
+			return err
+		}
+		out, err := yaml.Marshal(doc)
+		if err != nil {
+			return err
+		}
+		return c.Blob(http.StatusOK, "application/x-yaml", out)
+	default:
+		return c.JSON(http.StatusBadRequest, "unsupported format: "+format)
+	}
+}
+
+// convert yaml maps with interface{} keys to json objects
+func yamlToJSONValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		obj := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			obj[fmt.Sprintf("%v", key)] = yamlToJSONValue(value)
+		}
+		return obj
+	case []interface{}:
+		items := make([]interface{}, len(v))
+		for idx, item := range v {
+			items[idx] = yamlToJSONValue(item)
+		}
+		return items
+	default:
+		return v
+	}
+}
@@ -0,0 +1,194 @@
+package ops
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	. "github.com/smartystreets/goconvey/convey"
+	"google.golang.org/grpc"
+
+	"github.com/feiyuw/simgo/ops/client"
+	"github.com/feiyuw/simgo/ops/server"
+	"github.com/feiyuw/simgo/protocols"
+	"github.com/feiyuw/simgo/storage"
+)
+
+const testScenario = `
+servers:
+  - name: greeter
+    protocol: grpc
+    port: 5100
+    options:
+      protos: [../protocols/helloworld.proto]
+    handlers:
+      - method: helloworld.Greeter.SayHello
+        type: raw
+        content: '{"message": "hello"}'
+  - name: users
+    protocol: http
+    port: 5101
+    handlers:
+      - method: GET /users/:id
+        type: javascript
+        content: |
+          ctx.resp.Body = {"id": ctx.req.Params.id}
+clients:
+  - protocol: http
+    server: 127.0.0.1:5101
+    options:
+      timeout: 1s
+`
+
+func TestScenario(t *testing.T) {
+	e := echo.New()
+
+	Convey("parse scenario in yaml and json", t, func() {
+		sc, err := ParseScenario([]byte(testScenario))
+		So(err, ShouldBeNil)
+		So(len(sc.Servers), ShouldEqual, 2)
+		So(sc.Servers[0].Options["protos"], ShouldResemble, []interface{}{"../protocols/helloworld.proto"})
+		So(sc.Servers[1].Handlers[0].Method, ShouldEqual, "GET /users/:id")
+		So(sc.Clients[0].Options["timeout"], ShouldEqual, "1s")
+
+		sc, err = ParseScenario([]byte(`{"servers": [{"name": "s1", "protocol": "http", "port": 1234}]}`))
+		So(err, ShouldBeNil)
+		So(sc.Servers[0].Port, ShouldEqual, 1234)
+
+		_, err = ParseScenario([]byte(`servers: {name: [}`))
+		So(err, ShouldNotBeNil)
+		_, err = ParseScenario([]byte(`servers: abc`))
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("load scenario and export it", t, func() {
+		sc, _ := ParseScenario([]byte(testScenario))
+		result, err := LoadScenario(sc)
+		So(err, ShouldBeNil)
+		So(len(result.Servers), ShouldEqual, 2)
+		So(len(result.Clients), ShouldEqual, 1)
+		defer removeScenario(result)
+
+		httpClient, _ := protocols.NewHTTPClient("127.0.0.1:5101", time.Second, nil)
+		out, err := httpClient.InvokeRPC("GET /users/3", nil)
+		So(err, ShouldBeNil)
+		So(out.(*protocols.HTTPResponse).Body.(map[string]interface{})["id"], ShouldEqual, "3")
+		grpcClient, err := protocols.NewGrpcClient("127.0.0.1:5100", []string{"../protocols/helloworld.proto"}, grpc.WithInsecure(), grpc.WithBlock())
+		So(err, ShouldBeNil)
+		defer grpcClient.Close()
+		out, err = grpcClient.InvokeRPC("helloworld.Greeter.SayHello", map[string]interface{}{"name": "you"})
+		So(err, ShouldBeNil)
+		So(out.(*protocols.GrpcResult).Messages[0]["message"], ShouldEqual, "hello")
+
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/scenarios", nil)
+		rec := httptest.NewRecorder()
+		So(exportScenario(e.NewContext(req, rec)), ShouldBeNil)
+		So(rec.Code, ShouldEqual, http.StatusOK)
+		exported, err := ParseScenario(rec.Body.Bytes())
+		So(err, ShouldBeNil)
+		expected, _ := ParseScenario([]byte(testScenario))
+		So(exported, ShouldResemble, expected)
+
+		req = httptest.NewRequest(http.MethodGet, "/api/v1/scenarios?format=json", nil)
+		rec = httptest.NewRecorder()
+		So(exportScenario(e.NewContext(req, rec)), ShouldBeNil)
+		So(rec.Body.String(), ShouldStartWith, `{"servers":[{"name":"greeter"`)
+	})
+
+	Convey("failed scenario is rolled back", t, func() {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/scenarios", strings.NewReader(`
+servers:
+  - {name: ok, protocol: http, port: 5102}
+  - {name: bad, protocol: http, port: 5103, handlers: [{method: GET /, type: unknown}]}
+`))
+		rec := httptest.NewRecorder()
+		So(loadScenario(e.NewContext(req, rec)), ShouldBeNil)
+		So(rec.Code, ShouldEqual, http.StatusBadRequest)
+		So(rec.Body.String(), ShouldContainSubstring, "unsupported handler type")
+		servers, _ := server.Export()
+		So(servers, ShouldBeEmpty)
+
+		req = httptest.NewRequest(http.MethodPost, "/api/v1/scenarios", strings.NewReader(`{"servers": [{"name": "ok", "protocol": "http", "port": 5102}]}`))
+		rec = httptest.NewRecorder()
+		So(loadScenario(e.NewContext(req, rec)), ShouldBeNil)
+		So(rec.Code, ShouldEqual, http.StatusOK)
+		servers, _ = server.Export()
+		So(len(servers), ShouldEqual, 1)
+		result := new(scenarioResult)
+		json.Unmarshal(rec.Body.Bytes(), result)
+		So(len(result.Servers), ShouldEqual, 1)
+		removeScenario(result)
+	})
+
+	Convey("load scenario file", t, func() {
+		dir, _ := ioutil.TempDir("", "simgo-scenario")
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "scenario.json")
+		So(LoadScenarioFile(path), ShouldNotBeNil)
+		ioutil.WriteFile(path, []byte(`{"clients": [{"protocol": "http", "server": "127.0.0.1:5104"}]}`), 0644)
+		So(LoadScenarioFile(path), ShouldBeNil)
+		clients, _ := client.Export()
+		So(len(clients), ShouldEqual, 1)
+		So(clients[0].Server, ShouldEqual, "127.0.0.1:5104")
+	})
+
+	Convey("restart with storage and scenario", t, func() {
+		dir, _ := ioutil.TempDir("", "simgo-restart")
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "scenario.yaml")
+		ioutil.WriteFile(path, []byte(testScenario), 0644)
+		st, _ := storage.New("file", filepath.Join(dir, "data"))
+		So(Restore(st), ShouldBeNil)
+		defer Restore(storage.NewMemoryStorage())
+		So(LoadScenarioFile(path), ShouldBeNil)
+
+		// stop servers and clients without touching saved definitions, and start again
+		backup := filepath.Join(dir, "backup")
+		So(os.Rename(filepath.Join(dir, "data"), backup), ShouldBeNil)
+		servers, _ := server.Export()
+		So(len(servers), ShouldEqual, 2)
+		removeAll()
+		So(os.RemoveAll(filepath.Join(dir, "data")), ShouldBeNil)
+		So(os.Rename(backup, filepath.Join(dir, "data")), ShouldBeNil)
+		st, _ = storage.New("file", filepath.Join(dir, "data"))
+		So(Restore(st), ShouldBeNil)
+		defer removeAll()
+		So(LoadScenarioFile(path), ShouldBeNil)
+
+		exported, err := ExportScenario()
+		So(err, ShouldBeNil)
+		expected, _ := ParseScenario([]byte(testScenario))
+		So(exported, ShouldResemble, expected)
+	})
+}
+
+// remove all servers and clients
+func removeAll() {
+	sc, _ := ExportScenario()
+	result := &scenarioResult{}
+	for _, def := range sc.Servers {
+		serverId, _ := server.Find(def.Name, def.Port)
+		result.Servers = append(result.Servers, serverId)
+	}
+	for _, def := range sc.Clients {
+		clientId, _ := client.Find(def.Protocol, def.Server)
+		result.Clients = append(result.Clients, clientId)
+	}
+	removeScenario(result)
+}
+
+func removeScenario(result *scenarioResult) {
+	for _, clientId := range result.Clients {
+		client.Remove(clientId)
+	}
+	for _, serverId := range result.Servers {
+		server.Remove(serverId)
+	}
+}
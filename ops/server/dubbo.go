@@ -0,0 +1,44 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/feiyuw/simgo/protocols"
+)
+
+// create dubbo method handler with handler type and content,
+// raw: json of return value, java object is like {"@class": "com.foo.User", "name": "you"},
+// error: message of the exception thrown,
+// javascript: script run with ctx, see script.run, ctx.req is the request, ctx.resp is the response
+func newDubboMethodHandler(mtd, handlerType, content string, counters *scriptCounters) (func(req *protocols.DubboRequest, resp *protocols.DubboResponse) error, error) {
+	switch handlerType {
+	case "raw":
+		var value interface{}
+		if err := json.Unmarshal([]byte(content), &value); err != nil {
+			return nil, fmt.Errorf("invalid raw value: %v", err)
+		}
+		return func(req *protocols.DubboRequest, resp *protocols.DubboResponse) error {
+			resp.Value = value
+			return nil
+		}, nil
+	case "error":
+		if content == "" {
+			return nil, errors.New("exception message should not be empty")
+		}
+		return func(req *protocols.DubboRequest, resp *protocols.DubboResponse) error {
+			return errors.New(content)
+		}, nil
+	case "javascript":
+		js, err := newScript(mtd, content, counters)
+		if err != nil {
+			return nil, err
+		}
+		return func(req *protocols.DubboRequest, resp *protocols.DubboResponse) error {
+			return js.run(map[string]interface{}{"req": req, "resp": resp})
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported handler type: %s", handlerType)
+	}
+}
@@ -0,0 +1,76 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/feiyuw/simgo/protocols"
+	"github.com/feiyuw/simgo/utils"
+
+	"github.com/labstack/echo/v4"
+)
+
+type faultRequest struct {
+	ServerID uint64 `json:"serverId"`
+	Method   string `json:"method"` // protocols.AllMethods for methods without their own faults
+	protocols.Fault
+}
+
+// grpc server of id, a response is written and nil returned if not found or not a grpc server
+func findGrpcServer(c echo.Context, serverId uint64) (*protocols.GrpcServer, error) {
+	server, err := serverStorage.FindOne(serverId)
+	if err != nil {
+		return nil, c.JSON(http.StatusNotFound, "server not found!")
+	}
+	gs, ok := server.RpcServer.(*protocols.GrpcServer)
+	if !ok {
+		return nil, c.JSON(http.StatusBadRequest, "only grpc servers are supported")
+	}
+	return gs, nil
+}
+
+func ListFaults(c echo.Context) error {
+	serverId, err := utils.AtoUint64(c.QueryParam("serverId"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, "incorrect server ID")
+	}
+	gs, err := findGrpcServer(c, serverId)
+	if gs == nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, gs.Faults())
+}
+
+// set faults of a method at runtime, they are not saved with server
+func SetFault(c echo.Context) error {
+	req := new(faultRequest)
+	if err := c.Bind(req); err != nil {
+		return err
+	}
+	if req.Method == "" {
+		return c.JSON(http.StatusBadRequest, "method should not be empty")
+	}
+	gs, err := findGrpcServer(c, req.ServerID)
+	if gs == nil {
+		return err
+	}
+
+	if err := gs.SetFault(req.Method, &req.Fault); err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+	return c.JSON(http.StatusOK, nil)
+}
+
+func DeleteFault(c echo.Context) error {
+	serverId, err := utils.AtoUint64(c.QueryParam("serverId"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, "incorrect server ID")
+	}
+	gs, err := findGrpcServer(c, serverId)
+	if gs == nil {
+		return err
+	}
+
+	gs.RemoveFault(c.QueryParam("method"))
+	return c.JSON(http.StatusOK, nil)
+}
@@ -1,13 +1,23 @@
 package server
 
 import (
+	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/feiyuw/simgo/protocols"
 	"github.com/feiyuw/simgo/utils"
 
+	"github.com/jhump/protoreflect/dynamic"
 	"github.com/labstack/echo/v4"
+	"github.com/robertkrimen/otto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
 )
 
 func ListGrpcMethods(c echo.Context) error {
@@ -31,3 +41,166 @@ func ListGrpcMethods(c echo.Context) error {
 
 	return c.JSON(http.StatusOK, methods)
 }
+
+// create grpc method rules from handler rules, handler type and content are used as fallback rule
+func newGrpcMethodRules(handler *MethodHandler, counters *scriptCounters) ([]*protocols.MethodRule, error) {
+	rules := make([]*protocols.MethodRule, 0, len(handler.Rules)+1)
+
+	for idx, rule := range handler.Rules {
+		grpcHandler, err := newGrpcMethodHandler(handler.Method, rule.Type, rule.Content, counters)
+		if err != nil {
+			return nil, fmt.Errorf("rule %d: %v", idx, err)
+		}
+		rules = append(rules, &protocols.MethodRule{Matcher: rule.Match, Handler: grpcHandler})
+	}
+	if handler.Type == "replay" {
+		records := []*protocols.GrpcRecord{}
+		if err := json.Unmarshal([]byte(handler.Content), &records); err != nil {
+			return nil, fmt.Errorf("invalid records: %v", err)
+		}
+		if len(records) == 0 {
+			return nil, errors.New("no records to replay")
+		}
+		replayRules, err := protocols.NewReplayRules(records)
+		if err != nil {
+			return nil, err
+		}
+		return append(rules, replayRules...), nil
+	}
+	if handler.Type != "" || len(handler.Rules) == 0 {
+		grpcHandler, err := newGrpcMethodHandler(handler.Method, handler.Type, handler.Content, counters)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, &protocols.MethodRule{Handler: grpcHandler})
+	}
+
+	return rules, nil
+}
+
+// create grpc method handler with handler type and content,
+// raw: json content used as out message, javascript: script run with ctx, see script.run,
+// error: json content of grpc status, see protocols.ParseStatus
+func newGrpcMethodHandler(mtd, handlerType, content string, counters *scriptCounters) (func(in *dynamic.Message, out *dynamic.Message, stream grpc.ServerStream) error, error) {
+	switch handlerType {
+	case "raw":
+		return func(in *dynamic.Message, out *dynamic.Message, stream grpc.ServerStream) error {
+			resp := make(map[string]interface{})
+			if err := json.Unmarshal([]byte(content), &resp); err != nil {
+				return err
+			}
+			for k, v := range resp {
+				out.SetFieldByName(k, v)
+			}
+			return nil
+		}, nil
+	case "javascript":
+		js, err := newScript(mtd, content, counters)
+		if err != nil {
+			return nil, err
+		}
+		return func(in *dynamic.Message, out *dynamic.Message, stream grpc.ServerStream) error {
+			var statusErr error
+
+			reqCtx := stream.Context()
+			md, _ := metadata.FromIncomingContext(reqCtx)
+			peerAddr := ""
+			if p, ok := peer.FromContext(reqCtx); ok {
+				peerAddr = p.Addr.String()
+			}
+			deadline := ""
+			if d, ok := reqCtx.Deadline(); ok {
+				deadline = d.Format(time.RFC3339Nano)
+			}
+
+			ctx := map[string]interface{}{
+				"in":       in,
+				"out":      out,
+				"stream":   stream,
+				"metadata": map[string][]string(md), // incoming metadata, eg. ctx.metadata["authorization"][0]
+				"peer":     peerAddr,
+				"deadline": deadline, // in RFC3339 format, empty if no deadline
+				// set response headers or trailers, eg. ctx.SetHeader({"x-request-id": "1", "x-tags": ["a", "b"]})
+				"SetHeader": func(call otto.FunctionCall) otto.Value {
+					header, err := exportMetadata(call.Argument(0))
+					if err != nil {
+						panic(call.Otto.MakeTypeError(err.Error()))
+					}
+					if err = stream.SetHeader(header); err != nil {
+						panic(call.Otto.MakeCustomError("Error", err.Error()))
+					}
+					return otto.UndefinedValue()
+				},
+				"SetTrailer": func(call otto.FunctionCall) otto.Value {
+					trailer, err := exportMetadata(call.Argument(0))
+					if err != nil {
+						panic(call.Otto.MakeTypeError(err.Error()))
+					}
+					stream.SetTrailer(trailer)
+					return otto.UndefinedValue()
+				},
+				// return grpc status error, eg. ctx.Error("NOT_FOUND", "user not found", [{"@type": "type.googleapis.com/google.rpc.ResourceInfo", "resourceName": "xxx"}])
+				"Error": func(call otto.FunctionCall) otto.Value {
+					content, err := json.Marshal(map[string]interface{}{
+						"code":    exportValue(call.Argument(0)),
+						"message": exportValue(call.Argument(1)),
+						"details": exportValue(call.Argument(2)),
+					})
+					if err != nil {
+						panic(call.Otto.MakeTypeError(err.Error()))
+					}
+					st, err := protocols.ParseStatus(content)
+					if err != nil {
+						panic(call.Otto.MakeTypeError(err.Error()))
+					}
+					statusErr = st.Err()
+					return otto.UndefinedValue()
+				},
+			}
+			if err := js.run(ctx); err != nil {
+				return status.Error(codes.Internal, err.Error())
+			}
+
+			return statusErr
+		}, nil
+	case "error":
+		st, err := protocols.ParseStatus([]byte(content))
+		if err != nil {
+			return nil, err
+		}
+		return func(in *dynamic.Message, out *dynamic.Message, stream grpc.ServerStream) error {
+			return st.Err()
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported handler type: %s", handlerType)
+	}
+}
+
+func exportValue(v otto.Value) interface{} {
+	exported, _ := v.Export()
+	return exported
+}
+
+// convert javascript object to metadata, values can be string or array of strings
+func exportMetadata(v otto.Value) (metadata.MD, error) {
+	md := metadata.MD{}
+	obj, ok := exportValue(v).(map[string]interface{})
+	if !ok {
+		return nil, errors.New("metadata should be an object")
+	}
+	for k, value := range obj {
+		switch value := value.(type) {
+		case string:
+			md.Append(k, value)
+		case []string:
+			md.Append(k, value...)
+		case []interface{}:
+			for _, item := range value {
+				md.Append(k, fmt.Sprintf("%v", item))
+			}
+		default:
+			md.Append(k, fmt.Sprintf("%v", value))
+		}
+	}
+	return md, nil
+}
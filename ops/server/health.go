@@ -0,0 +1,46 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/feiyuw/simgo/utils"
+
+	"github.com/labstack/echo/v4"
+)
+
+type healthRequest struct {
+	ServerID uint64 `json:"serverId"`
+	Service  string `json:"service"` // empty for the whole server
+	Status   string `json:"status"`  // SERVING, NOT_SERVING or SERVICE_UNKNOWN
+}
+
+// serving statuses reported by health service of grpc server
+func ListServingStatuses(c echo.Context) error {
+	serverId, err := utils.AtoUint64(c.QueryParam("serverId"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, "incorrect server ID")
+	}
+	gs, err := findGrpcServer(c, serverId)
+	if gs == nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, gs.ServingStatuses())
+}
+
+// set serving status at runtime, it is not saved with server
+func SetServingStatus(c echo.Context) error {
+	req := new(healthRequest)
+	if err := c.Bind(req); err != nil {
+		return err
+	}
+	gs, err := findGrpcServer(c, req.ServerID)
+	if gs == nil {
+		return err
+	}
+
+	if err := gs.SetServingStatus(req.Service, req.Status); err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+	return c.JSON(http.StatusOK, nil)
+}
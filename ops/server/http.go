@@ -0,0 +1,41 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/feiyuw/simgo/protocols"
+)
+
+// create http method handler with handler type and content,
+// raw: json of response, eg. {"status": 200, "headers": {"X-Id": "1"}, "body": {"message": "hello"}},
+// javascript: script run with ctx, see script.run, ctx.req is the request, ctx.resp is the response
+func newHTTPMethodHandler(mtd, handlerType, content string, counters *scriptCounters) (func(req *protocols.HTTPServerRequest, resp *protocols.HTTPResponse) error, error) {
+	switch handlerType {
+	case "raw":
+		raw := new(protocols.HTTPResponse)
+		if err := json.Unmarshal([]byte(content), raw); err != nil {
+			return nil, fmt.Errorf("invalid raw response: %v", err)
+		}
+		return func(req *protocols.HTTPServerRequest, resp *protocols.HTTPResponse) error {
+			if raw.Status != 0 {
+				resp.Status = raw.Status
+			}
+			for k, v := range raw.Headers {
+				resp.Headers[k] = v
+			}
+			resp.Body = raw.Body
+			return nil
+		}, nil
+	case "javascript":
+		js, err := newScript(mtd, content, counters)
+		if err != nil {
+			return nil, err
+		}
+		return func(req *protocols.HTTPServerRequest, resp *protocols.HTTPResponse) error {
+			return js.run(map[string]interface{}{"req": req, "resp": resp})
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported handler type: %s", handlerType)
+	}
+}
@@ -1,11 +1,27 @@
 package server
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
 	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/feiyuw/simgo/logger"
+	"github.com/feiyuw/simgo/utils"
 )
 
 const (
 	MSGSIZE = 1000
+	// messages buffered for each live feed, newer messages are dropped if the feed is too slow
+	FEEDSIZE = 1000
+	// interval of keepalive comments in live feed
+	feedKeepalive = 15 * time.Second
 )
 
 type Message struct {
@@ -15,12 +31,39 @@ type Message struct {
 	To        string `json:"to"`
 	Ts        int64  `json:"ts"`
 	Body      string `json:"body"`
+	Seq       int    `json:"seq"` // sequence number in stream
+}
+
+// get retention of messages from server options messageSize and messageAge, eg. {"messageSize": 100, "messageAge": "1h"},
+// MSGSIZE messages are kept by default and they never expire
+func parseRetention(options map[string]interface{}) (int, time.Duration, error) {
+	size, age := MSGSIZE, time.Duration(0)
+
+	if v, exists := options["messageSize"]; exists && v != nil {
+		n, ok := v.(float64)
+		if !ok || n <= 0 || n != float64(int(n)) {
+			return 0, 0, errors.New("option messageSize should be a positive integer")
+		}
+		size = int(n)
+	}
+	if v, exists := options["messageAge"]; exists && v != nil {
+		s, ok := v.(string)
+		if !ok {
+			return 0, 0, errors.New("option messageAge should be a duration")
+		}
+		d, err := time.ParseDuration(s)
+		if err != nil || d < 0 {
+			return 0, 0, fmt.Errorf("option messageAge should be a duration: %s", s)
+		}
+		age = d
+	}
+	return size, age, nil
 }
 
-func newMessageRecorder(server *Server) func(mtd, direction, from, to, body string) error {
-	server.Messages = make([]*Message, 0, MSGSIZE)
+func newMessageRecorder(server *Server) func(mtd, direction, from, to, body string, seq int) error {
+	server.Messages = make([]*Message, 0, server.msgSize)
 
-	return func(mtd, direction, from, to, body string) error {
+	return func(mtd, direction, from, to, body string, seq int) error {
 		msg := &Message{
 			Method:    mtd,
 			Direction: direction,
@@ -28,27 +71,326 @@ func newMessageRecorder(server *Server) func(mtd, direction, from, to, body stri
 			To:        to,
 			Ts:        time.Now().UnixNano() / int64(time.Millisecond),
 			Body:      body,
+			Seq:       seq,
 		}
 		server.Lock()
 		defer server.Unlock()
-		if len(server.Messages) >= MSGSIZE {
-			copy(server.Messages[1:], server.Messages[0:MSGSIZE-1])
+		if len(server.Messages) >= server.msgSize {
+			copy(server.Messages[1:], server.Messages[0:server.msgSize-1])
 			server.Messages[0] = msg
 		} else {
 			server.Messages = append([]*Message{msg}, server.Messages...)
 		}
+		server.expireMessages()
+		for feed, filter := range server.feeds {
+			if !filter.match(msg) {
+				continue
+			}
+			select {
+			case feed <- msg:
+			default:
+				logger.Warnf("ops/server", "live feed of server %d is full, message of %s dropped", server.Id, msg.Method)
+			}
+		}
 		return nil
 	}
 }
 
-func queryMessages(server *Server, skip, limit int) []*Message {
-	msgCnt := len(server.Messages)
+// drop messages older than retention age, server should be locked
+func (s *Server) expireMessages() {
+	if s.msgAge <= 0 {
+		return
+	}
+	deadline := time.Now().Add(-s.msgAge).UnixNano() / int64(time.Millisecond)
+	cnt := len(s.Messages)
+	for cnt > 0 && s.Messages[cnt-1].Ts < deadline {
+		cnt--
+	}
+	s.Messages = s.Messages[:cnt]
+}
+
+// filter of messages, empty fields match all
+type messageFilter struct {
+	Method    string
+	Direction string
+	Peer      string // substring of from or to
+	Body      string // substring of body
+	Since     int64  // timestamp in milliseconds, inclusive
+	Until     int64  // timestamp in milliseconds, exclusive
+}
+
+func newMessageFilter(c echo.Context) (*messageFilter, error) {
+	var err error
+
+	mf := &messageFilter{
+		Method:    c.QueryParam("method"),
+		Direction: c.QueryParam("direction"),
+		Peer:      c.QueryParam("peer"),
+		Body:      c.QueryParam("body"),
+	}
+	if since := c.QueryParam("since"); since != "" {
+		if mf.Since, err = strconv.ParseInt(since, 10, 64); err != nil {
+			return nil, errors.New("incorrect since")
+		}
+	}
+	if until := c.QueryParam("until"); until != "" {
+		if mf.Until, err = strconv.ParseInt(until, 10, 64); err != nil {
+			return nil, errors.New("incorrect until")
+		}
+	}
+	return mf, nil
+}
+
+func (mf *messageFilter) match(msg *Message) bool {
+	if mf.Method != "" && mf.Method != msg.Method {
+		return false
+	}
+	if mf.Direction != "" && mf.Direction != msg.Direction {
+		return false
+	}
+	if mf.Peer != "" && !strings.Contains(msg.From, mf.Peer) && !strings.Contains(msg.To, mf.Peer) {
+		return false
+	}
+	if mf.Body != "" && !strings.Contains(msg.Body, mf.Body) {
+		return false
+	}
+	if mf.Since > 0 && msg.Ts < mf.Since {
+		return false
+	}
+	if mf.Until > 0 && msg.Ts >= mf.Until {
+		return false
+	}
+	return true
+}
+
+// messages matched filter, newest first
+func queryMessages(server *Server, filter *messageFilter, skip, limit int) []*Message {
+	server.Lock()
+	defer server.Unlock()
+	server.expireMessages()
 
-	if msgCnt <= skip {
-		return []*Message{}
-	} else if msgCnt <= skip+limit {
-		return server.Messages[skip:msgCnt]
-	} else {
-		return server.Messages[skip : skip+limit]
+	msgs := []*Message{}
+	for _, msg := range server.Messages {
+		if !filter.match(msg) {
+			continue
+		}
+		if skip > 0 {
+			skip--
+			continue
+		}
+		if len(msgs) >= limit {
+			break
+		}
+		msgs = append(msgs, msg)
+	}
+	return msgs
+}
+
+func ClearMessages(c echo.Context) error {
+	serverId, err := utils.AtoUint64(c.QueryParam("serverId"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, "incorrect server ID")
+	}
+	server, err := serverStorage.FindOne(serverId)
+	if err != nil {
+		return err
+	}
+
+	(&receivedSource{server}).ClearReceived()
+	return c.JSON(http.StatusOK, nil)
+}
+
+// export messages matched filters in oldest first order, format can be jsonl(default) or har
+func ExportMessages(c echo.Context) error {
+	serverId, err := utils.AtoUint64(c.QueryParam("serverId"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, "incorrect server ID")
+	}
+	server, err := serverStorage.FindOne(serverId)
+	if err != nil {
+		return err
+	}
+	filter, err := newMessageFilter(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+
+	msgs := queryMessages(server, filter, 0, server.msgSize)
+	for i, j := 0, len(msgs)-1; i < j; i, j = i+1, j-1 {
+		msgs[i], msgs[j] = msgs[j], msgs[i]
+	}
+	filename := fmt.Sprintf("server_%d_messages", server.Id)
+
+	switch format := c.QueryParam("format"); format {
+	case "", "jsonl":
+		var buf bytes.Buffer
+		encoder := json.NewEncoder(&buf)
+		for _, msg := range msgs {
+			if err := encoder.Encode(msg); err != nil {
+				return err
+			}
+		}
+		c.Response().Header().Set(echo.HeaderContentDisposition, "attachment; filename="+filename+".jsonl")
+		return c.Blob(http.StatusOK, "application/x-ndjson", buf.Bytes())
+	case "har":
+		c.Response().Header().Set(echo.HeaderContentDisposition, "attachment; filename="+filename+".har")
+		return c.JSON(http.StatusOK, newHAR(msgs))
+	default:
+		return c.JSON(http.StatusBadRequest, "unsupported format: "+format)
+	}
+}
+
+// HAR like document, each entry is a request and its response
+type har struct {
+	Log harLog `json:"log"`
+}
+
+type harLog struct {
+	Version string      `json:"version"`
+	Creator harCreator  `json:"creator"`
+	Entries []*harEntry `json:"entries"`
+}
+
+type harCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type harEntry struct {
+	StartedDateTime string      `json:"startedDateTime"`
+	Time            int64       `json:"time"` // milliseconds from request to response, -1 if no request or response
+	Method          string      `json:"method"`
+	Request         *harMessage `json:"request"`
+	Response        *harMessage `json:"response"`
+}
+
+type harMessage struct {
+	From    string     `json:"from"`
+	To      string     `json:"to"`
+	Seq     int        `json:"seq"`
+	Content harContent `json:"content"`
+}
+
+type harContent struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+}
+
+// pair each out message with the earliest unanswered in message of the same method and peer,
+// messages should be in oldest first order
+func newHAR(msgs []*Message) *har {
+	entries := []*harEntry{}
+	pending := map[string][]*harEntry{}
+
+	for _, msg := range msgs {
+		hm := &harMessage{From: msg.From, To: msg.To, Seq: msg.Seq, Content: harContent{MimeType: "application/json", Text: msg.Body}}
+		if msg.Direction == "in" {
+			key := msg.Method + " " + msg.From
+			entry := &harEntry{StartedDateTime: msTime(msg.Ts), Time: -1, Method: msg.Method, Request: hm}
+			entries = append(entries, entry)
+			pending[key] = append(pending[key], entry)
+			continue
+		}
+		key := msg.Method + " " + msg.To
+		if queue := pending[key]; len(queue) > 0 {
+			entry := queue[0]
+			pending[key] = queue[1:]
+			entry.Response = hm
+			if started, err := time.Parse(time.RFC3339Nano, entry.StartedDateTime); err == nil {
+				entry.Time = msg.Ts - started.UnixNano()/int64(time.Millisecond)
+			}
+			continue
+		}
+		entries = append(entries, &harEntry{StartedDateTime: msTime(msg.Ts), Time: -1, Method: msg.Method, Response: hm})
+	}
+
+	return &har{Log: harLog{Version: "1.2", Creator: harCreator{Name: "simgo", Version: "1.0"}, Entries: entries}}
+}
+
+// RFC3339 time of timestamp in milliseconds
+func msTime(ts int64) string {
+	return time.Unix(0, ts*int64(time.Millisecond)).Format(time.RFC3339Nano)
+}
+
+// subscribe messages matched filter, the channel is closed when server removed
+func (s *Server) subscribe(filter *messageFilter) chan *Message {
+	feed := make(chan *Message, FEEDSIZE)
+	s.Lock()
+	defer s.Unlock()
+	if s.feeds == nil {
+		s.feeds = map[chan *Message]*messageFilter{}
+	}
+	s.feeds[feed] = filter
+	return feed
+}
+
+func (s *Server) unsubscribe(feed chan *Message) {
+	s.Lock()
+	defer s.Unlock()
+	if _, exists := s.feeds[feed]; exists {
+		delete(s.feeds, feed)
+		close(feed)
+	}
+}
+
+// close all live feeds
+func (s *Server) closeFeeds() {
+	s.Lock()
+	defer s.Unlock()
+	for feed := range s.feeds {
+		close(feed)
+	}
+	s.feeds = nil
+}
+
+// push messages of server as Server-Sent Events until client disconnected or server removed,
+// filtered like FetchMessages
+func StreamMessages(c echo.Context) error {
+	serverId, err := utils.AtoUint64(c.QueryParam("serverId"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, "incorrect server ID")
+	}
+	server, err := serverStorage.FindOne(serverId)
+	if err != nil {
+		return err
+	}
+
+	filter, err := newMessageFilter(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+	feed := server.subscribe(filter)
+	defer server.unsubscribe(feed)
+
+	resp := c.Response()
+	resp.Header().Set(echo.HeaderContentType, "text/event-stream")
+	resp.Header().Set("Cache-Control", "no-cache")
+	resp.Header().Set("Connection", "keep-alive")
+	resp.WriteHeader(http.StatusOK)
+	resp.Flush()
+
+	ticker := time.NewTicker(feedKeepalive)
+	defer ticker.Stop()
+	for {
+		select {
+		case msg, ok := <-feed:
+			if !ok {
+				return nil
+			}
+			data, err := json.Marshal(msg)
+			if err != nil {
+				return err
+			}
+			if _, err := fmt.Fprintf(resp, "event: message\ndata: %s\n\n", data); err != nil {
+				return nil
+			}
+		case <-ticker.C:
+			if _, err := fmt.Fprint(resp, ": keepalive\n\n"); err != nil {
+				return nil
+			}
+		case <-c.Request().Context().Done():
+			return nil
+		}
+		resp.Flush()
 	}
 }
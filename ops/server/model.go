@@ -1,17 +1,27 @@
 package server
 
 import (
+	"encoding/json"
 	"errors"
 	"sort"
 	"sync"
 	"sync/atomic"
+	"time"
 
+	"github.com/feiyuw/simgo/logger"
 	"github.com/feiyuw/simgo/protocols"
+	"github.com/feiyuw/simgo/storage"
+)
+
+const (
+	storageKind = "servers"
 )
 
 var (
 	serverStorage = &servers{M: map[uint64]*Server{}}
 	nextServerID  uint64
+	// definitions of servers are saved here, set by Restore
+	store storage.Storage = storage.NewMemoryStorage()
 )
 
 type Server struct {
@@ -26,6 +36,58 @@ type Server struct {
 	RpcServer      protocols.RpcServer
 	Messages       []*Message
 	MethodHandlers map[string]*MethodHandler
+	Records        []*protocols.GrpcRecord `json:"-"` // calls forwarded to backend by grpc proxy
+
+	feeds    map[chan *Message]*messageFilter // live feeds of messages
+	counters map[string]*scriptCounters       // counters of javascript handlers by method
+	msgSize  int                              // max number of messages kept
+	msgAge   time.Duration                    // messages older than it are dropped, never if 0
+}
+
+// persisted definition of server
+type serverRecord struct {
+	Id             uint64                    `json:"id"`
+	Name           string                    `json:"name"`
+	Protocol       string                    `json:"protocol"`
+	Port           int                       `json:"port"`
+	Options        map[string]interface{}    `json:"options"`
+	MethodHandlers map[string]*MethodHandler `json:"methodHandlers"`
+}
+
+// counters of javascript handlers of method, released when the method handler is removed
+func (s *Server) methodCounters(mtd string) *scriptCounters {
+	s.Lock()
+	defer s.Unlock()
+	if s.counters == nil {
+		s.counters = map[string]*scriptCounters{}
+	}
+	counters, exists := s.counters[mtd]
+	if !exists {
+		counters = newScriptCounters()
+		s.counters[mtd] = counters
+	}
+	return counters
+}
+
+// save definition of server, error is logged only
+func (s *Server) save() {
+	s.RLock()
+	record := &serverRecord{
+		Id:             s.Id,
+		Name:           s.Name,
+		Protocol:       s.Protocol,
+		Port:           s.Port,
+		Options:        s.Options,
+		MethodHandlers: make(map[string]*MethodHandler, len(s.MethodHandlers)),
+	}
+	for mtd, handler := range s.MethodHandlers {
+		record.MethodHandlers[mtd] = handler
+	}
+	s.RUnlock()
+
+	if err := store.Save(storageKind, s.Id, record); err != nil {
+		logger.Errorf("ops/server", "failed to save server %d: %v", s.Id, err)
+	}
 }
 
 type servers struct {
@@ -34,14 +96,31 @@ type servers struct {
 	M map[uint64]*Server
 }
 
+// add server, a new ID is assigned if its ID is 0
 func (s *servers) Add(value *Server) (uint64, error) {
 	s.Lock()
 	defer s.Unlock()
-	value.Id = atomic.AddUint64(&nextServerID, 1)
+	if value.Id == 0 {
+		value.Id = atomic.AddUint64(&nextServerID, 1)
+	} else if _, exists := s.M[value.Id]; exists {
+		return 0, errors.New("server ID exists")
+	} else {
+		reserveServerID(value.Id)
+	}
 	s.M[value.Id] = value
 	return value.Id, nil
 }
 
+// make sure new IDs are greater than id
+func reserveServerID(id uint64) {
+	for {
+		next := atomic.LoadUint64(&nextServerID)
+		if id <= next || atomic.CompareAndSwapUint64(&nextServerID, next, id) {
+			return
+		}
+	}
+}
+
 func (s *servers) Remove(key uint64) error {
 	s.RLock()
 	server, exists := s.M[key]
@@ -53,7 +132,47 @@ func (s *servers) Remove(key uint64) error {
 				return err
 			}
 		}
+		s.Lock()
 		delete(s.M, key)
+		s.Unlock()
+		server.closeFeeds()
+		if err := store.Remove(storageKind, key); err != nil {
+			logger.Errorf("ops/server", "failed to remove server %d from storage: %v", key, err)
+		}
+	}
+	return nil
+}
+
+// restore servers and their method handlers from storage, changes are saved to it later,
+// servers failed to restore are logged with their definitions and kept in storage, so they are restored again by the next start
+func Restore(st storage.Storage) error {
+	store = st
+	values, err := st.LoadAll(storageKind)
+	if err != nil {
+		return err
+	}
+
+	for _, value := range values {
+		record := new(serverRecord)
+		if err := json.Unmarshal(value, record); err != nil {
+			logger.Errorf("ops/server", "invalid server definition: %v", err)
+			continue
+		}
+		// new servers should not get IDs of stored ones, even if failed to restore
+		reserveServerID(record.Id)
+		server := &Server{Id: record.Id, Name: record.Name, Protocol: record.Protocol, Port: record.Port, Options: record.Options}
+		if _, err := createServer(server); err != nil {
+			logger.Errorf("ops/server", "failed to restore server %d, kept in storage: %v, definition: %s", record.Id, err, value)
+			continue
+		}
+		for mtd, handler := range record.MethodHandlers {
+			handler.ServerID = server.Id
+			handler.Method = mtd
+			if err := setMethodHandler(server, handler); err != nil {
+				logger.Errorf("ops/server", "failed to restore handler %s of server %d: %v", mtd, server.Id, err)
+			}
+		}
+		logger.Infof("ops/server", "server %d restored with %d handlers", server.Id, len(server.MethodHandlers))
 	}
 	return nil
 }
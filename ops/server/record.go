@@ -0,0 +1,150 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/feiyuw/simgo/protocols"
+	"github.com/feiyuw/simgo/utils"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	RECORDSIZE = 1000
+)
+
+// save calls forwarded by grpc proxy, the oldest one is dropped if full
+func newGrpcRecorder(server *Server) func(record *protocols.GrpcRecord) {
+	server.Records = make([]*protocols.GrpcRecord, 0, RECORDSIZE)
+
+	return func(record *protocols.GrpcRecord) {
+		server.Lock()
+		defer server.Unlock()
+		if len(server.Records) >= RECORDSIZE {
+			copy(server.Records, server.Records[1:])
+			server.Records[RECORDSIZE-1] = record
+		} else {
+			server.Records = append(server.Records, record)
+		}
+	}
+}
+
+// records of server in recorded order, filtered by method if set
+func FetchRecords(c echo.Context) error {
+	serverId, err := utils.AtoUint64(c.QueryParam("serverId"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, "incorrect server ID")
+	}
+	server, err := serverStorage.FindOne(serverId)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, findRecords(server, c.QueryParam("method")))
+}
+
+func ClearRecords(c echo.Context) error {
+	serverId, err := utils.AtoUint64(c.QueryParam("serverId"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, "incorrect server ID")
+	}
+	server, err := serverStorage.FindOne(serverId)
+	if err != nil {
+		return err
+	}
+
+	server.Lock()
+	server.Records = server.Records[:0]
+	server.Unlock()
+	return c.JSON(http.StatusOK, nil)
+}
+
+type replayRequest struct {
+	ServerID uint64   `json:"serverId"` // server has the records
+	TargetID uint64   `json:"targetId"` // server to replay records, the same server if not set
+	Methods  []string `json:"methods"`  // methods to replay, all recorded methods if empty
+}
+
+// add replay handlers to target server with records, existing handlers of the methods are replaced
+func ReplayRecords(c echo.Context) error {
+	req := new(replayRequest)
+	if err := c.Bind(req); err != nil {
+		return err
+	}
+	server, err := serverStorage.FindOne(req.ServerID)
+	if err != nil {
+		return err
+	}
+	if req.TargetID == 0 {
+		req.TargetID = req.ServerID
+	}
+	target, err := serverStorage.FindOne(req.TargetID)
+	if err != nil {
+		return err
+	}
+	if target.Protocol != "grpc" {
+		return c.JSON(http.StatusBadRequest, "incorrect protocol")
+	}
+
+	methods := req.Methods
+	if len(methods) == 0 {
+		methods = recordedMethods(server)
+	}
+	// build all handlers before any is replaced, so that a failed method leaves the target unchanged
+	handlers := make([]*MethodHandler, len(methods))
+	rulesM := make(map[string][]*protocols.MethodRule, len(methods))
+	for idx, mtd := range methods {
+		records := findRecords(server, mtd)
+		if len(records) == 0 {
+			return c.JSON(http.StatusBadRequest, "no records of method "+mtd)
+		}
+		content, err := json.Marshal(records)
+		if err != nil {
+			return err
+		}
+		handlers[idx] = &MethodHandler{ServerID: target.Id, Method: mtd, Type: "replay", Content: string(content)}
+		if rulesM[mtd], err = newGrpcMethodRules(handlers[idx], target.methodCounters(mtd)); err != nil {
+			return c.JSON(http.StatusBadRequest, err.Error())
+		}
+	}
+	// existing rules are replaced
+	if err := target.RpcServer.(*protocols.GrpcServer).SetMethodsRules(rulesM); err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+	target.Lock()
+	for _, handler := range handlers {
+		target.MethodHandlers[handler.Method] = handler
+	}
+	target.Unlock()
+	target.save()
+
+	return c.JSON(http.StatusOK, methods)
+}
+
+func findRecords(server *Server, mtd string) []*protocols.GrpcRecord {
+	server.RLock()
+	defer server.RUnlock()
+	records := []*protocols.GrpcRecord{}
+	for _, record := range server.Records {
+		if mtd == "" || record.Method == mtd {
+			records = append(records, record)
+		}
+	}
+	return records
+}
+
+// recorded methods in order of their first records
+func recordedMethods(server *Server) []string {
+	server.RLock()
+	defer server.RUnlock()
+	methods := []string{}
+	seen := map[string]bool{}
+	for _, record := range server.Records {
+		if !seen[record.Method] {
+			seen[record.Method] = true
+			methods = append(methods, record.Method)
+		}
+	}
+	return methods
+}
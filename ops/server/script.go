@@ -0,0 +1,146 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/robertkrimen/otto"
+
+	"github.com/feiyuw/simgo/logger"
+	"github.com/feiyuw/simgo/utils"
+)
+
+var (
+	// running time limit of javascript handlers, time slept by ctx.Sleep is not counted
+	ScriptTimeout = 5 * time.Second
+
+	errScriptTimeout = errors.New("script timed out")
+)
+
+// counters of scripts by name, shared by handlers of the same method of a server
+type scriptCounters struct {
+	sync.Mutex
+	M map[string]int64
+}
+
+func newScriptCounters() *scriptCounters {
+	return &scriptCounters{M: map[string]int64{}}
+}
+
+// javascript handler compiled once, run in a new vm for each call
+type script struct {
+	mtd      string
+	compiled *otto.Script
+	counters *scriptCounters
+}
+
+func newScript(mtd, content string, counters *scriptCounters) (*script, error) {
+	compiled, err := otto.New().Compile("", content)
+	if err != nil {
+		return nil, fmt.Errorf("invalid script: %v", err)
+	}
+	return &script{mtd: mtd, compiled: compiled, counters: counters}, nil
+}
+
+// run script with ctx extended by the standard library, error returned if it throws or times out
+func (s *script) run(ctx map[string]interface{}) (err error) {
+	vm := otto.New()
+	vm.Interrupt = make(chan func(), 1)
+	timeout := ScriptTimeout
+	deadline := time.Now().Add(timeout)
+	timer := time.AfterFunc(timeout, func() {
+		vm.Interrupt <- func() {
+			panic(errScriptTimeout)
+		}
+	})
+	defer timer.Stop()
+	defer func() {
+		if caught := recover(); caught != nil {
+			if caught != errScriptTimeout {
+				panic(caught)
+			}
+			err = fmt.Errorf("script timed out after %v", timeout)
+		}
+		if err != nil {
+			logger.Errorf("ops/server", "script of %s failed: %v", s.mtd, err)
+		}
+	}()
+
+	ctx["Sleep"] = func(seconds uint64) {
+		// pause the timer, so that sleeping is not counted
+		if !timer.Stop() {
+			time.Sleep(time.Duration(seconds) * time.Second)
+			return
+		}
+		remaining := time.Until(deadline)
+		time.Sleep(time.Duration(seconds) * time.Second)
+		deadline = time.Now().Add(remaining)
+		timer.Reset(remaining)
+	}
+	for name, fn := range s.stdlib() {
+		ctx[name] = fn
+	}
+	if err = vm.Set("ctx", ctx); err != nil {
+		return err
+	}
+
+	_, err = vm.Run(s.compiled)
+	return err
+}
+
+// functions added to ctx of every script
+func (s *script) stdlib() map[string]interface{} {
+	return map[string]interface{}{
+		// random integer in [min, max]
+		"Random":       utils.RandInt,
+		"RandomString": utils.RandString,
+		"UUID":         utils.UUID,
+		// unix timestamp in milliseconds
+		"Now": func() int64 {
+			return time.Now().UnixNano() / int64(time.Millisecond)
+		},
+		// format unix timestamp in milliseconds with go layout, RFC3339 by default,
+		// eg. ctx.FormatTime(ctx.Now(), "2006-01-02")
+		"FormatTime": func(ms int64, layout string) string {
+			if layout == "" {
+				layout = time.RFC3339
+			}
+			return time.Unix(0, ms*int64(time.Millisecond)).Format(layout)
+		},
+		// add delta(1 if not set) to counter and return the new value, eg. ctx.Incr("calls")
+		"Incr": func(call otto.FunctionCall) otto.Value {
+			delta := int64(1)
+			if arg := call.Argument(1); arg.IsDefined() {
+				n, err := arg.ToInteger()
+				if err != nil {
+					panic(call.Otto.MakeTypeError(err.Error()))
+				}
+				delta = n
+			}
+			key := call.Argument(0).String()
+			s.counters.Lock()
+			s.counters.M[key] += delta
+			value := s.counters.M[key]
+			s.counters.Unlock()
+			v, _ := otto.ToValue(value)
+			return v
+		},
+		// current value of counter, 0 if never increased
+		"Counter": func(name string) int64 {
+			s.counters.Lock()
+			defer s.counters.Unlock()
+			return s.counters.M[name]
+		},
+		"Log": func(call otto.FunctionCall) otto.Value {
+			args := make([]string, len(call.ArgumentList))
+			for idx, arg := range call.ArgumentList {
+				args[idx] = arg.String()
+			}
+			logger.Infof("ops/server", "script of %s: %s", s.mtd, strings.Join(args, " "))
+			return otto.UndefinedValue()
+		},
+	}
+}
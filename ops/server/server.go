@@ -1,19 +1,16 @@
 package server
 
 import (
-	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
+	"sort"
 	"strconv"
-	"time"
 
 	"github.com/feiyuw/simgo/protocols"
 	"github.com/feiyuw/simgo/utils"
 
-	"github.com/jhump/protoreflect/dynamic"
 	"github.com/labstack/echo/v4"
-	"github.com/robertkrimen/otto"
-	"google.golang.org/grpc"
 )
 
 func Query(c echo.Context) error {
@@ -30,25 +27,51 @@ func New(c echo.Context) error {
 	if err := c.Bind(server); err != nil {
 		return err
 	}
+	server.Id = 0 // always assign a new ID
+
+	serverId, err := createServer(server)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+	server.save()
+
+	return c.JSON(http.StatusOK, map[string]uint64{"id": serverId})
+}
+
+// create rpc server of the definition and start it
+func createServer(server *Server) (uint64, error) {
+	msgSize, msgAge, err := parseRetention(server.Options)
+	if err != nil {
+		return 0, err
+	}
+	server.msgSize, server.msgAge = msgSize, msgAge
+
 	rpcServer, err := protocols.NewRpcServer(server.Protocol, server.Name, server.Port, server.Options)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
 	server.RpcServer = rpcServer
 	server.MethodHandlers = map[string]*MethodHandler{}
+	if gs, ok := rpcServer.(*protocols.GrpcServer); ok {
+		gs.SetReceivedSource(&receivedSource{server})
+	}
 
+	if err = server.RpcServer.Start(); err != nil {
+		return 0, err
+	}
 	serverId, err := serverStorage.Add(server)
 	if err != nil {
-		return c.JSON(http.StatusBadRequest, err)
-	}
-	if err = server.RpcServer.Start(); err != nil {
-		return c.JSON(http.StatusBadRequest, err)
+		server.RpcServer.Close()
+		return 0, err
 	}
 
 	server.RpcServer.AddListener(newMessageRecorder(server))
+	if gs, ok := server.RpcServer.(*protocols.GrpcServer); ok {
+		gs.AddRecorder(newGrpcRecorder(server))
+	}
 
-	return c.JSON(http.StatusOK, map[string]uint64{"id": serverId})
+	return serverId, nil
 }
 
 func Delete(c echo.Context) error {
@@ -56,17 +79,103 @@ func Delete(c echo.Context) error {
 	if err != nil {
 		return c.JSON(http.StatusBadRequest, "incorrect server ID")
 	}
-	if err := serverStorage.Remove(serverId); err != nil {
+	if err := Remove(serverId); err != nil {
 		return err
 	}
 	return c.JSON(http.StatusOK, nil)
 }
 
+// stop server and remove it
+func Remove(serverId uint64) error {
+	return serverStorage.Remove(serverId)
+}
+
+// ID of server with the name and port, false if not exists
+func Find(name string, port int) (uint64, bool) {
+	servers, _ := serverStorage.FindAll()
+	for _, server := range servers {
+		if server.Name == name && server.Port == port {
+			return server.Id, true
+		}
+	}
+	return 0, false
+}
+
+// definition of server with its method handlers, used in scenario
+type Definition struct {
+	Name     string                 `json:"name"`
+	Protocol string                 `json:"protocol"`
+	Port     int                    `json:"port"`
+	Options  map[string]interface{} `json:"options,omitempty"`
+	Handlers []*MethodHandler       `json:"handlers,omitempty"`
+}
+
+// create and start server with its method handlers, nothing is left if failed
+func Load(def *Definition) (uint64, error) {
+	server := &Server{Name: def.Name, Protocol: def.Protocol, Port: def.Port, Options: def.Options}
+	serverId, err := createServer(server)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create server %s: %v", def.Name, err)
+	}
+	for _, handler := range def.Handlers {
+		if handler == nil {
+			continue
+		}
+		h := *handler
+		h.ServerID = serverId
+		if _, exists := server.MethodHandlers[h.Method]; exists {
+			err = errors.New("method handler exists")
+		} else {
+			err = setMethodHandler(server, &h)
+		}
+		if err != nil {
+			serverStorage.Remove(serverId)
+			return 0, fmt.Errorf("failed to set handler %s of server %s: %v", h.Method, def.Name, err)
+		}
+	}
+	server.save()
+
+	return serverId, nil
+}
+
+// definitions of all servers in ID order, handlers are in method order
+func Export() ([]*Definition, error) {
+	servers, err := serverStorage.FindAll()
+	if err != nil {
+		return nil, err
+	}
+
+	defs := make([]*Definition, len(servers))
+	for idx, server := range servers {
+		server.RLock()
+		def := &Definition{Name: server.Name, Protocol: server.Protocol, Port: server.Port, Options: server.Options, Handlers: []*MethodHandler{}}
+		for _, handler := range server.MethodHandlers {
+			h := *handler
+			h.ServerID = 0
+			def.Handlers = append(def.Handlers, &h)
+		}
+		server.RUnlock()
+		sort.Slice(def.Handlers, func(idx1, idx2 int) bool {
+			return def.Handlers[idx1].Method < def.Handlers[idx2].Method
+		})
+		defs[idx] = def
+	}
+	return defs, nil
+}
+
 type MethodHandler struct {
-	ServerID uint64 `json:"serverId"`
-	Method   string `json:"method"`
-	Type     string `json:"type"`
-	Content  string `json:"content"`
+	ServerID uint64         `json:"serverId,omitempty"`
+	Method   string         `json:"method"`
+	Type     string         `json:"type"`    // type of fallback handler, can be empty if rules set
+	Content  string         `json:"content"` // content of fallback handler
+	Rules    []*HandlerRule `json:"rules,omitempty"`
+}
+
+// handler used when request matches, rules are checked in order
+type HandlerRule struct {
+	Match   *protocols.RuleMatcher `json:"match"`
+	Type    string                 `json:"type"`
+	Content string                 `json:"content"`
 }
 
 func ListMethodHandlers(c echo.Context) error {
@@ -96,40 +205,49 @@ func AddMethodHandler(c echo.Context) error {
 	if _, exists := server.MethodHandlers[handler.Method]; exists {
 		return errors.New("method handler exists")
 	}
+	if err = setMethodHandler(server, handler); err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+	server.save()
+
+	return c.JSON(http.StatusOK, nil)
+}
+
+// create handler of the definition and set it to rpc server
+func setMethodHandler(server *Server, handler *MethodHandler) error {
 	switch server.Protocol {
 	case "grpc":
-		err = server.RpcServer.(*protocols.GrpcServer).SetMethodHandler(handler.Method, func(in *dynamic.Message, out *dynamic.Message, stream grpc.ServerStream) error {
-			switch handler.Type {
-			case "raw":
-				resp := make(map[string]interface{})
-				if err := json.Unmarshal([]byte(handler.Content), &resp); err != nil {
-					return err
-				}
-				for k, v := range resp {
-					out.SetFieldByName(k, v)
-				}
-			case "javascript":
-				vm := otto.New()
-				vm.Set("ctx", map[string]interface{}{
-					"in":     in,
-					"out":    out,
-					"stream": stream,
-					"Sleep": func(seconds uint64) {
-						time.Sleep(time.Duration(seconds) * time.Second)
-					},
-				})
-				vm.Run(handler.Content)
-			}
-
-			return nil
-		})
+		rules, err := newGrpcMethodRules(handler, server.methodCounters(handler.Method))
+		if err != nil {
+			return err
+		}
+		if err = server.RpcServer.(*protocols.GrpcServer).SetMethodRules(handler.Method, rules); err != nil {
+			return err
+		}
+	case "http":
+		httpHandler, err := newHTTPMethodHandler(handler.Method, handler.Type, handler.Content, server.methodCounters(handler.Method))
+		if err != nil {
+			return err
+		}
+		if err = server.RpcServer.(*protocols.HTTPServer).SetMethodHandler(handler.Method, httpHandler); err != nil {
+			return err
+		}
+	case "dubbo":
+		dubboHandler, err := newDubboMethodHandler(handler.Method, handler.Type, handler.Content, server.methodCounters(handler.Method))
 		if err != nil {
 			return err
 		}
-		server.MethodHandlers[handler.Method] = handler
+		if err = server.RpcServer.(*protocols.DubboServer).SetMethodHandler(handler.Method, dubboHandler); err != nil {
+			return err
+		}
+	default:
+		return errors.New("unsupported protocol: " + server.Protocol)
 	}
 
-	return c.JSON(http.StatusOK, nil)
+	server.Lock()
+	server.MethodHandlers[handler.Method] = handler
+	server.Unlock()
+	return nil
 }
 
 func DeleteMethodHandler(c echo.Context) error {
@@ -145,18 +263,38 @@ func DeleteMethodHandler(c echo.Context) error {
 	mtd := c.QueryParam("method")
 
 	if _, exists := server.MethodHandlers[mtd]; exists {
-		switch server.Protocol {
-		case "grpc":
-			if err := server.RpcServer.(*protocols.GrpcServer).RemoveMethodHandler(mtd); err != nil {
-				return err
-			}
-			delete(server.MethodHandlers, mtd)
+		if err := removeMethodHandler(server, mtd); err != nil {
+			return err
 		}
+		server.save()
 	}
 
 	return c.JSON(http.StatusOK, nil)
 }
 
+func removeMethodHandler(server *Server, mtd string) error {
+	var err error
+
+	switch server.Protocol {
+	case "grpc":
+		err = server.RpcServer.(*protocols.GrpcServer).RemoveMethodHandler(mtd)
+	case "http":
+		err = server.RpcServer.(*protocols.HTTPServer).RemoveMethodHandler(mtd)
+	case "dubbo":
+		err = server.RpcServer.(*protocols.DubboServer).RemoveMethodHandler(mtd)
+	}
+	if err != nil {
+		return err
+	}
+
+	server.Lock()
+	delete(server.MethodHandlers, mtd)
+	delete(server.counters, mtd)
+	server.Unlock()
+	return nil
+}
+
+// messages of server in newest first order, filtered by method, direction, peer, body, since and until if set
 func FetchMessages(c echo.Context) error {
 	var (
 		limit, skip int
@@ -184,5 +322,10 @@ func FetchMessages(c echo.Context) error {
 		skip = 0
 	}
 
-	return c.JSON(http.StatusOK, queryMessages(server, skip, limit))
+	filter, err := newMessageFilter(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+
+	return c.JSON(http.StatusOK, queryMessages(server, filter, skip, limit))
 }
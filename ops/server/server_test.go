@@ -1,19 +1,30 @@
 package server
 
 import (
+	"bufio"
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/jhump/protoreflect/dynamic"
 	"github.com/labstack/echo/v4"
 	. "github.com/smartystreets/goconvey/convey"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	hwpb "google.golang.org/grpc/examples/helloworld/helloworld"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 
 	"github.com/feiyuw/simgo/protocols"
+	"github.com/feiyuw/simgo/storage"
 )
 
 func TestServerRESTAPIs(t *testing.T) {
@@ -81,7 +92,7 @@ func TestServerRESTAPIs(t *testing.T) {
 		So(err, ShouldBeNil)
 		out, err := client.InvokeRPC("helloworld.Greeter.SayHello", map[string]interface{}{"name": "you"})
 		So(err, ShouldBeNil)
-		So(out.(map[string]interface{})["message"], ShouldEqual, "hello you")
+		So(out.(*protocols.GrpcResult).Messages[0]["message"], ShouldEqual, "hello you")
 
 		// 4. fetch messages
 		req = httptest.NewRequest(http.MethodGet, fmt.Sprintf("/api/v1/servers/messages?serverId=%d", serverId), nil)
@@ -116,7 +127,7 @@ func TestServerRESTAPIs(t *testing.T) {
 		out, err = client.InvokeRPC("helloworld.Greeter.SayHello", map[string]interface{}{"name": "you"})
 		elapsed := time.Now().Sub(start)
 		So(err, ShouldBeNil)
-		So(out.(map[string]interface{})["message"], ShouldEqual, "xxx")
+		So(out.(*protocols.GrpcResult).Messages[0]["message"], ShouldEqual, "xxx")
 		So(elapsed, ShouldBeGreaterThan, time.Second)
 
 		// 7. delete server
@@ -129,4 +140,694 @@ func TestServerRESTAPIs(t *testing.T) {
 		So(err, ShouldBeNil)
 		So(len(servers), ShouldEqual, 0)
 	})
+
+	Convey("grpc error handler e2e test", t, func() {
+		server := &Server{
+			Name:           "server_error",
+			Protocol:       "grpc",
+			Port:           5001,
+			MethodHandlers: map[string]*MethodHandler{},
+		}
+		rpcServer, err := protocols.NewRpcServer("grpc", server.Name, server.Port, map[string]interface{}{"protos": []interface{}{"../../protocols/helloworld.proto"}})
+		So(err, ShouldBeNil)
+		server.RpcServer = rpcServer
+		serverId, _ := serverStorage.Add(server)
+		defer serverStorage.Remove(serverId)
+		So(server.RpcServer.Start(), ShouldBeNil)
+		client, err := protocols.NewGrpcClient("127.0.0.1:5001", []string{"../../protocols/helloworld.proto"}, grpc.WithInsecure())
+		So(err, ShouldBeNil)
+		defer client.Close()
+
+		// 1. error handler with details
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/servers/handlers", strings.NewReader(fmt.Sprintf(`{"serverId":%d,"method":"helloworld.Greeter.SayHello","type":"error","content":"{\"code\":\"UNAVAILABLE\",\"message\":\"try later\",\"details\":[{\"@type\":\"type.googleapis.com/google.rpc.RetryInfo\",\"retryDelay\":\"3s\"}]}"}`, serverId)))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		So(AddMethodHandler(e.NewContext(req, rec)), ShouldBeNil)
+		So(rec.Code, ShouldEqual, http.StatusOK)
+		_, err = client.InvokeRPC("helloworld.Greeter.SayHello", map[string]interface{}{"name": "you"})
+		So(status.Code(err), ShouldEqual, codes.Unavailable)
+		So(status.Convert(err).Message(), ShouldEqual, "try later")
+		So(status.Convert(err).Details()[0].(*errdetails.RetryInfo).RetryDelay.Seconds, ShouldEqual, 3)
+		server.RpcServer.(*protocols.GrpcServer).RemoveMethodHandler("helloworld.Greeter.SayHello")
+		delete(server.MethodHandlers, "helloworld.Greeter.SayHello")
+
+		// 2. invalid error handler
+		req = httptest.NewRequest(http.MethodPost, "/api/v1/servers/handlers", strings.NewReader(fmt.Sprintf(`{"serverId":%d,"method":"helloworld.Greeter.SayHello","type":"error","content":"{\"code\":0}"}`, serverId)))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec = httptest.NewRecorder()
+		So(AddMethodHandler(e.NewContext(req, rec)), ShouldBeNil)
+		So(rec.Code, ShouldEqual, http.StatusBadRequest)
+
+		// 3. javascript handler returns error
+		req = httptest.NewRequest(http.MethodPost, "/api/v1/servers/handlers", strings.NewReader(fmt.Sprintf(`{"serverId":%d,"method":"helloworld.Greeter.SayHello","type":"javascript","content":"if (ctx.in.GetFieldByName(\"name\") == \"\") { ctx.Error(3, \"empty name\", [{\"@type\": \"type.googleapis.com/google.rpc.BadRequest\", \"fieldViolations\": [{\"field\": \"name\"}]}]) } else { ctx.out.SetFieldByName(\"message\", \"ok\") }"}`, serverId)))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec = httptest.NewRecorder()
+		So(AddMethodHandler(e.NewContext(req, rec)), ShouldBeNil)
+		So(rec.Code, ShouldEqual, http.StatusOK)
+		_, err = client.InvokeRPC("helloworld.Greeter.SayHello", map[string]interface{}{"name": ""})
+		So(status.Code(err), ShouldEqual, codes.InvalidArgument)
+		So(status.Convert(err).Details()[0].(*errdetails.BadRequest).FieldViolations[0].Field, ShouldEqual, "name")
+		out, err := client.InvokeRPC("helloworld.Greeter.SayHello", map[string]interface{}{"name": "you"})
+		So(err, ShouldBeNil)
+		So(out.(*protocols.GrpcResult).Messages[0]["message"], ShouldEqual, "ok")
+	})
+
+	Convey("grpc handler rules e2e test", t, func() {
+		server := &Server{
+			Name:           "server_rules",
+			Protocol:       "grpc",
+			Port:           5002,
+			MethodHandlers: map[string]*MethodHandler{},
+		}
+		rpcServer, err := protocols.NewRpcServer("grpc", server.Name, server.Port, map[string]interface{}{"protos": []interface{}{"../../protocols/helloworld.proto"}})
+		So(err, ShouldBeNil)
+		server.RpcServer = rpcServer
+		serverId, _ := serverStorage.Add(server)
+		defer serverStorage.Remove(serverId)
+		So(server.RpcServer.Start(), ShouldBeNil)
+		client, err := protocols.NewGrpcClient("127.0.0.1:5002", []string{"../../protocols/helloworld.proto"}, grpc.WithInsecure())
+		So(err, ShouldBeNil)
+		defer client.Close()
+
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/servers/handlers", strings.NewReader(fmt.Sprintf(`{
+			"serverId": %d,
+			"method": "helloworld.Greeter.SayHello",
+			"type": "raw",
+			"content": "{\"message\": \"fallback\"}",
+			"rules": [
+				{"match": {"fields": [{"path": "$.name", "value": "admin"}]}, "type": "error", "content": "{\"code\": \"PERMISSION_DENIED\"}"},
+				{"match": {"fields": [{"path": "$.name", "op": "regex", "value": "^u[0-9]+$"}]}, "type": "javascript", "content": "ctx.out.SetFieldByName(\"message\", \"user \" + ctx.in.GetFieldByName(\"name\"))"}
+			]
+		}`, serverId)))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		So(AddMethodHandler(e.NewContext(req, rec)), ShouldBeNil)
+		So(rec.Code, ShouldEqual, http.StatusOK)
+
+		_, err = client.InvokeRPC("helloworld.Greeter.SayHello", map[string]interface{}{"name": "admin"})
+		So(status.Code(err), ShouldEqual, codes.PermissionDenied)
+		out, err := client.InvokeRPC("helloworld.Greeter.SayHello", map[string]interface{}{"name": "u123"})
+		So(err, ShouldBeNil)
+		So(out.(*protocols.GrpcResult).Messages[0]["message"], ShouldEqual, "user u123")
+		out, err = client.InvokeRPC("helloworld.Greeter.SayHello", map[string]interface{}{"name": "guest"})
+		So(err, ShouldBeNil)
+		So(out.(*protocols.GrpcResult).Messages[0]["message"], ShouldEqual, "fallback")
+
+		req = httptest.NewRequest(http.MethodGet, fmt.Sprintf("/api/v1/servers/handlers?serverId=%d", serverId), nil)
+		rec = httptest.NewRecorder()
+		So(ListMethodHandlers(e.NewContext(req, rec)), ShouldBeNil)
+		handlers := map[string]*MethodHandler{}
+		So(json.Unmarshal(rec.Body.Bytes(), &handlers), ShouldBeNil)
+		So(len(handlers["helloworld.Greeter.SayHello"].Rules), ShouldEqual, 2)
+		So(handlers["helloworld.Greeter.SayHello"].Rules[1].Match.Fields[0].Op, ShouldEqual, "regex")
+	})
+
+	Convey("http server e2e test", t, func() {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/servers", strings.NewReader(`{"name":"http_e2e","port":5003,"protocol":"http","options":{}}`))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		So(New(e.NewContext(req, rec)), ShouldBeNil)
+		So(rec.Code, ShouldEqual, http.StatusOK)
+		resp := map[string]uint64{}
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		serverId := resp["id"]
+		defer serverStorage.Remove(serverId)
+
+		req = httptest.NewRequest(http.MethodPost, "/api/v1/servers/handlers", strings.NewReader(fmt.Sprintf(`{"serverId":%d,"method":"GET /users/:id","type":"raw","content":"{\"status\":202,\"headers\":{\"X-Id\":\"1\"},\"body\":{\"name\":\"you\"}}"}`, serverId)))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec = httptest.NewRecorder()
+		So(AddMethodHandler(e.NewContext(req, rec)), ShouldBeNil)
+		So(rec.Code, ShouldEqual, http.StatusOK)
+		req = httptest.NewRequest(http.MethodPost, "/api/v1/servers/handlers", strings.NewReader(fmt.Sprintf(`{"serverId":%d,"method":"POST /users/:id","type":"javascript","content":"ctx.resp.Status = 201; ctx.resp.Headers[\"X-Id\"] = ctx.req.Params.id; ctx.resp.Body = {\"body\": JSON.parse(ctx.req.Body)}"}`, serverId)))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec = httptest.NewRecorder()
+		So(AddMethodHandler(e.NewContext(req, rec)), ShouldBeNil)
+		So(rec.Code, ShouldEqual, http.StatusOK)
+
+		client, _ := protocols.NewHTTPClient("127.0.0.1:5003", time.Second, nil)
+		out, err := client.InvokeRPC("GET /users/1", nil)
+		So(err, ShouldBeNil)
+		So(out.(*protocols.HTTPResponse).Status, ShouldEqual, 202)
+		So(out.(*protocols.HTTPResponse).Headers["X-Id"], ShouldEqual, "1")
+		So(out.(*protocols.HTTPResponse).Body.(map[string]interface{})["name"], ShouldEqual, "you")
+		out, err = client.InvokeRPC("POST /users/3", `{"body": {"name": "me"}}`)
+		So(err, ShouldBeNil)
+		So(out.(*protocols.HTTPResponse).Status, ShouldEqual, 201)
+		So(out.(*protocols.HTTPResponse).Headers["X-Id"], ShouldEqual, "3")
+		So(out.(*protocols.HTTPResponse).Body.(map[string]interface{})["body"].(map[string]interface{})["name"], ShouldEqual, "me")
+
+		server, _ := serverStorage.FindOne(serverId)
+		So(len(server.Messages), ShouldEqual, 4)
+		So(server.Messages[0].Method, ShouldEqual, "POST /users/:id")
+		So(server.Messages[0].Direction, ShouldEqual, "out")
+
+		req = httptest.NewRequest(http.MethodDelete, fmt.Sprintf("/api/v1/servers/handlers?serverId=%d&method=GET+/users/:id", serverId), nil)
+		rec = httptest.NewRecorder()
+		So(DeleteMethodHandler(e.NewContext(req, rec)), ShouldBeNil)
+		out, err = client.InvokeRPC("GET /users/1", nil)
+		So(err, ShouldBeNil)
+		So(out.(*protocols.HTTPResponse).Status, ShouldEqual, http.StatusNotFound)
+	})
+	Convey("dubbo server e2e test", t, func() {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/servers", strings.NewReader(`{"name":"dubbo_e2e","port":5004,"protocol":"dubbo","options":{}}`))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		So(New(e.NewContext(req, rec)), ShouldBeNil)
+		So(rec.Code, ShouldEqual, http.StatusOK)
+		resp := map[string]uint64{}
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		serverId := resp["id"]
+		defer serverStorage.Remove(serverId)
+
+		for _, body := range []string{
+			`{"serverId":%d,"method":"com.foo.Greeter.hello","type":"javascript","content":"ctx.resp.Value = 'hello ' + ctx.req.Args[0]"}`,
+			`{"serverId":%d,"method":"com.foo.Greeter:1.0.0.hello","type":"raw","content":"{\"@class\":\"com.foo.Greeting\",\"message\":\"hi\"}"}`,
+			`{"serverId":%d,"method":"com.foo.Greeter.fail","type":"error","content":"user not found"}`,
+		} {
+			req = httptest.NewRequest(http.MethodPost, "/api/v1/servers/handlers", strings.NewReader(fmt.Sprintf(body, serverId)))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			rec = httptest.NewRecorder()
+			So(AddMethodHandler(e.NewContext(req, rec)), ShouldBeNil)
+			So(rec.Code, ShouldEqual, http.StatusOK)
+		}
+
+		client, err := protocols.NewDubboClient("127.0.0.1:5004", "com.foo.Greeter", "", "", time.Second)
+		So(err, ShouldBeNil)
+		defer client.Close()
+		out, err := client.InvokeRPC("hello", `{"types": ["java.lang.String"], "args": ["you"]}`)
+		So(err, ShouldBeNil)
+		So(out, ShouldEqual, "hello you")
+		_, err = client.InvokeRPC("fail", nil)
+		So(err.Error(), ShouldEqual, "java.lang.RuntimeException: user not found")
+
+		client2, err := protocols.NewDubboClient("127.0.0.1:5004", "com.foo.Greeter", "1.0.0", "", time.Second)
+		So(err, ShouldBeNil)
+		defer client2.Close()
+		out, err = client2.InvokeRPC("hello", `{"types": ["java.lang.String"], "args": ["you"]}`)
+		So(err, ShouldBeNil)
+		So(out.(map[string]interface{})["message"], ShouldEqual, "hi")
+
+		server, _ := serverStorage.FindOne(serverId)
+		So(len(server.Messages), ShouldEqual, 6)
+	})
+
+	Convey("restore servers from storage", t, func() {
+		st := storage.NewMemoryStorage()
+		st.Save("servers", 100, &serverRecord{
+			Id:       100,
+			Name:     "restored",
+			Protocol: "http",
+			Port:     5005,
+			MethodHandlers: map[string]*MethodHandler{
+				"GET /hello": {Type: "raw", Content: `{"body": "hello"}`},
+				"GET /bad":   {Type: "unknown"},
+			},
+		})
+		st.Save("servers", 101, &serverRecord{Id: 101, Name: "bad", Protocol: "unknown"})
+		So(Restore(st), ShouldBeNil)
+		defer serverStorage.Remove(100)
+		_, err := serverStorage.FindOne(101)
+		So(err, ShouldNotBeNil)
+		server, err := serverStorage.FindOne(100)
+		So(err, ShouldBeNil)
+		So(len(server.MethodHandlers), ShouldEqual, 1)
+		So(server.MethodHandlers["GET /hello"].ServerID, ShouldEqual, 100)
+
+		client, _ := protocols.NewHTTPClient("127.0.0.1:5005", time.Second, nil)
+		out, err := client.InvokeRPC("GET /hello", nil)
+		So(err, ShouldBeNil)
+		So(out.(*protocols.HTTPResponse).Body, ShouldEqual, "hello")
+
+		req := httptest.NewRequest(http.MethodDelete, "/api/v1/servers/handlers?serverId=100&method=GET+/hello", nil)
+		rec := httptest.NewRecorder()
+		So(DeleteMethodHandler(e.NewContext(req, rec)), ShouldBeNil)
+		// server failed to restore is kept
+		values, _ := st.LoadAll("servers")
+		So(len(values), ShouldEqual, 2)
+		for _, value := range values {
+			So(string(value), ShouldNotContainSubstring, "GET /hello")
+		}
+
+		// IDs of failed servers are not reused
+		newId, err := createServer(&Server{Name: "new", Protocol: "http", Port: 5015})
+		So(err, ShouldBeNil)
+		So(newId, ShouldBeGreaterThan, 101)
+		serverStorage.Remove(newId)
+
+		serverStorage.Remove(100)
+		values, _ = st.LoadAll("servers")
+		So(len(values), ShouldEqual, 1)
+		So(string(values[0]), ShouldContainSubstring, `"name":"bad"`)
+	})
+
+	Convey("grpc proxy record and replay e2e test", t, func() {
+		backend, _ := protocols.NewGrpcServer(":5006", []string{"../../protocols/helloworld.proto"})
+		backend.SetMethodHandler("helloworld.Greeter.SayHello", func(in *dynamic.Message, out *dynamic.Message, stream grpc.ServerStream) error {
+			out.SetFieldByName("message", "real "+in.GetFieldByName("name").(string))
+			return nil
+		})
+		backend.Start()
+		defer backend.Close()
+
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/servers", strings.NewReader(`{"name":"proxy_e2e","port":5007,"protocol":"grpc","options":{"protos":["../../protocols/helloworld.proto"],"proxy":"127.0.0.1:5006"}}`))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		So(New(e.NewContext(req, rec)), ShouldBeNil)
+		So(rec.Code, ShouldEqual, http.StatusOK)
+		resp := map[string]uint64{}
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		serverId := resp["id"]
+		defer serverStorage.Remove(serverId)
+
+		client, err := protocols.NewGrpcClient("127.0.0.1:5007", []string{"../../protocols/helloworld.proto"}, grpc.WithInsecure())
+		So(err, ShouldBeNil)
+		defer client.Close()
+		for _, name := range []string{"a", "b"} {
+			out, err := client.InvokeRPC("helloworld.Greeter.SayHello", map[string]interface{}{"name": name})
+			So(err, ShouldBeNil)
+			So(out.(*protocols.GrpcResult).Messages[0]["message"], ShouldEqual, "real "+name)
+		}
+
+		req = httptest.NewRequest(http.MethodGet, fmt.Sprintf("/api/v1/servers/records?serverId=%d&method=helloworld.Greeter.SayHello", serverId), nil)
+		rec = httptest.NewRecorder()
+		So(FetchRecords(e.NewContext(req, rec)), ShouldBeNil)
+		records := []*protocols.GrpcRecord{}
+		json.Unmarshal(rec.Body.Bytes(), &records)
+		So(len(records), ShouldEqual, 2)
+		So(string(records[1].Messages[1].Body), ShouldEqual, `{"message":"real b"}`)
+
+		req = httptest.NewRequest(http.MethodPost, "/api/v1/servers/records/replay", strings.NewReader(fmt.Sprintf(`{"serverId":%d}`, serverId)))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec = httptest.NewRecorder()
+		So(ReplayRecords(e.NewContext(req, rec)), ShouldBeNil)
+		So(rec.Code, ShouldEqual, http.StatusOK)
+		So(rec.Body.String(), ShouldEqual, "[\"helloworld.Greeter.SayHello\"]\n")
+
+		// nothing is replaced if any method fails
+		req = httptest.NewRequest(http.MethodPost, "/api/v1/servers/records/replay", strings.NewReader(fmt.Sprintf(`{"serverId":%d,"methods":["helloworld.Greeter.SayHello","helloworld.Greeter.Unknown"]}`, serverId)))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec = httptest.NewRecorder()
+		So(ReplayRecords(e.NewContext(req, rec)), ShouldBeNil)
+		So(rec.Code, ShouldEqual, http.StatusBadRequest)
+
+		backend.Close()
+		out, err := client.InvokeRPC("helloworld.Greeter.SayHello", map[string]interface{}{"name": "a"})
+		So(err, ShouldBeNil)
+		So(out.(*protocols.GrpcResult).Messages[0]["message"], ShouldEqual, "real a")
+		out, err = client.InvokeRPC("helloworld.Greeter.SayHello", map[string]interface{}{"name": "c"})
+		So(err, ShouldBeNil)
+		So(out.(*protocols.GrpcResult).Messages[0]["message"], ShouldEqual, "real b")
+
+		req = httptest.NewRequest(http.MethodDelete, fmt.Sprintf("/api/v1/servers/records?serverId=%d", serverId), nil)
+		rec = httptest.NewRecorder()
+		So(ClearRecords(e.NewContext(req, rec)), ShouldBeNil)
+		server, _ := serverStorage.FindOne(serverId)
+		So(server.Records, ShouldBeEmpty)
+		So(server.MethodHandlers["helloworld.Greeter.SayHello"].Type, ShouldEqual, "replay")
+	})
+
+	Convey("javascript handler with metadata, header and trailer e2e test", t, func() {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/servers", strings.NewReader(`{"name":"metadata_e2e","port":5008,"protocol":"grpc","options":{"protos":["../../protocols/helloworld.proto"]}}`))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		So(New(e.NewContext(req, rec)), ShouldBeNil)
+		resp := map[string]uint64{}
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		serverId := resp["id"]
+		defer serverStorage.Remove(serverId)
+
+		script := `
+if (!ctx.metadata["authorization"]) {
+	ctx.Error("UNAUTHENTICATED", "no token")
+} else {
+	ctx.SetHeader({"x-token": ctx.metadata["authorization"][0], "x-tags": ["a", "b"]})
+	ctx.SetTrailer({"x-deadline": ctx.deadline == "" ? "none" : "set"})
+	ctx.out.SetFieldByName("message", "hello " + ctx.peer.split(":")[0])
+}`
+		body, _ := json.Marshal(map[string]interface{}{"serverId": serverId, "method": "helloworld.Greeter.SayHello", "type": "javascript", "content": script})
+		req = httptest.NewRequest(http.MethodPost, "/api/v1/servers/handlers", strings.NewReader(string(body)))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec = httptest.NewRecorder()
+		So(AddMethodHandler(e.NewContext(req, rec)), ShouldBeNil)
+		So(rec.Code, ShouldEqual, http.StatusOK)
+
+		conn, _ := grpc.Dial("127.0.0.1:5008", grpc.WithInsecure())
+		defer conn.Close()
+		hwClient := hwpb.NewGreeterClient(conn)
+		_, err := hwClient.SayHello(context.Background(), &hwpb.HelloRequest{Name: "you"})
+		So(status.Code(err), ShouldEqual, codes.Unauthenticated)
+
+		var header, trailer metadata.MD
+		ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "abc")
+		out, err := hwClient.SayHello(ctx, &hwpb.HelloRequest{Name: "you"}, grpc.Header(&header), grpc.Trailer(&trailer))
+		So(err, ShouldBeNil)
+		So(out.Message, ShouldEqual, "hello 127.0.0.1")
+		So(header.Get("x-token"), ShouldResemble, []string{"abc"})
+		So(header.Get("x-tags"), ShouldResemble, []string{"a", "b"})
+		So(trailer.Get("x-deadline"), ShouldResemble, []string{"none"})
+	})
+	Convey("stream live messages with filters", t, func() {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/servers", strings.NewReader(`{"name":"feed_e2e","port":5009,"protocol":"http","options":{}}`))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		So(New(e.NewContext(req, rec)), ShouldBeNil)
+		resp := map[string]uint64{}
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		serverId := resp["id"]
+		defer serverStorage.Remove(serverId)
+		server, _ := serverStorage.FindOne(serverId)
+		So(setMethodHandler(server, &MethodHandler{Method: "GET /hello", Type: "raw", Content: `{"body": "hello"}`}), ShouldBeNil)
+		So(setMethodHandler(server, &MethodHandler{Method: "GET /other", Type: "raw", Content: `{"body": "other"}`}), ShouldBeNil)
+
+		e.GET("/api/v1/servers/messages/stream", StreamMessages)
+		ts := httptest.NewServer(e)
+		defer ts.Close()
+		stream, err := http.Get(fmt.Sprintf("%s/api/v1/servers/messages/stream?serverId=%d&method=GET+/hello&direction=out", ts.URL, serverId))
+		So(err, ShouldBeNil)
+		defer stream.Body.Close()
+		So(stream.Header.Get("Content-Type"), ShouldEqual, "text/event-stream")
+
+		client, _ := protocols.NewHTTPClient("127.0.0.1:5009", time.Second, nil)
+		client.InvokeRPC("GET /other", nil)
+		client.InvokeRPC("GET /hello", nil)
+
+		reader := bufio.NewReader(stream.Body)
+		line, _ := reader.ReadString('\n')
+		So(line, ShouldEqual, "event: message\n")
+		line, _ = reader.ReadString('\n')
+		So(line, ShouldStartWith, "data: ")
+		msg := new(Message)
+		So(json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), msg), ShouldBeNil)
+		So(msg.Method, ShouldEqual, "GET /hello")
+		So(msg.Direction, ShouldEqual, "out")
+
+		// feed is closed when server removed
+		serverStorage.Remove(serverId)
+		reader.ReadString('\n')
+		_, err = reader.ReadString('\n')
+		So(err, ShouldNotBeNil)
+	})
+	Convey("filter, export and clear messages", t, func() {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/servers", strings.NewReader(`{"name":"history_e2e","port":5010,"protocol":"http","options":{"messageSize":5,"messageAge":"1h"}}`))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		So(New(e.NewContext(req, rec)), ShouldBeNil)
+		resp := map[string]uint64{}
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		serverId := resp["id"]
+		defer serverStorage.Remove(serverId)
+		server, _ := serverStorage.FindOne(serverId)
+		So(setMethodHandler(server, &MethodHandler{Method: "GET /users/:id", Type: "javascript", Content: `ctx.resp.Body = {"id": ctx.req.Params.id}`}), ShouldBeNil)
+
+		client, _ := protocols.NewHTTPClient("127.0.0.1:5010", time.Second, nil)
+		for _, id := range []string{"1", "2", "3"} {
+			client.InvokeRPC("GET /users/"+id, nil)
+		}
+		So(len(server.Messages), ShouldEqual, 5) // the oldest one dropped
+
+		fetch := func(query string) []*Message {
+			req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/api/v1/servers/messages?serverId=%d&%s", serverId, query), nil)
+			rec := httptest.NewRecorder()
+			So(FetchMessages(e.NewContext(req, rec)), ShouldBeNil)
+			msgs := []*Message{}
+			json.Unmarshal(rec.Body.Bytes(), &msgs)
+			return msgs
+		}
+		So(len(fetch("direction=out")), ShouldEqual, 3)
+		msgs := fetch("direction=out&body=" + url.QueryEscape(`"id":"3"`))
+		So(len(msgs), ShouldEqual, 1)
+		So(msgs[0].Body, ShouldContainSubstring, `"id":"3"`)
+		So(len(fetch("peer=127.0.0.1&limit=2")), ShouldEqual, 2)
+		So(len(fetch(fmt.Sprintf("since=%d", msgs[0].Ts+1))), ShouldEqual, 0)
+		So(len(fetch(fmt.Sprintf("until=%d", msgs[0].Ts+1))), ShouldEqual, 5)
+
+		req = httptest.NewRequest(http.MethodGet, fmt.Sprintf("/api/v1/servers/messages/export?serverId=%d&direction=in", serverId), nil)
+		rec = httptest.NewRecorder()
+		So(ExportMessages(e.NewContext(req, rec)), ShouldBeNil)
+		lines := strings.Split(strings.TrimSpace(rec.Body.String()), "\n")
+		So(len(lines), ShouldEqual, 2)
+		So(lines[0], ShouldContainSubstring, "/users/2")
+
+		req = httptest.NewRequest(http.MethodGet, fmt.Sprintf("/api/v1/servers/messages/export?serverId=%d&format=har", serverId), nil)
+		rec = httptest.NewRecorder()
+		So(ExportMessages(e.NewContext(req, rec)), ShouldBeNil)
+		doc := new(har)
+		So(json.Unmarshal(rec.Body.Bytes(), doc), ShouldBeNil)
+		So(len(doc.Log.Entries), ShouldEqual, 3)
+		So(doc.Log.Entries[0].Request, ShouldBeNil) // request of it dropped
+		So(doc.Log.Entries[1].Request.Content.Text, ShouldContainSubstring, "/users/2")
+		So(doc.Log.Entries[1].Response.Content.Text, ShouldContainSubstring, `"id":"2"`)
+		So(doc.Log.Entries[1].Time, ShouldBeGreaterThanOrEqualTo, 0)
+
+		req = httptest.NewRequest(http.MethodGet, fmt.Sprintf("/api/v1/servers/messages/export?serverId=%d&format=xml", serverId), nil)
+		rec = httptest.NewRecorder()
+		So(ExportMessages(e.NewContext(req, rec)), ShouldBeNil)
+		So(rec.Code, ShouldEqual, http.StatusBadRequest)
+
+		req = httptest.NewRequest(http.MethodDelete, fmt.Sprintf("/api/v1/servers/messages?serverId=%d", serverId), nil)
+		rec = httptest.NewRecorder()
+		So(ClearMessages(e.NewContext(req, rec)), ShouldBeNil)
+		So(fetch(""), ShouldBeEmpty)
+	})
+
+	Convey("messages expire with retention age", t, func() {
+		server := &Server{msgSize: 10, msgAge: time.Minute}
+		recorder := newMessageRecorder(server)
+		recorder("GET /a", "in", "127.0.0.1:1", ":80", "{}", 1)
+		server.Messages[0].Ts -= 2 * time.Minute.Milliseconds()
+		recorder("GET /b", "in", "127.0.0.1:1", ":80", "{}", 1)
+		So(len(server.Messages), ShouldEqual, 1)
+		So(server.Messages[0].Method, ShouldEqual, "GET /b")
+
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/servers", strings.NewReader(`{"name":"bad","port":5011,"protocol":"http","options":{"messageSize":"x"}}`))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		So(New(e.NewContext(req, rec)), ShouldBeNil)
+		So(rec.Code, ShouldEqual, http.StatusBadRequest)
+	})
+	Convey("verify request messages", t, func() {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/servers", strings.NewReader(`{"name":"verify_e2e","port":5012,"protocol":"grpc","options":{"protos":["../../protocols/helloworld.proto"]}}`))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		So(New(e.NewContext(req, rec)), ShouldBeNil)
+		resp := map[string]uint64{}
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		serverId := resp["id"]
+		defer serverStorage.Remove(serverId)
+		server, _ := serverStorage.FindOne(serverId)
+		So(setMethodHandler(server, &MethodHandler{Method: "helloworld.Greeter.SayHello", Type: "raw", Content: `{"message": "hi"}`}), ShouldBeNil)
+
+		client, _ := protocols.NewGrpcClient("127.0.0.1:5012", []string{"../../protocols/helloworld.proto"}, grpc.WithInsecure())
+		defer client.Close()
+		client.InvokeRPC("helloworld.Greeter.SayHello", map[string]interface{}{"name": "alice"})
+		client.InvokeRPC("helloworld.Greeter.SayHello", map[string]interface{}{"name": "bob"})
+
+		verify := func(body string) (int, *protocols.VerifyResult) {
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/servers/verify", strings.NewReader(fmt.Sprintf(body, serverId)))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			rec := httptest.NewRecorder()
+			So(VerifyMessages(e.NewContext(req, rec)), ShouldBeNil)
+			result := new(protocols.VerifyResult)
+			json.Unmarshal(rec.Body.Bytes(), result)
+			return rec.Code, result
+		}
+		code, result := verify(`{"serverId":%d,"ordered":true,"expectations":[{"method":"helloworld.Greeter.SayHello","fields":[{"path":"name","value":"alice"}],"times":1},{"method":"helloworld.Greeter.SayHello","fields":[{"path":"name","value":"bob"}]}]}`)
+		So(code, ShouldEqual, http.StatusOK)
+		So(result.OK, ShouldBeTrue)
+
+		code, result = verify(`{"serverId":%d,"ordered":true,"expectations":[{"method":"helloworld.Greeter.SayHello","fields":[{"path":"name","value":"bob"}]},{"method":"helloworld.Greeter.SayHello","fields":[{"path":"name","value":"alice"}]}]}`)
+		So(code, ShouldEqual, http.StatusOK)
+		So(result.OK, ShouldBeFalse)
+
+		go func() {
+			time.Sleep(50 * time.Millisecond)
+			client.InvokeRPC("helloworld.Greeter.SayHello", map[string]interface{}{"name": "carol"})
+		}()
+		_, result = verify(`{"serverId":%d,"timeout":"2s","expectations":[{"method":"helloworld.Greeter.SayHello","atLeast":3}]}`)
+		So(result.OK, ShouldBeTrue)
+		So(result.Results[0].Count, ShouldEqual, 3)
+
+		// the same messages are verified and cleared by Go API
+		gs := server.RpcServer.(*protocols.GrpcServer)
+		result, _ = gs.Verify(&protocols.Verification{Expectations: []*protocols.Expectation{{Method: "helloworld.Greeter.SayHello"}}})
+		So(result.Results[0].Count, ShouldEqual, 3)
+		req = httptest.NewRequest(http.MethodDelete, fmt.Sprintf("/api/v1/servers/messages?serverId=%d", serverId), nil)
+		So(ClearMessages(e.NewContext(req, httptest.NewRecorder())), ShouldBeNil)
+		result, _ = gs.Verify(&protocols.Verification{Expectations: []*protocols.Expectation{{Method: "helloworld.Greeter.SayHello"}}})
+		So(result.Results[0].Count, ShouldEqual, 0)
+		client.InvokeRPC("helloworld.Greeter.SayHello", map[string]interface{}{"name": "dave"})
+		gs.ClearReceived()
+		_, result = verify(`{"serverId":%d,"expectations":[{"method":"helloworld.Greeter.SayHello","times":0}]}`)
+		So(result.OK, ShouldBeTrue)
+
+		code, _ = verify(`{"serverId":%d,"expectations":[{"method":""}]}`)
+		So(code, ShouldEqual, http.StatusBadRequest)
+	})
+	Convey("toggle faults at runtime", t, func() {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/servers", strings.NewReader(`{"name":"fault_e2e","port":5013,"protocol":"grpc","options":{"protos":["../../protocols/helloworld.proto"]}}`))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		So(New(e.NewContext(req, rec)), ShouldBeNil)
+		resp := map[string]uint64{}
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		serverId := resp["id"]
+		defer serverStorage.Remove(serverId)
+		server, _ := serverStorage.FindOne(serverId)
+		So(setMethodHandler(server, &MethodHandler{Method: "helloworld.Greeter.SayHello", Type: "raw", Content: `{"message": "hi"}`}), ShouldBeNil)
+
+		client, _ := protocols.NewGrpcClient("127.0.0.1:5013", []string{"../../protocols/helloworld.proto"}, grpc.WithInsecure())
+		defer client.Close()
+
+		setFault := func(body string) int {
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/servers/faults", strings.NewReader(fmt.Sprintf(body, serverId)))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			rec := httptest.NewRecorder()
+			So(SetFault(e.NewContext(req, rec)), ShouldBeNil)
+			return rec.Code
+		}
+		So(setFault(`{"serverId":%d,"method":"helloworld.Greeter.SayHello","error":{"rate":1,"codes":["RESOURCE_EXHAUSTED"],"message":"busy"}}`), ShouldEqual, http.StatusOK)
+		_, err := client.InvokeRPC("helloworld.Greeter.SayHello", map[string]interface{}{"name": "you"})
+		So(status.Code(err), ShouldEqual, codes.ResourceExhausted)
+		So(status.Convert(err).Message(), ShouldEqual, "busy")
+
+		req = httptest.NewRequest(http.MethodGet, fmt.Sprintf("/api/v1/servers/faults?serverId=%d", serverId), nil)
+		rec = httptest.NewRecorder()
+		So(ListFaults(e.NewContext(req, rec)), ShouldBeNil)
+		faults := map[string]*protocols.Fault{}
+		json.Unmarshal(rec.Body.Bytes(), &faults)
+		So(faults["helloworld.Greeter.SayHello"].Error.Rate, ShouldEqual, 1)
+
+		req = httptest.NewRequest(http.MethodDelete, fmt.Sprintf("/api/v1/servers/faults?serverId=%d&method=helloworld.Greeter.SayHello", serverId), nil)
+		rec = httptest.NewRecorder()
+		So(DeleteFault(e.NewContext(req, rec)), ShouldBeNil)
+		out, err := client.InvokeRPC("helloworld.Greeter.SayHello", map[string]interface{}{"name": "you"})
+		So(err, ShouldBeNil)
+		So(out.(*protocols.GrpcResult).Messages[0]["message"], ShouldEqual, "hi")
+
+		So(setFault(`{"serverId":%d,"method":"*","dropRate":2}`), ShouldEqual, http.StatusBadRequest)
+		So(setFault(`{"serverId":%d,"method":"*","latency":{"distribution":"unknown"}}`), ShouldEqual, http.StatusBadRequest)
+		So(setFault(`{"serverId":%d,"dropRate":1}`), ShouldEqual, http.StatusBadRequest)
+	})
+	Convey("control serving status of health service", t, func() {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/servers", strings.NewReader(`{"name":"health_e2e","port":5014,"protocol":"grpc","options":{"protos":["../../protocols/helloworld.proto"]}}`))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		So(New(e.NewContext(req, rec)), ShouldBeNil)
+		resp := map[string]uint64{}
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		serverId := resp["id"]
+		defer serverStorage.Remove(serverId)
+
+		setStatus := func(body string) int {
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/servers/health", strings.NewReader(fmt.Sprintf(body, serverId)))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			rec := httptest.NewRecorder()
+			So(SetServingStatus(e.NewContext(req, rec)), ShouldBeNil)
+			return rec.Code
+		}
+		So(setStatus(`{"serverId":%d,"service":"helloworld.Greeter","status":"NOT_SERVING"}`), ShouldEqual, http.StatusOK)
+		So(setStatus(`{"serverId":%d,"service":"helloworld.Greeter","status":"DOWN"}`), ShouldEqual, http.StatusBadRequest)
+
+		conn, _ := grpc.Dial("127.0.0.1:5014", grpc.WithInsecure())
+		defer conn.Close()
+		out, err := healthpb.NewHealthClient(conn).Check(context.Background(), &healthpb.HealthCheckRequest{Service: "helloworld.Greeter"})
+		So(err, ShouldBeNil)
+		So(out.Status, ShouldEqual, healthpb.HealthCheckResponse_NOT_SERVING)
+
+		req = httptest.NewRequest(http.MethodGet, fmt.Sprintf("/api/v1/servers/health?serverId=%d", serverId), nil)
+		rec = httptest.NewRecorder()
+		So(ListServingStatuses(e.NewContext(req, rec)), ShouldBeNil)
+		statuses := map[string]string{}
+		json.Unmarshal(rec.Body.Bytes(), &statuses)
+		So(statuses, ShouldResemble, map[string]string{
+			"":                      "SERVING",
+			"grpc.health.v1.Health": "SERVING",
+			"grpc.reflection.v1alpha.ServerReflection": "SERVING",
+			"helloworld.Greeter":                       "NOT_SERVING",
+		})
+	})
+}
+
+func TestScript(t *testing.T) {
+	Convey("invalid script is rejected when created", t, func() {
+		_, err := newScript("a.B.C", "ctx.out.SetFieldByName(", newScriptCounters())
+		So(err, ShouldNotBeNil)
+		_, err = newGrpcMethodHandler("a.B.C", "javascript", "}", newScriptCounters())
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("standard library in ctx", t, func() {
+		server := &Server{}
+		js, err := newScript("a.B.C", `
+ctx.Incr("calls"); ctx.Incr("calls", 2)
+ctx.out.calls = ctx.Counter("calls")
+ctx.out.random = ctx.Random(1, 3)
+ctx.out.str = ctx.RandomString(6)
+ctx.out.uuid = ctx.UUID()
+ctx.out.day = ctx.FormatTime(86400000, "2006-01-02")
+ctx.out.now = ctx.Now()
+ctx.Log("calls", ctx.out.calls)`, server.methodCounters("a.B.C"))
+		So(err, ShouldBeNil)
+		out := map[string]interface{}{}
+		So(js.run(map[string]interface{}{"out": out}), ShouldBeNil)
+		So(out["calls"], ShouldEqual, 3)
+		So(out["random"], ShouldBeBetweenOrEqual, 1, 3)
+		So(out["str"], ShouldHaveLength, 6)
+		So(out["uuid"], ShouldHaveLength, 36)
+		So(out["day"], ShouldEqual, time.Unix(86400, 0).Format("2006-01-02"))
+		So(out["now"], ShouldBeGreaterThan, 0)
+
+		// counters are shared by scripts of the same method of a server
+		incr := `ctx.out.calls = ctx.Incr("calls")`
+		other, _ := newScript("a.B.C", incr, server.methodCounters("a.B.C"))
+		So(other.run(map[string]interface{}{"out": out}), ShouldBeNil)
+		So(out["calls"], ShouldEqual, 4)
+		other, _ = newScript("a.B.D", incr, server.methodCounters("a.B.D"))
+		So(other.run(map[string]interface{}{"out": out}), ShouldBeNil)
+		So(out["calls"], ShouldEqual, 1)
+		other, _ = newScript("a.B.C", incr, (&Server{}).methodCounters("a.B.C"))
+		So(other.run(map[string]interface{}{"out": out}), ShouldBeNil)
+		So(out["calls"], ShouldEqual, 1)
+	})
+
+	Convey("counters are released when method handler is removed", t, func() {
+		hs, _ := protocols.NewHTTPServer(":5016", nil)
+		server := &Server{Protocol: "http", RpcServer: hs, MethodHandlers: map[string]*MethodHandler{}}
+		handler := &MethodHandler{Method: "GET /calls", Type: "javascript", Content: `ctx.Incr("calls")`}
+		So(setMethodHandler(server, handler), ShouldBeNil)
+		server.methodCounters(handler.Method).M["calls"] = 2
+		So(removeMethodHandler(server, handler.Method), ShouldBeNil)
+		So(server.counters, ShouldNotContainKey, handler.Method)
+		So(setMethodHandler(server, handler), ShouldBeNil)
+		So(server.methodCounters(handler.Method).M["calls"], ShouldEqual, 0)
+	})
+
+	Convey("script is interrupted when timed out", t, func() {
+		defer func(timeout time.Duration) { ScriptTimeout = timeout }(ScriptTimeout)
+		ScriptTimeout = 50 * time.Millisecond
+		js, _ := newScript("a.B.C", "while (true) {}", newScriptCounters())
+		start := time.Now()
+		err := js.run(map[string]interface{}{})
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "timed out")
+		So(time.Since(start), ShouldBeLessThan, time.Second)
+	})
+
+	Convey("script errors are returned as grpc errors", t, func() {
+		handler, err := newGrpcMethodHandler("a.B.C", "javascript", `throw new Error("boom")`, newScriptCounters())
+		So(err, ShouldBeNil)
+		err = handler(nil, nil, &mockServerStream{ctx: context.Background()})
+		So(status.Code(err), ShouldEqual, codes.Internal)
+		So(status.Convert(err).Message(), ShouldContainSubstring, "boom")
+	})
+}
+
+// server stream with context only
+type mockServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (ms *mockServerStream) Context() context.Context {
+	return ms.ctx
 }
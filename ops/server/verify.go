@@ -0,0 +1,66 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/feiyuw/simgo/protocols"
+)
+
+type verifyRequest struct {
+	ServerID uint64 `json:"serverId"`
+	protocols.Verification
+}
+
+// verify request messages of server, ok is false in result if any expectation is not met
+func VerifyMessages(c echo.Context) error {
+	req := new(verifyRequest)
+	if err := c.Bind(req); err != nil {
+		return err
+	}
+	server, err := serverStorage.FindOne(req.ServerID)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, "server not found!")
+	}
+
+	var result *protocols.VerifyResult
+	if gs, ok := server.RpcServer.(*protocols.GrpcServer); ok {
+		// the same messages, with absent fields matched as default values of their types
+		result, err = gs.Verify(&req.Verification)
+	} else {
+		result, err = protocols.VerifyWait((&receivedSource{server}).Received, &req.Verification)
+	}
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+	return c.JSON(http.StatusOK, result)
+}
+
+// request messages kept by server, so that grpc servers verify the same messages as REST API
+type receivedSource struct {
+	server *Server
+}
+
+// request messages of server in received order
+func (rs *receivedSource) Received() []*protocols.ReceivedMessage {
+	server := rs.server
+	server.Lock()
+	defer server.Unlock()
+	server.expireMessages()
+
+	msgs := []*protocols.ReceivedMessage{}
+	for idx := len(server.Messages) - 1; idx >= 0; idx-- {
+		msg := server.Messages[idx]
+		if msg.Direction == "in" {
+			msgs = append(msgs, &protocols.ReceivedMessage{Method: msg.Method, Peer: msg.From, Body: msg.Body, Seq: msg.Seq})
+		}
+	}
+	return msgs
+}
+
+func (rs *receivedSource) ClearReceived() {
+	rs.server.Lock()
+	rs.server.Messages = rs.server.Messages[:0]
+	rs.server.Unlock()
+}
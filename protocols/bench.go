@@ -0,0 +1,215 @@
+package protocols
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"text/template"
+	"time"
+
+	"github.com/feiyuw/simgo/utils"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// options of driving a method for a duration, at most RPS requests are sent per second
+// by Concurrency workers, as fast as possible if RPS is 0
+type BenchOptions struct {
+	Method      string         `json:"method"`
+	Data        interface{}    `json:"data"` // request data, a map or a json string, rendered as template for each request
+	RPS         int            `json:"rps"`
+	Concurrency int            `json:"concurrency"` // 1 by default
+	Duration    string         `json:"duration"`    // eg. 10s
+	Options     *InvokeOptions `json:"options,omitempty"`
+}
+
+// result of benchmark, latencies are in nanoseconds
+type BenchResult struct {
+	Method     string         `json:"method"`
+	Total      int            `json:"total"`
+	Errors     int            `json:"errors"`     // calls not OK
+	Duration   time.Duration  `json:"duration"`   // actual duration
+	Throughput float64        `json:"throughput"` // calls per second
+	Latency    BenchLatency   `json:"latency"`
+	Codes      map[string]int `json:"codes"` // count of calls by status code, eg. {"OK": 99, "Unavailable": 1}
+}
+
+type BenchLatency struct {
+	Min  time.Duration `json:"min"`
+	Mean time.Duration `json:"mean"`
+	P50  time.Duration `json:"p50"`
+	P90  time.Duration `json:"p90"`
+	P95  time.Duration `json:"p95"`
+	P99  time.Duration `json:"p99"`
+	Max  time.Duration `json:"max"`
+}
+
+// variables of request template, eg. {"id": "{{.Seq}}", "name": "{{randString 8}}"}
+type benchRequest struct {
+	Seq    int64 // sequence number of request, starts from 1
+	Worker int   // index of worker, starts from 0
+}
+
+var benchFuncs = template.FuncMap{
+	"randInt":    utils.RandInt, // random integer in [min, max]
+	"randString": utils.RandString,
+	"uuid":       utils.UUID,
+	// unix timestamp in milliseconds
+	"now": func() int64 {
+		return time.Now().UnixNano() / int64(time.Millisecond)
+	},
+}
+
+// compile request data to template
+func benchTemplate(data interface{}) (*template.Template, error) {
+	text, ok := data.(string)
+	if data == nil {
+		text = "{}"
+	} else if !ok {
+		var buf bytes.Buffer
+		encoder := json.NewEncoder(&buf)
+		encoder.SetEscapeHTML(false)
+		if err := encoder.Encode(data); err != nil {
+			return nil, fmt.Errorf("invalid request data: %v", err)
+		}
+		text = buf.String()
+	}
+	tmpl, err := template.New("request").Funcs(benchFuncs).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid request template: %v", err)
+	}
+	return tmpl, nil
+}
+
+// drive method by options until duration elapsed or ctx canceled
+func (gc *GrpcClient) Bench(ctx context.Context, opts *BenchOptions) (*BenchResult, error) {
+	if _, err := gc.findMethod(opts.Method); err != nil {
+		return nil, err
+	}
+	duration, err := time.ParseDuration(opts.Duration)
+	if err != nil || duration <= 0 {
+		return nil, fmt.Errorf("invalid duration: %s", opts.Duration)
+	}
+	if opts.RPS < 0 || opts.RPS > int(time.Second) || opts.Concurrency < 0 {
+		return nil, errors.New("rps and concurrency should not be negative, and rps should be at most 1e9")
+	}
+	tmpl, err := benchTemplate(opts.Data)
+	if err != nil {
+		return nil, err
+	}
+	// validate options before starting workers
+	_, cancel, _, _, err := opts.Options.prepare(ctx)
+	if err != nil {
+		return nil, err
+	}
+	cancel()
+
+	concurrency := opts.Concurrency
+	if concurrency == 0 {
+		concurrency = 1
+	}
+	ctx, cancel = context.WithTimeout(ctx, duration)
+	defer cancel()
+
+	// tokens of requests, workers wait for them if rate limited
+	var tokens <-chan time.Time
+	if opts.RPS > 0 {
+		ticker := time.NewTicker(time.Second / time.Duration(opts.RPS))
+		defer ticker.Stop()
+		tokens = ticker.C
+	}
+
+	var (
+		seq       int64
+		lock      sync.Mutex
+		wg        sync.WaitGroup
+		latencies []time.Duration
+		codeCount = map[codes.Code]int{}
+	)
+	start := time.Now()
+	for worker := 0; worker < concurrency; worker++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			var buf bytes.Buffer
+			for {
+				if tokens != nil {
+					select {
+					case <-tokens:
+					case <-ctx.Done():
+						return
+					}
+				} else if ctx.Err() != nil {
+					return
+				}
+
+				buf.Reset()
+				code := codes.OK
+				var latency time.Duration
+				if err := tmpl.Execute(&buf, &benchRequest{Seq: atomic.AddInt64(&seq, 1), Worker: worker}); err != nil {
+					code = codes.InvalidArgument
+				} else if result, err := gc.InvokeContext(ctx, opts.Method, buf.String(), opts.Options); err != nil {
+					code = status.Code(err)
+				} else {
+					code, latency = result.Code, result.Latency
+				}
+				// calls in flight are canceled when benchmark finished, they are not counted
+				if code != codes.OK && ctx.Err() != nil {
+					return
+				}
+
+				lock.Lock()
+				codeCount[code]++
+				if code == codes.OK {
+					latencies = append(latencies, latency)
+				}
+				lock.Unlock()
+			}
+		}(worker)
+	}
+	wg.Wait()
+
+	result := &BenchResult{Method: opts.Method, Duration: time.Since(start), Codes: map[string]int{}}
+	for code, count := range codeCount {
+		result.Codes[code.String()] = count
+		result.Total += count
+		if code != codes.OK {
+			result.Errors += count
+		}
+	}
+	result.Throughput = float64(result.Total) / result.Duration.Seconds()
+	result.Latency = newBenchLatency(latencies)
+	return result, nil
+}
+
+// statistics of latencies of successful calls
+func newBenchLatency(latencies []time.Duration) BenchLatency {
+	if len(latencies) == 0 {
+		return BenchLatency{}
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+
+	var sum time.Duration
+	for _, latency := range latencies {
+		sum += latency
+	}
+	percentile := func(p float64) time.Duration {
+		return latencies[int(math.Ceil(p*float64(len(latencies))))-1]
+	}
+	return BenchLatency{
+		Min:  latencies[0],
+		Mean: sum / time.Duration(len(latencies)),
+		P50:  percentile(0.5),
+		P90:  percentile(0.9),
+		P95:  percentile(0.95),
+		P99:  percentile(0.99),
+		Max:  latencies[len(latencies)-1],
+	}
+}
@@ -0,0 +1,116 @@
+package protocols
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/jhump/protoreflect/dynamic"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestBenchLatency(t *testing.T) {
+	Convey("percentiles of latencies", t, func() {
+		latencies := []time.Duration{}
+		for i := 100; i > 0; i-- {
+			latencies = append(latencies, time.Duration(i)*time.Millisecond)
+		}
+		lat := newBenchLatency(latencies)
+		So(lat.Min, ShouldEqual, time.Millisecond)
+		So(lat.Max, ShouldEqual, 100*time.Millisecond)
+		So(lat.P50, ShouldEqual, 50*time.Millisecond)
+		So(lat.P99, ShouldEqual, 99*time.Millisecond)
+		So(lat.Mean, ShouldEqual, 50500*time.Microsecond)
+		So(newBenchLatency(nil), ShouldResemble, BenchLatency{})
+	})
+}
+
+func TestGrpcClientBench(t *testing.T) {
+	s, _ := NewGrpcServer(":4988", []string{"echo.proto"})
+	s.SetMethodHandler("grpc.examples.echo.Echo.UnaryEcho", func(in *dynamic.Message, out *dynamic.Message, stream grpc.ServerStream) error {
+		switch in.GetFieldByName("message") {
+		case "fail":
+			return status.Error(codes.Unavailable, "fail")
+		case "hang":
+			<-stream.Context().Done()
+			return stream.Context().Err()
+		}
+		out.SetFieldByName("message", in.GetFieldByName("message"))
+		return nil
+	})
+	s.Start()
+	defer s.Close()
+	time.Sleep(time.Millisecond) // make sure server started
+
+	client, _ := NewGrpcClient("127.0.0.1:4988", []string{"echo.proto"}, grpc.WithInsecure())
+	defer client.Close()
+
+	Convey("bench with target rps", t, func() {
+		result, err := client.Bench(context.Background(), &BenchOptions{
+			Method:      "grpc.examples.echo.Echo.UnaryEcho",
+			Data:        map[string]interface{}{"message": "{{.Seq}}-{{randString 4}}"},
+			RPS:         100,
+			Concurrency: 4,
+			Duration:    "200ms",
+		})
+		So(err, ShouldBeNil)
+		So(result.Total, ShouldBeBetweenOrEqual, 10, 21)
+		So(result.Codes["OK"], ShouldEqual, result.Total)
+		So(result.Errors, ShouldEqual, 0)
+		So(result.Latency.Max, ShouldBeGreaterThan, 0)
+		So(result.Throughput, ShouldBeGreaterThan, 0)
+
+		received, _ := s.Verify(&Verification{Expectations: []*Expectation{
+			{Method: "grpc.examples.echo.Echo.UnaryEcho", Fields: []*ValueMatcher{{Path: "message", Op: "regex", Value: `^1-\w{4}$`}}, Times: intPtr(1)},
+		}})
+		So(received.OK, ShouldBeTrue)
+	})
+
+	Convey("errors are counted by status code", t, func() {
+		result, err := client.Bench(context.Background(), &BenchOptions{
+			Method:   "grpc.examples.echo.Echo.UnaryEcho",
+			Data:     `{"message": "{{if eq .Seq 1}}ok{{else}}fail{{end}}"}`,
+			Duration: "50ms",
+		})
+		So(err, ShouldBeNil)
+		So(result.Codes["OK"], ShouldEqual, 1)
+		So(result.Codes["Unavailable"], ShouldEqual, result.Total-1)
+		So(result.Errors, ShouldEqual, result.Total-1)
+	})
+
+	Convey("calls in flight are canceled when finished", t, func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(100*time.Millisecond, cancel)
+		start := time.Now()
+		result, err := client.Bench(ctx, &BenchOptions{
+			Method:      "grpc.examples.echo.Echo.UnaryEcho",
+			Data:        `{"message": "hang"}`,
+			Concurrency: 2,
+			Duration:    "10s",
+		})
+		So(err, ShouldBeNil)
+		So(time.Since(start), ShouldBeLessThan, time.Second)
+		So(result.Total, ShouldEqual, 0)
+
+		start = time.Now()
+		result, err = client.Bench(context.Background(), &BenchOptions{Method: "grpc.examples.echo.Echo.UnaryEcho", Data: `{"message": "hang"}`, Duration: "100ms"})
+		So(err, ShouldBeNil)
+		So(time.Since(start), ShouldBeLessThan, time.Second)
+		So(result.Total, ShouldEqual, 0)
+	})
+
+	Convey("invalid options", t, func() {
+		_, err := client.Bench(context.Background(), &BenchOptions{Method: "grpc.examples.echo.Echo.Unknown", Duration: "1s"})
+		So(err, ShouldNotBeNil)
+		_, err = client.Bench(context.Background(), &BenchOptions{Method: "grpc.examples.echo.Echo.UnaryEcho"})
+		So(err, ShouldNotBeNil)
+		_, err = client.Bench(context.Background(), &BenchOptions{Method: "grpc.examples.echo.Echo.UnaryEcho", Duration: "1s", Data: "{{"})
+		So(err, ShouldNotBeNil)
+		_, err = client.Bench(context.Background(), &BenchOptions{Method: "grpc.examples.echo.Echo.UnaryEcho", Duration: "1s", RPS: -1})
+		So(err, ShouldNotBeNil)
+	})
+}
@@ -0,0 +1,130 @@
+package protocols
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/fullstorydev/grpcurl"
+	"github.com/jhump/protoreflect/desc"
+)
+
+// where service descriptors are loaded from, proto files are resolved in import paths,
+// or in current directory if no import path set, protosets are compiled FileDescriptorSet files,
+// services of server reflection are also used by clients if reflection set
+type ProtoSource struct {
+	ImportPaths []string `json:"importPaths,omitempty"`
+	Protos      []string `json:"protos,omitempty"`
+	Protosets   []string `json:"protosets,omitempty"`
+	Reflection  bool     `json:"reflection,omitempty"`
+}
+
+// get proto source from options protos, importPaths, protosets and reflection,
+// eg. {"importPaths": ["upload/protos"], "protos": ["api/user.proto"], "protosets": ["upload/order.protoset"]}
+func newProtoSource(options map[string]interface{}) (*ProtoSource, error) {
+	protos, err := getStringsOption(options, "protos")
+	if err != nil {
+		return nil, err
+	}
+	importPaths, err := getStringsOption(options, "importPaths")
+	if err != nil {
+		return nil, err
+	}
+	protosets, err := getStringsOption(options, "protosets")
+	if err != nil {
+		return nil, err
+	}
+	reflection, err := getBoolOption(options, "reflection")
+	if err != nil {
+		return nil, err
+	}
+	return &ProtoSource{ImportPaths: importPaths, Protos: protos, Protosets: protosets, Reflection: reflection}, nil
+}
+
+// no proto files or protosets, reflection is not counted
+func (ps *ProtoSource) empty() bool {
+	return len(ps.Protos) == 0 && len(ps.Protosets) == 0
+}
+
+// descriptor source of proto files and protosets, combined if both set
+func (ps *ProtoSource) descriptorSource() (grpcurl.DescriptorSource, error) {
+	if ps.empty() {
+		return nil, errors.New("no protos specified")
+	}
+	sources := compositeSource{}
+	if len(ps.Protos) > 0 {
+		importPaths := ps.ImportPaths
+		if importPaths == nil {
+			importPaths = []string{}
+		}
+		source, err := grpcurl.DescriptorSourceFromProtoFiles(importPaths, ps.Protos...)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse proto file: %v", err)
+		}
+		sources = append(sources, source)
+	}
+	if len(ps.Protosets) > 0 {
+		source, err := grpcurl.DescriptorSourceFromProtoSets(ps.Protosets...)
+		if err != nil {
+			return nil, fmt.Errorf("cannot load protoset: %v", err)
+		}
+		sources = append(sources, source)
+	}
+	if len(sources) == 1 {
+		return sources[0], nil
+	}
+	return sources, nil
+}
+
+// descriptor sources used in order, symbols are found in the first source defining them
+type compositeSource []grpcurl.DescriptorSource
+
+func (cs compositeSource) ListServices() ([]string, error) {
+	found := map[string]bool{}
+	services := []string{}
+	for _, source := range cs {
+		svcs, err := source.ListServices()
+		if err != nil {
+			return nil, err
+		}
+		for _, svc := range svcs {
+			if !found[svc] {
+				found[svc] = true
+				services = append(services, svc)
+			}
+		}
+	}
+	sort.Strings(services)
+	return services, nil
+}
+
+func (cs compositeSource) FindSymbol(fullyQualifiedName string) (desc.Descriptor, error) {
+	var lastErr error
+	for _, source := range cs {
+		dsc, err := source.FindSymbol(fullyQualifiedName)
+		if err == nil {
+			return dsc, nil
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+func (cs compositeSource) AllExtensionsForType(typeName string) ([]*desc.FieldDescriptor, error) {
+	found := map[int32]bool{}
+	exts := []*desc.FieldDescriptor{}
+	for _, source := range cs {
+		fields, err := source.AllExtensionsForType(typeName)
+		if err != nil {
+			// type may be unknown by some of sources
+			continue
+		}
+		for _, fld := range fields {
+			if !found[fld.GetNumber()] {
+				found[fld.GetNumber()] = true
+				exts = append(exts, fld)
+			}
+		}
+	}
+	return exts, nil
+}
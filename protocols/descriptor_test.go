@@ -0,0 +1,147 @@
+package protocols
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/fullstorydev/grpcurl"
+	"github.com/golang/protobuf/proto"
+	descpb "github.com/golang/protobuf/protoc-gen-go/descriptor"
+	"github.com/jhump/protoreflect/desc"
+	"github.com/jhump/protoreflect/dynamic"
+	. "github.com/smartystreets/goconvey/convey"
+	"google.golang.org/grpc"
+)
+
+func TestProtoSource(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "simgo")
+	defer os.RemoveAll(dir)
+	os.MkdirAll(filepath.Join(dir, "api"), 0755)
+	os.MkdirAll(filepath.Join(dir, "common"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, "api", "user.proto"), []byte(`syntax = "proto3";
+package api;
+import "common/types.proto";
+import "google/protobuf/empty.proto";
+service Users {
+  rpc Get(common.Id) returns (common.Id);
+  rpc Ping(google.protobuf.Empty) returns (google.protobuf.Empty);
+}`), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "common", "types.proto"), []byte(`syntax = "proto3"; package common; message Id { string id = 1; }`), 0644)
+
+	Convey("protos are resolved in import paths", t, func() {
+		s, err := NewRpcServer("grpc", "users", 4986, map[string]interface{}{"importPaths": []interface{}{dir}, "protos": []interface{}{"api/user.proto"}})
+		So(err, ShouldBeNil)
+		So(s.Start(), ShouldBeNil)
+		defer s.Close()
+		methods, _ := s.(*GrpcServer).ListMethods()
+		So(methods, ShouldContain, "api.Users.Get")
+
+		client, err := NewRpcClient("grpc", "127.0.0.1:4986", map[string]interface{}{"importPaths": []interface{}{dir}, "protos": []interface{}{"api/user.proto"}})
+		So(err, ShouldBeNil)
+		defer client.Close()
+		services, _ := client.(*GrpcClient).ListServices()
+		So(services, ShouldContain, "api.Users")
+	})
+
+	Convey("imports are not found without import paths", t, func() {
+		_, err := NewRpcServer("grpc", "users", 4986, map[string]interface{}{"protos": []interface{}{filepath.Join(dir, "api", "user.proto")}})
+		So(err, ShouldNotBeNil)
+		_, err = NewRpcServer("grpc", "users", 4986, map[string]interface{}{"importPaths": "x", "protos": []interface{}{"api/user.proto"}})
+		So(err, ShouldNotBeNil)
+		_, err = NewRpcServer("grpc", "users", 4986, map[string]interface{}{"importPaths": []interface{}{dir}})
+		So(err, ShouldNotBeNil)
+	})
+}
+
+// write protoset of proto file and its dependencies
+func writeProtoset(path string, importPaths []string, protoFile, service string) error {
+	source, err := grpcurl.DescriptorSourceFromProtoFiles(importPaths, protoFile)
+	if err != nil {
+		return err
+	}
+	dsc, err := source.FindSymbol(service)
+	if err != nil {
+		return err
+	}
+	fds := &descpb.FileDescriptorSet{}
+	var add func(fd *desc.FileDescriptor)
+	add = func(fd *desc.FileDescriptor) {
+		for _, dep := range fd.GetDependencies() {
+			add(dep)
+		}
+		fds.File = append(fds.File, fd.AsFileDescriptorProto())
+	}
+	add(dsc.GetFile())
+	b, err := proto.Marshal(fds)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, b, 0644)
+}
+
+func TestProtosets(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "simgo")
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(filepath.Join(dir, "order.proto"), []byte(`syntax = "proto3";
+package order;
+message Order { string id = 1; int32 count = 2; }
+service Orders { rpc Get(Order) returns (Order); }`), 0644)
+	protoset := filepath.Join(dir, "order.protoset")
+	if err := writeProtoset(protoset, []string{dir}, "order.proto", "order.Orders"); err != nil {
+		t.Fatal(err)
+	}
+
+	Convey("services are loaded from protosets", t, func() {
+		s, err := NewRpcServer("grpc", "orders", 4985, map[string]interface{}{"protosets": []interface{}{protoset}})
+		So(err, ShouldBeNil)
+		So(s.Start(), ShouldBeNil)
+		defer s.Close()
+		gs := s.(*GrpcServer)
+		gs.SetMethodHandler("order.Orders.Get", func(in, out *dynamic.Message, stream grpc.ServerStream) error {
+			out.SetFieldByName("id", in.GetFieldByName("id"))
+			out.SetFieldByName("count", int32(3))
+			return nil
+		})
+
+		client, err := NewRpcClient("grpc", "127.0.0.1:4985", map[string]interface{}{"protosets": []interface{}{protoset}})
+		So(err, ShouldBeNil)
+		defer client.Close()
+		out, err := client.InvokeRPC("order.Orders.Get", map[string]interface{}{"id": "o1"})
+		So(err, ShouldBeNil)
+		So(out.(*GrpcResult).Messages[0]["id"], ShouldEqual, "o1")
+		So(out.(*GrpcResult).Messages[0]["count"], ShouldEqual, 3)
+	})
+
+	Convey("protosets, protos and reflection can be mixed", t, func() {
+		s, err := NewRpcServer("grpc", "mixed", 4985, map[string]interface{}{"protos": []interface{}{"helloworld.proto"}, "protosets": []interface{}{protoset}})
+		So(err, ShouldBeNil)
+		So(s.Start(), ShouldBeNil)
+		defer s.Close()
+		methods, _ := s.(*GrpcServer).ListMethods()
+		So(methods, ShouldContain, "order.Orders.Get")
+		So(methods, ShouldContain, "helloworld.Greeter.SayHello")
+
+		// greeter is got from server reflection
+		client, err := NewRpcClient("grpc", "127.0.0.1:4985", map[string]interface{}{"protosets": []interface{}{protoset}, "reflection": true})
+		So(err, ShouldBeNil)
+		defer client.Close()
+		services, err := client.(*GrpcClient).ListServices()
+		So(err, ShouldBeNil)
+		So(services, ShouldContain, "order.Orders")
+		So(services, ShouldContain, "helloworld.Greeter")
+		mtds, err := client.(*GrpcClient).ListMethods("helloworld.Greeter")
+		So(err, ShouldBeNil)
+		So(mtds, ShouldContain, "helloworld.Greeter.SayHello")
+	})
+
+	Convey("invalid protosets", t, func() {
+		_, err := NewRpcServer("grpc", "orders", 4985, map[string]interface{}{"protosets": []interface{}{filepath.Join(dir, "order.proto")}})
+		So(err, ShouldNotBeNil)
+		_, err = NewRpcServer("grpc", "orders", 4985, map[string]interface{}{"protosets": []interface{}{filepath.Join(dir, "missing.protoset")}})
+		So(err, ShouldNotBeNil)
+		_, err = NewRpcClient("grpc", "127.0.0.1:4985", map[string]interface{}{"reflection": "yes"})
+		So(err, ShouldNotBeNil)
+	})
+}
@@ -0,0 +1,763 @@
+package protocols
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/feiyuw/simgo/logger"
+)
+
+// dubbo protocol, see https://dubbo.apache.org/en-us/blog/dubbo-protocol.html
+// header: magic(2) | flag(1) | status(1) | request id(8) | body length(4)
+
+const (
+	dubboMagic         = 0xdabb
+	dubboHeaderLength  = 16
+	dubboVersion       = "2.0.2"
+	dubboMaxBodyLength = 8 * 1024 * 1024
+
+	dubboFlagRequest = 0x80
+	dubboFlagTwoWay  = 0x40
+	dubboFlagEvent   = 0x20
+	dubboHessian2ID  = 2
+
+	dubboStatusOK           = 20
+	dubboStatusBadRequest   = 40
+	dubboStatusServiceError = 70
+
+	dubboResponseWithException                = 0
+	dubboResponseValue                        = 1
+	dubboResponseNullValue                    = 2
+	dubboResponseWithExceptionWithAttachments = 3
+	dubboResponseValueWithAttachments         = 4
+	dubboResponseNullValueWithAttachments     = 5
+
+	dubboDefaultTimeout = 3 * time.Second
+)
+
+type dubboHeader struct {
+	flag      byte
+	status    byte
+	requestID int64
+	bodyLen   int
+}
+
+func readDubboPacket(r io.Reader) (*dubboHeader, []byte, error) {
+	buf := make([]byte, dubboHeaderLength)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, nil, err
+	}
+	if binary.BigEndian.Uint16(buf[0:2]) != dubboMagic {
+		return nil, nil, errors.New("invalid dubbo magic number")
+	}
+	header := &dubboHeader{
+		flag:      buf[2],
+		status:    buf[3],
+		requestID: int64(binary.BigEndian.Uint64(buf[4:12])),
+		bodyLen:   int(binary.BigEndian.Uint32(buf[12:16])),
+	}
+	if header.flag&0x1f != dubboHessian2ID {
+		return nil, nil, fmt.Errorf("unsupported dubbo serialization: %d", header.flag&0x1f)
+	}
+	if header.bodyLen > dubboMaxBodyLength {
+		return nil, nil, fmt.Errorf("dubbo body too large: %d", header.bodyLen)
+	}
+	body := make([]byte, header.bodyLen)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, nil, err
+	}
+	return header, body, nil
+}
+
+func writeDubboPacket(w io.Writer, header *dubboHeader, body []byte) error {
+	buf := make([]byte, dubboHeaderLength, dubboHeaderLength+len(body))
+	binary.BigEndian.PutUint16(buf[0:2], dubboMagic)
+	buf[2] = header.flag | dubboHessian2ID
+	buf[3] = header.status
+	binary.BigEndian.PutUint64(buf[4:12], uint64(header.requestID))
+	binary.BigEndian.PutUint32(buf[12:16], uint32(len(body)))
+	_, err := w.Write(append(buf, body...))
+	return err
+}
+
+// request of dubbo method
+type DubboRequest struct {
+	Interface   string                 `json:"interface"`
+	Version     string                 `json:"version"`
+	Group       string                 `json:"group"`
+	Method      string                 `json:"method"`
+	Types       []string               `json:"types"` // java types of arguments, eg. java.lang.String, int, com.foo.User
+	Args        []interface{}          `json:"args"`
+	Attachments map[string]interface{} `json:"attachments"`
+}
+
+// response of dubbo method, exception is set if method failed
+type DubboResponse struct {
+	Value       interface{}            `json:"value"`
+	Exception   string                 `json:"exception,omitempty"`
+	Attachments map[string]interface{} `json:"attachments,omitempty"`
+}
+
+// service key like group/interface:version, empty group and version are omitted
+func dubboServiceKey(iface, version, group string) string {
+	key := iface
+	if group != "" {
+		key = group + "/" + key
+	}
+	if version != "" && version != "0.0.0" {
+		key = key + ":" + version
+	}
+	return key
+}
+
+var javaPrimitiveDescs = map[string]string{
+	"void": "V", "boolean": "Z", "byte": "B", "char": "C", "short": "S", "int": "I", "long": "J", "float": "F", "double": "D",
+}
+
+// convert java types to parameter descriptor, eg. [int, java.lang.String[]] to ILjava/lang/String;
+func javaTypesDesc(types []string) string {
+	var b strings.Builder
+
+	for _, t := range types {
+		t = strings.TrimSpace(t)
+		for strings.HasSuffix(t, "[]") {
+			b.WriteByte('[')
+			t = strings.TrimSuffix(t, "[]")
+		}
+		if desc, ok := javaPrimitiveDescs[t]; ok {
+			b.WriteString(desc)
+		} else {
+			b.WriteString("L" + strings.Replace(t, ".", "/", -1) + ";")
+		}
+	}
+	return b.String()
+}
+
+// convert parameter descriptor to java types, eg. ILjava/lang/String; to [int, java.lang.String]
+func parseJavaTypesDesc(desc string) ([]string, error) {
+	types := []string{}
+	dims := 0
+
+	for idx := 0; idx < len(desc); idx++ {
+		var t string
+		switch c := desc[idx]; c {
+		case '[':
+			dims++
+			continue
+		case 'L':
+			end := strings.IndexByte(desc[idx:], ';')
+			if end < 0 {
+				return nil, fmt.Errorf("invalid parameter descriptor: %s", desc)
+			}
+			t = strings.Replace(desc[idx+1:idx+end], "/", ".", -1)
+			idx += end
+		default:
+			for name, d := range javaPrimitiveDescs {
+				if d[0] == c {
+					t = name
+				}
+			}
+			if t == "" {
+				return nil, fmt.Errorf("invalid parameter descriptor: %s", desc)
+			}
+		}
+		types = append(types, t+strings.Repeat("[]", dims))
+		dims = 0
+	}
+	return types, nil
+}
+
+// ================================== client ==================================
+
+type DubboClient struct {
+	addr      string
+	iface     string
+	version   string
+	group     string
+	timeout   time.Duration
+	conn      net.Conn
+	writeLock sync.Mutex
+	pending   sync.Map // request id -> chan *dubboResult
+	nextID    int64
+	closed    chan struct{}
+	dead      chan struct{} // closed when connection is broken, calls fail with deadErr
+	deadErr   error
+}
+
+type dubboResult struct {
+	header *dubboHeader
+	body   []byte
+	err    error
+}
+
+// Create a new dubbo client of specified interface, version and group, 0 timeout means 3 seconds
+func NewDubboClient(addr, iface, version, group string, timeout time.Duration) (*DubboClient, error) {
+	if addr == "" {
+		return nil, fmt.Errorf("addr should not be empty")
+	}
+	if iface == "" {
+		return nil, fmt.Errorf("interface should not be empty")
+	}
+	if timeout <= 0 {
+		timeout = dubboDefaultTimeout
+	}
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return nil, fmt.Errorf("did not connect: %v", err)
+	}
+
+	dc := &DubboClient{
+		addr:    addr,
+		iface:   iface,
+		version: version,
+		group:   group,
+		timeout: timeout,
+		conn:    conn,
+		closed:  make(chan struct{}),
+		dead:    make(chan struct{}),
+	}
+	go dc.receive()
+	return dc, nil
+}
+
+func (dc *DubboClient) Close() error {
+	select {
+	case <-dc.closed:
+		return nil
+	default:
+		close(dc.closed)
+	}
+	return dc.conn.Close()
+}
+
+// invoke method of the interface, reqData is like {"types": ["java.lang.String"], "args": ["you"], "attachments": {}},
+// it can be a DubboRequest, or its json in string or map, the return value is decoded from hessian2
+func (dc *DubboClient) InvokeRPC(mtd string, reqData interface{}) (interface{}, error) {
+	req, err := toDubboRequest(reqData)
+	if err != nil {
+		return nil, err
+	}
+	if len(req.Types) != len(req.Args) {
+		return nil, fmt.Errorf("%d types for %d args", len(req.Types), len(req.Args))
+	}
+
+	enc := newHessianEncoder()
+	attachments := map[string]interface{}{
+		"path":      dc.iface,
+		"interface": dc.iface,
+		"timeout":   fmt.Sprintf("%d", dc.timeout/time.Millisecond),
+	}
+	if dc.version != "" {
+		attachments["version"] = dc.version
+	}
+	if dc.group != "" {
+		attachments["group"] = dc.group
+	}
+	for k, v := range req.Attachments {
+		attachments[k] = v
+	}
+	for _, v := range []interface{}{dubboVersion, dc.iface, dc.version, mtd, javaTypesDesc(req.Types)} {
+		enc.Encode(v)
+	}
+	for _, arg := range req.Args {
+		if err := enc.Encode(arg); err != nil {
+			return nil, err
+		}
+	}
+	if err := enc.Encode(attachments); err != nil {
+		return nil, err
+	}
+
+	header, body, err := dc.call(&dubboHeader{flag: dubboFlagRequest | dubboFlagTwoWay}, enc.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	resp, err := decodeDubboResponse(header, body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Exception != "" {
+		return nil, errors.New(resp.Exception)
+	}
+	return resp.Value, nil
+}
+
+// send request and wait for its response
+func (dc *DubboClient) call(header *dubboHeader, body []byte) (*dubboHeader, []byte, error) {
+	header.requestID = atomic.AddInt64(&dc.nextID, 1)
+	ch := make(chan *dubboResult, 1)
+	dc.pending.Store(header.requestID, ch)
+	defer dc.pending.Delete(header.requestID)
+
+	select {
+	case <-dc.dead:
+		return nil, nil, dc.deadErr
+	default:
+	}
+	dc.writeLock.Lock()
+	dc.conn.SetWriteDeadline(time.Now().Add(dc.timeout))
+	err := writeDubboPacket(dc.conn, header, body)
+	dc.writeLock.Unlock()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	select {
+	case result := <-ch:
+		return result.header, result.body, result.err
+	case <-time.After(dc.timeout):
+		return nil, nil, fmt.Errorf("dubbo request %d timeout after %v", header.requestID, dc.timeout)
+	case <-dc.closed:
+		return nil, nil, errors.New("dubbo client closed")
+	case <-dc.dead:
+		return nil, nil, dc.deadErr
+	}
+}
+
+// receive responses and dispatch them to callers, heartbeats from server are replied,
+// on read error, the connection is closed, and pending and new calls fail immediately
+func (dc *DubboClient) receive() {
+	r := bufio.NewReader(dc.conn)
+	for {
+		header, body, err := readDubboPacket(r)
+		if err != nil {
+			select {
+			case <-dc.closed:
+			default:
+				logger.Errorf("protocols/dubbo", "failed to read from %s: %v", dc.addr, err)
+			}
+			dc.deadErr = fmt.Errorf("dubbo connection to %s broken: %v", dc.addr, err)
+			close(dc.dead)
+			dc.conn.Close()
+			return
+		}
+		if header.flag&dubboFlagRequest != 0 {
+			if header.flag&dubboFlagEvent != 0 && header.flag&dubboFlagTwoWay != 0 {
+				dc.writeLock.Lock()
+				writeDubboPacket(dc.conn, &dubboHeader{flag: dubboFlagEvent, status: dubboStatusOK, requestID: header.requestID}, []byte{'N'})
+				dc.writeLock.Unlock()
+			}
+			continue
+		}
+		if ch, ok := dc.pending.Load(header.requestID); ok {
+			deliverDubboResult(ch.(chan *dubboResult), &dubboResult{header: header, body: body})
+		}
+	}
+}
+
+// deliver result to caller without blocking, the channel has room for one result only,
+// results after the first one are dropped, eg. read error after response received
+func deliverDubboResult(ch chan *dubboResult, result *dubboResult) {
+	select {
+	case ch <- result:
+	default:
+	}
+}
+
+func toDubboRequest(reqData interface{}) (*DubboRequest, error) {
+	var b []byte
+
+	switch reqData := reqData.(type) {
+	case nil:
+		return &DubboRequest{}, nil
+	case *DubboRequest:
+		return reqData, nil
+	case DubboRequest:
+		return &reqData, nil
+	case string:
+		if strings.TrimSpace(reqData) == "" {
+			return &DubboRequest{}, nil
+		}
+		b = []byte(reqData)
+	default:
+		var err error
+		if b, err = json.Marshal(reqData); err != nil {
+			return nil, err
+		}
+	}
+
+	req := new(DubboRequest)
+	if err := json.Unmarshal(b, req); err != nil {
+		return nil, fmt.Errorf("invalid dubbo request: %v", err)
+	}
+	return req, nil
+}
+
+func decodeDubboResponse(header *dubboHeader, body []byte) (*DubboResponse, error) {
+	dec := newHessianDecoder(bytes.NewReader(body))
+	if header.status != dubboStatusOK {
+		msg, err := dec.readString()
+		if err != nil {
+			return nil, fmt.Errorf("dubbo response status %d", header.status)
+		}
+		return nil, fmt.Errorf("dubbo response status %d: %s", header.status, msg)
+	}
+
+	respType, err := dec.readInt()
+	if err != nil {
+		return nil, err
+	}
+	resp := &DubboResponse{}
+	switch respType {
+	case dubboResponseValue, dubboResponseValueWithAttachments:
+		if resp.Value, err = dec.Decode(); err != nil {
+			return nil, err
+		}
+	case dubboResponseWithException, dubboResponseWithExceptionWithAttachments:
+		exception, err := dec.Decode()
+		if err != nil {
+			return nil, err
+		}
+		resp.Exception = exceptionMessage(exception)
+	case dubboResponseNullValue, dubboResponseNullValueWithAttachments:
+	default:
+		return nil, fmt.Errorf("unknown dubbo response type: %d", respType)
+	}
+	if respType >= dubboResponseWithExceptionWithAttachments {
+		attachments, err := dec.Decode()
+		if err != nil {
+			return nil, err
+		}
+		resp.Attachments, _ = attachments.(map[string]interface{})
+	}
+	return resp, nil
+}
+
+// get message from java exception object
+func exceptionMessage(exception interface{}) string {
+	obj, ok := exception.(map[string]interface{})
+	if !ok {
+		return fmt.Sprintf("%v", exception)
+	}
+	msg := fmt.Sprintf("%v", obj[hessianClassKey])
+	if detail, ok := obj["detailMessage"].(string); ok && detail != "" {
+		msg += ": " + detail
+	}
+	return msg
+}
+
+// ================================== server ==================================
+
+type DubboServer struct {
+	sync.RWMutex
+
+	addr      string
+	lis       net.Listener
+	handlerM  map[string]func(req *DubboRequest, resp *DubboResponse) error
+	listeners []func(mtd, direction, from, to, body string, seq int) error
+	conns     sync.Map // net.Conn -> struct{}
+}
+
+// create a new dubbo server
+func NewDubboServer(addr string) (*DubboServer, error) {
+	return &DubboServer{addr: addr, handlerM: map[string]func(req *DubboRequest, resp *DubboResponse) error{}}, nil
+}
+
+func (ds *DubboServer) Start() error {
+	lis, err := net.Listen("tcp", ds.addr)
+	if err != nil {
+		return err
+	}
+	ds.Lock()
+	ds.lis = lis
+	ds.Unlock()
+	logger.Infof("protocols/dubbo", "server listening at %v", lis.Addr())
+
+	go func() {
+		for {
+			conn, err := lis.Accept()
+			if err != nil {
+				if !strings.Contains(err.Error(), "use of closed network connection") {
+					logger.Errorf("protocols/dubbo", "failed to accept: %v", err)
+				}
+				return
+			}
+			ds.conns.Store(conn, struct{}{})
+			go ds.serveConn(conn)
+		}
+	}()
+
+	return nil
+}
+
+func (ds *DubboServer) Close() error {
+	ds.Lock()
+	lis := ds.lis
+	ds.lis = nil
+	ds.Unlock()
+	if lis == nil {
+		return nil
+	}
+
+	lis.Close()
+	ds.conns.Range(func(k, v interface{}) bool {
+		k.(net.Conn).Close()
+		return true
+	})
+	ds.Lock()
+	ds.handlerM = map[string]func(req *DubboRequest, resp *DubboResponse) error{}
+	ds.Unlock()
+	logger.Infof("protocols/dubbo", "dubbo server %s stopped", ds.addr)
+
+	return nil
+}
+
+// add a listener for all requests and responses of the server, seq is always 1
+func (ds *DubboServer) AddListener(listener func(mtd, direction, from, to, body string, seq int) error) {
+	ds.listeners = append(ds.listeners, listener)
+	logger.Infof("protocols/dubbo", "new listener added, now %d listeners", len(ds.listeners))
+}
+
+// set handler of method, mtd is service key and method name, eg. com.foo.UserService.getUser,
+// group and version can be set like group/com.foo.UserService:1.0.0.getUser, handler without them
+// is used if no handler for specified group or version
+func (ds *DubboServer) SetMethodHandler(mtd string, handler func(req *DubboRequest, resp *DubboResponse) error) error {
+	if strings.LastIndex(mtd, ".") <= 0 {
+		return fmt.Errorf("invalid method %s, should be like interface.method", mtd)
+	}
+	ds.Lock()
+	defer ds.Unlock()
+	if _, exists := ds.handlerM[mtd]; exists {
+		logger.Warnf("protocols/dubbo", "handler for method %s exists, will be overrided", mtd)
+	}
+	ds.handlerM[mtd] = handler
+	return nil
+}
+
+func (ds *DubboServer) RemoveMethodHandler(mtd string) error {
+	ds.Lock()
+	defer ds.Unlock()
+	delete(ds.handlerM, mtd)
+	return nil
+}
+
+// find handler with the most specific service key
+func (ds *DubboServer) getMethodHandler(req *DubboRequest) (string, func(req *DubboRequest, resp *DubboResponse) error, error) {
+	ds.RLock()
+	defer ds.RUnlock()
+	for _, key := range []string{
+		dubboServiceKey(req.Interface, req.Version, req.Group),
+		dubboServiceKey(req.Interface, req.Version, ""),
+		dubboServiceKey(req.Interface, "", req.Group),
+		req.Interface,
+	} {
+		mtd := key + "." + req.Method
+		if handler, ok := ds.handlerM[mtd]; ok {
+			return mtd, handler, nil
+		}
+	}
+	return "", nil, fmt.Errorf("handler for method %s.%s not found", dubboServiceKey(req.Interface, req.Version, req.Group), req.Method)
+}
+
+func (ds *DubboServer) serveConn(conn net.Conn) {
+	var writeLock sync.Mutex
+
+	defer func() {
+		ds.conns.Delete(conn)
+		conn.Close()
+	}()
+	r := bufio.NewReader(conn)
+	peerAddr := conn.RemoteAddr().String()
+	write := func(header *dubboHeader, body []byte) {
+		writeLock.Lock()
+		defer writeLock.Unlock()
+		if err := writeDubboPacket(conn, header, body); err != nil {
+			logger.Errorf("protocols/dubbo", "failed to write to %s: %v", peerAddr, err)
+		}
+	}
+
+	for {
+		header, body, err := readDubboPacket(r)
+		if err != nil {
+			if err != io.EOF && !strings.Contains(err.Error(), "use of closed network connection") {
+				logger.Errorf("protocols/dubbo", "failed to read from %s: %v", peerAddr, err)
+			}
+			return
+		}
+		if header.flag&dubboFlagRequest == 0 {
+			continue // response of heartbeat
+		}
+		if header.flag&dubboFlagEvent != 0 {
+			if header.flag&dubboFlagTwoWay != 0 {
+				write(&dubboHeader{flag: dubboFlagEvent, status: dubboStatusOK, requestID: header.requestID}, []byte{'N'})
+			}
+			continue
+		}
+		go func() {
+			// the connection is kept if handling failed unexpectedly, eg. response can not be encoded
+			defer func() {
+				if caught := recover(); caught != nil {
+					logger.Errorf("protocols/dubbo", "failed to handle request from %s: %v\n%s", peerAddr, caught, debug.Stack())
+					if header.flag&dubboFlagTwoWay != 0 {
+						enc := newHessianEncoder()
+						enc.Encode(fmt.Sprintf("%v", caught))
+						write(&dubboHeader{status: dubboStatusServiceError, requestID: header.requestID}, enc.Bytes())
+					}
+				}
+			}()
+			respHeader, respBody := ds.handleRequest(header, body, peerAddr)
+			if header.flag&dubboFlagTwoWay != 0 {
+				write(respHeader, respBody)
+			}
+		}()
+	}
+}
+
+func (ds *DubboServer) handleRequest(header *dubboHeader, body []byte, peerAddr string) (*dubboHeader, []byte) {
+	respHeader := &dubboHeader{status: dubboStatusOK, requestID: header.requestID}
+	enc := newHessianEncoder()
+	errorResponse := func(status byte, err error) (*dubboHeader, []byte) {
+		logger.Errorf("protocols/dubbo", "failed to handle request from %s: %v", peerAddr, err)
+		respHeader.status = status
+		enc.Encode(err.Error())
+		return respHeader, enc.Bytes()
+	}
+
+	req, reqDubboVersion, err := decodeDubboRequest(body)
+	if err != nil {
+		return errorResponse(dubboStatusBadRequest, err)
+	}
+	mtd, handler, err := ds.getMethodHandler(req)
+	if err != nil {
+		mtd = dubboServiceKey(req.Interface, req.Version, req.Group) + "." + req.Method
+		ds.notifyListeners(mtd, "in", peerAddr, ds.addr, req)
+		return errorResponse(dubboStatusServiceError, err)
+	}
+	ds.notifyListeners(mtd, "in", peerAddr, ds.addr, req)
+
+	resp := &DubboResponse{Attachments: map[string]interface{}{}}
+	if err := callDubboHandler(mtd, handler, req, resp); err != nil {
+		resp.Exception = err.Error()
+	}
+	ds.notifyListeners(mtd, "out", ds.addr, peerAddr, resp)
+
+	withAttachments := supportResponseAttachment(reqDubboVersion)
+	respType := dubboResponseValue
+	switch {
+	case resp.Exception != "":
+		respType = dubboResponseWithException
+	case resp.Value == nil:
+		respType = dubboResponseNullValue
+	}
+	if withAttachments {
+		respType += dubboResponseWithExceptionWithAttachments
+	}
+	enc.Encode(int32(respType))
+	switch {
+	case resp.Exception != "":
+		enc.Encode(map[string]interface{}{hessianClassKey: "java.lang.RuntimeException", "detailMessage": resp.Exception})
+	case resp.Value != nil:
+		if err := enc.Encode(resp.Value); err != nil {
+			enc = newHessianEncoder()
+			return errorResponse(dubboStatusServiceError, err)
+		}
+	}
+	if withAttachments {
+		enc.Encode(resp.Attachments)
+	}
+	return respHeader, enc.Bytes()
+}
+
+// call handler, panic of it is recovered as error with stack logged, so that it is responded as exception
+func callDubboHandler(mtd string, handler func(req *DubboRequest, resp *DubboResponse) error, req *DubboRequest, resp *DubboResponse) (err error) {
+	defer func() {
+		if caught := recover(); caught != nil {
+			logger.Errorf("protocols/dubbo", "handler of %s panicked: %v\n%s", mtd, caught, debug.Stack())
+			err = fmt.Errorf("handler of %s panicked: %v", mtd, caught)
+		}
+	}()
+	return handler(req, resp)
+}
+
+// attachments in response are supported by dubbo protocol 2.0.2 to 2.0.99,
+// see Version.isSupportResponseAttachment of dubbo
+func supportResponseAttachment(version string) bool {
+	v := dubboIntVersion(version)
+	return v >= 2000200 && v <= 2009900
+}
+
+// dubbo version as number like Version.getIntVersion of dubbo, eg. 2000200 for 2.0.2, 0 if invalid
+func dubboIntVersion(version string) int {
+	parts := strings.Split(version, ".")
+	if version == "" || len(parts) > 4 {
+		return 0
+	}
+	v := 0
+	for _, part := range parts {
+		// digits prefix only, eg. 2 of 2-SNAPSHOT
+		n := 0
+		for _, c := range part {
+			if c < '0' || c > '9' {
+				break
+			}
+			n = n*10 + int(c-'0')
+		}
+		v = v*100 + n
+	}
+	if len(parts) == 3 {
+		v *= 100
+	}
+	return v
+}
+
+// decode request body, returns request and dubbo version of client
+func decodeDubboRequest(body []byte) (*DubboRequest, string, error) {
+	dec := newHessianDecoder(bytes.NewReader(body))
+	fields := make([]string, 5) // dubbo version, path, version, method and parameter types
+	for idx := range fields {
+		s, err := dec.readString()
+		if err != nil {
+			return nil, "", fmt.Errorf("invalid dubbo request: %v", err)
+		}
+		fields[idx] = s
+	}
+	types, err := parseJavaTypesDesc(fields[4])
+	if err != nil {
+		return nil, "", err
+	}
+	req := &DubboRequest{Interface: fields[1], Version: fields[2], Method: fields[3], Types: types, Args: make([]interface{}, len(types))}
+	for idx := range req.Args {
+		if req.Args[idx], err = dec.Decode(); err != nil {
+			return nil, "", fmt.Errorf("invalid argument %d: %v", idx, err)
+		}
+	}
+	attachments, err := dec.Decode()
+	if err != nil && err != io.EOF {
+		return nil, "", fmt.Errorf("invalid attachments: %v", err)
+	}
+	req.Attachments, _ = attachments.(map[string]interface{})
+	if group, ok := req.Attachments["group"].(string); ok {
+		req.Group = group
+	}
+	if path, ok := req.Attachments["interface"].(string); ok && path != "" {
+		req.Interface = path
+	}
+	return req, fields[0], nil
+}
+
+func (ds *DubboServer) notifyListeners(mtd, direction, from, to string, msg interface{}) {
+	if len(ds.listeners) == 0 {
+		return
+	}
+	b, err := json.Marshal(msg)
+	if err != nil {
+		logger.Errorf("protocols/dubbo", "failed to encode message of %s: %v", mtd, err)
+		return
+	}
+	for _, listener := range ds.listeners {
+		if err := listener(mtd, direction, from, to, string(b), 1); err != nil {
+			logger.Errorf("protocols/dubbo", "listener failed to handle message of %s: %v", mtd, err)
+		}
+	}
+}
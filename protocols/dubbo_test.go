@@ -0,0 +1,188 @@
+package protocols
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestJavaTypesDesc(t *testing.T) {
+	Convey("java types to descriptor and back", t, func() {
+		types := []string{"int", "java.lang.String", "long[]", "com.foo.User[][]", "boolean"}
+		desc := javaTypesDesc(types)
+		So(desc, ShouldEqual, "ILjava/lang/String;[J[[Lcom/foo/User;Z")
+		parsed, err := parseJavaTypesDesc(desc)
+		So(err, ShouldBeNil)
+		So(parsed, ShouldResemble, types)
+
+		parsed, err = parseJavaTypesDesc("")
+		So(err, ShouldBeNil)
+		So(parsed, ShouldBeEmpty)
+		_, err = parseJavaTypesDesc("Ljava/lang/String")
+		So(err, ShouldNotBeNil)
+		_, err = parseJavaTypesDesc("X")
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestDubboVersion(t *testing.T) {
+	Convey("attachments in response are supported by 2.0.2 to 2.0.99", t, func() {
+		So(dubboIntVersion("2.0.2"), ShouldEqual, 2000200)
+		So(dubboIntVersion("2.7.3.1"), ShouldEqual, 2070301)
+		So(dubboIntVersion("2.0.2-SNAPSHOT"), ShouldEqual, 2000200)
+		for _, v := range []string{"2.0.2", "2.0.10", "2.0.99"} {
+			So(supportResponseAttachment(v), ShouldBeTrue)
+		}
+		for _, v := range []string{"", "2.0.0", "2.0.1", "2.6.5", "2.5.3", "3.0.0", "x"} {
+			So(supportResponseAttachment(v), ShouldBeFalse)
+		}
+	})
+}
+
+func TestDubbo(t *testing.T) {
+	s, _ := NewDubboServer(":4995")
+	messages := []string{}
+	s.AddListener(func(mtd, direction, from, to, body string, seq int) error {
+		messages = append(messages, direction+" "+mtd+" "+body)
+		return nil
+	})
+	s.SetMethodHandler("com.foo.Greeter.hello", func(req *DubboRequest, resp *DubboResponse) error {
+		resp.Value = "hello " + req.Args[0].(string)
+		return nil
+	})
+	s.SetMethodHandler("g1/com.foo.Greeter:1.0.0.hello", func(req *DubboRequest, resp *DubboResponse) error {
+		resp.Value = map[string]interface{}{"@class": "com.foo.Greeting", "message": "hi " + req.Args[0].(string), "count": req.Args[1]}
+		resp.Attachments["traceId"] = req.Attachments["traceId"]
+		return nil
+	})
+	s.SetMethodHandler("com.foo.Greeter.fail", func(req *DubboRequest, resp *DubboResponse) error {
+		return errors.New("something wrong")
+	})
+	s.SetMethodHandler("com.foo.Greeter.nothing", func(req *DubboRequest, resp *DubboResponse) error {
+		return nil
+	})
+	s.Start()
+	defer s.Close()
+	time.Sleep(50 * time.Millisecond)
+
+	Convey("invoke with default handler", t, func() {
+		c, err := NewDubboClient("127.0.0.1:4995", "com.foo.Greeter", "", "", time.Second)
+		So(err, ShouldBeNil)
+		defer c.Close()
+		out, err := c.InvokeRPC("hello", `{"types": ["java.lang.String"], "args": ["you"]}`)
+		So(err, ShouldBeNil)
+		So(out, ShouldEqual, "hello you")
+		So(messages[len(messages)-2], ShouldStartWith, `in com.foo.Greeter.hello {"interface":"com.foo.Greeter"`)
+		So(messages[len(messages)-1], ShouldStartWith, `out com.foo.Greeter.hello {"value":"hello you"`)
+
+		out, err = c.InvokeRPC("nothing", nil)
+		So(err, ShouldBeNil)
+		So(out, ShouldBeNil)
+	})
+
+	Convey("invoke with group and version", t, func() {
+		c, err := NewDubboClient("127.0.0.1:4995", "com.foo.Greeter", "1.0.0", "g1", time.Second)
+		So(err, ShouldBeNil)
+		defer c.Close()
+		out, err := c.InvokeRPC("hello", map[string]interface{}{
+			"types":       []string{"java.lang.String", "int"},
+			"args":        []interface{}{"you", 3},
+			"attachments": map[string]interface{}{"traceId": "abc"},
+		})
+		So(err, ShouldBeNil)
+		So(out, ShouldResemble, map[string]interface{}{"@class": "com.foo.Greeting", "message": "hi you", "count": int32(3)})
+
+		// fallback to handler without group and version
+		c2, err := NewDubboClient("127.0.0.1:4995", "com.foo.Greeter", "2.0.0", "g2", time.Second)
+		So(err, ShouldBeNil)
+		defer c2.Close()
+		out, err = c2.InvokeRPC("hello", &DubboRequest{Types: []string{"java.lang.String"}, Args: []interface{}{"me"}})
+		So(err, ShouldBeNil)
+		So(out, ShouldEqual, "hello me")
+	})
+
+	Convey("exception and missing handler", t, func() {
+		c, err := NewDubboClient("127.0.0.1:4995", "com.foo.Greeter", "", "", time.Second)
+		So(err, ShouldBeNil)
+		defer c.Close()
+		_, err = c.InvokeRPC("fail", nil)
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldEqual, "java.lang.RuntimeException: something wrong")
+
+		_, err = c.InvokeRPC("notexist", nil)
+		So(err, ShouldNotBeNil)
+		So(strings.Contains(err.Error(), "handler for method com.foo.Greeter.notexist not found"), ShouldBeTrue)
+
+		_, err = c.InvokeRPC("hello", `{"types": ["java.lang.String"], "args": []}`)
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("panic of handler is responded as exception", t, func() {
+		s.SetMethodHandler("com.foo.Greeter.panic", func(req *DubboRequest, resp *DubboResponse) error {
+			panic("boom")
+		})
+		defer s.RemoveMethodHandler("com.foo.Greeter.panic")
+		c, err := NewDubboClient("127.0.0.1:4995", "com.foo.Greeter", "", "", time.Second)
+		So(err, ShouldBeNil)
+		defer c.Close()
+		_, err = c.InvokeRPC("panic", nil)
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "boom")
+		// connection is still usable
+		_, err = c.InvokeRPC("fail", nil)
+		So(err.Error(), ShouldEqual, "java.lang.RuntimeException: something wrong")
+	})
+
+	Convey("pending results are delivered without blocking", t, func() {
+		ch := make(chan *dubboResult, 1)
+		deliverDubboResult(ch, &dubboResult{body: []byte("a")})
+		deliverDubboResult(ch, &dubboResult{err: io.EOF})
+		So(string((<-ch).body), ShouldEqual, "a")
+	})
+
+	Convey("calls fail immediately when connection is broken", t, func() {
+		lis, err := net.Listen("tcp", "127.0.0.1:4982")
+		So(err, ShouldBeNil)
+		defer lis.Close()
+		go func() {
+			conn, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			readDubboPacket(bufio.NewReader(conn)) // close after request received
+			conn.Close()
+		}()
+
+		c, err := NewDubboClient("127.0.0.1:4982", "com.foo.Greeter", "", "", 5*time.Second)
+		So(err, ShouldBeNil)
+		defer c.Close()
+		start := time.Now()
+		_, err = c.InvokeRPC("hello", map[string]interface{}{"types": []string{"java.lang.String"}, "args": []interface{}{"you"}})
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "broken")
+		_, err = c.InvokeRPC("hello", map[string]interface{}{"types": []string{"java.lang.String"}, "args": []interface{}{"you"}})
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "broken")
+		So(time.Since(start), ShouldBeLessThan, time.Second)
+	})
+
+	Convey("remove handler and invalid client", t, func() {
+		So(s.SetMethodHandler("nodot", nil), ShouldNotBeNil)
+		s.RemoveMethodHandler("com.foo.Greeter.nothing")
+		c, _ := NewDubboClient("127.0.0.1:4995", "com.foo.Greeter", "", "", time.Second)
+		defer c.Close()
+		_, err := c.InvokeRPC("nothing", nil)
+		So(err, ShouldNotBeNil)
+
+		_, err = NewDubboClient("127.0.0.1:4995", "", "", "", time.Second)
+		So(err, ShouldNotBeNil)
+		_, err = NewDubboClient("127.0.0.1:4994", "com.foo.Greeter", "", "", time.Second)
+		So(err, ShouldNotBeNil)
+	})
+}
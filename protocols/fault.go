@@ -0,0 +1,281 @@
+package protocols
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/feiyuw/simgo/logger"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// fault of this method is used for methods without their own faults
+	AllMethods = "*"
+)
+
+// faults injected to calls of a method, applied in order of connection reset, latency,
+// dropped response and error, stream limit is applied to streaming calls not failed by them
+type Fault struct {
+	Latency     *LatencyFault `json:"latency,omitempty"`
+	Error       *ErrorFault   `json:"error,omitempty"`
+	ResetRate   float64       `json:"resetRate,omitempty"`   // probability of connection reset
+	DropRate    float64       `json:"dropRate,omitempty"`    // probability of response dropped, call hangs until deadline or canceled
+	StreamLimit int           `json:"streamLimit,omitempty"` // terminate stream with ABORTED after N response messages
+}
+
+// latency before handling, distribution can be fixed(default), uniform, normal or exponential
+type LatencyFault struct {
+	Distribution string  `json:"distribution,omitempty"`
+	Value        string  `json:"value,omitempty"`  // latency of fixed distribution, eg. 100ms
+	Min          string  `json:"min,omitempty"`    // range of uniform distribution
+	Max          string  `json:"max,omitempty"`    //
+	Mean         string  `json:"mean,omitempty"`   // mean of normal and exponential distribution
+	Stddev       string  `json:"stddev,omitempty"` // standard deviation of normal distribution
+	Rate         float64 `json:"rate,omitempty"`   // probability of latency, always if 0
+
+	value, min, max, mean, stddev time.Duration
+}
+
+// error returned instead of calling handler, code is chosen randomly from codes
+type ErrorFault struct {
+	Rate    float64      `json:"rate"`
+	Codes   []codes.Code `json:"codes,omitempty"` // UNAVAILABLE if empty
+	Message string       `json:"message,omitempty"`
+}
+
+func (f *Fault) compile() error {
+	for _, rate := range []float64{f.ResetRate, f.DropRate} {
+		if err := checkRate(rate); err != nil {
+			return err
+		}
+	}
+	if f.StreamLimit < 0 {
+		return errors.New("stream limit should not be negative")
+	}
+	if f.Error != nil {
+		if err := checkRate(f.Error.Rate); err != nil {
+			return err
+		}
+		for _, code := range f.Error.Codes {
+			if code == codes.OK {
+				return errors.New("error code should not be OK")
+			}
+		}
+	}
+	if f.Latency != nil {
+		return f.Latency.compile()
+	}
+	return nil
+}
+
+func checkRate(rate float64) error {
+	if rate < 0 || rate > 1 {
+		return fmt.Errorf("invalid rate %v, should be in [0, 1]", rate)
+	}
+	return nil
+}
+
+func (lf *LatencyFault) compile() error {
+	if err := checkRate(lf.Rate); err != nil {
+		return err
+	}
+	durations := []struct {
+		s string
+		d *time.Duration
+	}{{lf.Value, &lf.value}, {lf.Min, &lf.min}, {lf.Max, &lf.max}, {lf.Mean, &lf.mean}, {lf.Stddev, &lf.stddev}}
+	for _, item := range durations {
+		if item.s == "" {
+			continue
+		}
+		d, err := time.ParseDuration(item.s)
+		if err != nil || d < 0 {
+			return fmt.Errorf("invalid latency %s", item.s)
+		}
+		*item.d = d
+	}
+
+	switch lf.Distribution {
+	case "", "fixed":
+	case "uniform":
+		if lf.max < lf.min {
+			return errors.New("max latency should not be less than min")
+		}
+	case "normal", "exponential":
+	default:
+		return fmt.Errorf("unsupported latency distribution: %s", lf.Distribution)
+	}
+	return nil
+}
+
+// random latency of the distribution, 0 if not applied
+func (lf *LatencyFault) next() time.Duration {
+	if lf.Rate > 0 && rand.Float64() >= lf.Rate {
+		return 0
+	}
+
+	switch lf.Distribution {
+	case "uniform":
+		return lf.min + time.Duration(rand.Int63n(int64(lf.max-lf.min)+1))
+	case "normal":
+		if d := time.Duration(rand.NormFloat64()*float64(lf.stddev)) + lf.mean; d > 0 {
+			return d
+		}
+		return 0
+	case "exponential":
+		return time.Duration(rand.ExpFloat64() * float64(lf.mean))
+	default:
+		return lf.value
+	}
+}
+
+// set fault of method at runtime, AllMethods for methods without their own faults
+func (gs *GrpcServer) SetFault(mtd string, fault *Fault) error {
+	if err := fault.compile(); err != nil {
+		return err
+	}
+	gs.faultLock.Lock()
+	defer gs.faultLock.Unlock()
+	gs.faults[mtd] = fault
+	return nil
+}
+
+func (gs *GrpcServer) RemoveFault(mtd string) {
+	gs.faultLock.Lock()
+	defer gs.faultLock.Unlock()
+	delete(gs.faults, mtd)
+}
+
+// faults of all methods
+func (gs *GrpcServer) Faults() map[string]*Fault {
+	gs.faultLock.RLock()
+	defer gs.faultLock.RUnlock()
+	faults := make(map[string]*Fault, len(gs.faults))
+	for mtd, fault := range gs.faults {
+		faults[mtd] = fault
+	}
+	return faults
+}
+
+func (gs *GrpcServer) getFault(mtd string) *Fault {
+	gs.faultLock.RLock()
+	defer gs.faultLock.RUnlock()
+	if fault, exists := gs.faults[mtd]; exists {
+		return fault
+	}
+	return gs.faults[AllMethods]
+}
+
+// inject faults before handling a call, error returned if the call is failed by them
+func (gs *GrpcServer) injectFault(ctx context.Context, mtd string, fault *Fault, peerAddr string) error {
+	if fault.ResetRate > 0 && rand.Float64() < fault.ResetRate {
+		logger.Infof("protocols/grpc", "reset connection of %s from %s by fault", mtd, peerAddr)
+		gs.resetConn(peerAddr)
+		return status.Error(codes.Unavailable, "connection reset by fault")
+	}
+	if fault.Latency != nil {
+		if d := fault.Latency.next(); d > 0 {
+			select {
+			case <-time.After(d):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+	if fault.DropRate > 0 && rand.Float64() < fault.DropRate {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	if fault.Error != nil && fault.Error.Rate > 0 && rand.Float64() < fault.Error.Rate {
+		code := codes.Unavailable
+		if len(fault.Error.Codes) > 0 {
+			code = fault.Error.Codes[rand.Intn(len(fault.Error.Codes))]
+		}
+		msg := fault.Error.Message
+		if msg == "" {
+			msg = "injected fault"
+		}
+		return status.Error(code, msg)
+	}
+	return nil
+}
+
+// close connection of peer without waiting for pending data
+func (gs *GrpcServer) resetConn(peerAddr string) {
+	conn, ok := gs.conns.Load(peerAddr)
+	if !ok {
+		return
+	}
+	if tcpConn, ok := conn.(*trackedConn).Conn.(*net.TCPConn); ok {
+		tcpConn.SetLinger(0)
+	}
+	conn.(*trackedConn).Close()
+}
+
+// listener keeps accepted connections by remote address, so that they can be reset
+type trackedListener struct {
+	net.Listener
+	conns *sync.Map
+}
+
+func (tl *trackedListener) Accept() (net.Conn, error) {
+	conn, err := tl.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	tc := &trackedConn{Conn: conn, conns: tl.conns}
+	tl.conns.Store(conn.RemoteAddr().String(), tc)
+	return tc, nil
+}
+
+type trackedConn struct {
+	net.Conn
+	conns *sync.Map
+}
+
+func (tc *trackedConn) Close() error {
+	tc.conns.Delete(tc.RemoteAddr().String())
+	return tc.Conn.Close()
+}
+
+// stream terminated after limit response messages sent, both SendMsg and RecvMsg fail then
+type limitedStream struct {
+	grpc.ServerStream
+	limit int
+	sent  int
+	err   error
+}
+
+func (ls *limitedStream) SendMsg(m interface{}) error {
+	if ls.err != nil {
+		return ls.err
+	}
+	if ls.sent >= ls.limit {
+		ls.err = status.Errorf(codes.Aborted, "stream terminated after %d messages by fault", ls.limit)
+		return ls.err
+	}
+	ls.sent++
+	return ls.ServerStream.SendMsg(m)
+}
+
+func (ls *limitedStream) RecvMsg(m interface{}) error {
+	if ls.err != nil {
+		return ls.err
+	}
+	return ls.ServerStream.RecvMsg(m)
+}
+
+// error of handling the stream, the termination error if stream terminated by limit
+func (ls *limitedStream) wrapErr(err error) error {
+	if ls != nil && ls.err != nil {
+		return ls.err
+	}
+	return err
+}
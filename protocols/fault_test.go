@@ -0,0 +1,133 @@
+package protocols
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/jhump/protoreflect/dynamic"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+func TestLatencyFault(t *testing.T) {
+	Convey("latency distributions", t, func() {
+		lf := &LatencyFault{Value: "10ms"}
+		So(lf.compile(), ShouldBeNil)
+		So(lf.next(), ShouldEqual, 10*time.Millisecond)
+
+		lf = &LatencyFault{Distribution: "uniform", Min: "10ms", Max: "20ms"}
+		So(lf.compile(), ShouldBeNil)
+		for i := 0; i < 100; i++ {
+			d := lf.next()
+			So(d, ShouldBeBetweenOrEqual, 10*time.Millisecond, 20*time.Millisecond)
+		}
+
+		lf = &LatencyFault{Distribution: "normal", Mean: "10ms", Stddev: "100ms"}
+		So(lf.compile(), ShouldBeNil)
+		for i := 0; i < 100; i++ {
+			So(lf.next(), ShouldBeGreaterThanOrEqualTo, 0)
+		}
+
+		lf = &LatencyFault{Distribution: "exponential", Mean: "10ms", Rate: 0.000001}
+		So(lf.compile(), ShouldBeNil)
+		So(lf.next(), ShouldEqual, 0)
+	})
+
+	Convey("invalid faults", t, func() {
+		So((&Fault{Latency: &LatencyFault{Value: "x"}}).compile(), ShouldNotBeNil)
+		So((&Fault{Latency: &LatencyFault{Distribution: "uniform", Min: "2s", Max: "1s"}}).compile(), ShouldNotBeNil)
+		So((&Fault{Latency: &LatencyFault{Distribution: "poisson"}}).compile(), ShouldNotBeNil)
+		So((&Fault{ResetRate: -1}).compile(), ShouldNotBeNil)
+		So((&Fault{StreamLimit: -1}).compile(), ShouldNotBeNil)
+		So((&Fault{Error: &ErrorFault{Rate: 1, Codes: []codes.Code{codes.OK}}}).compile(), ShouldNotBeNil)
+	})
+}
+
+func TestGrpcServerFault(t *testing.T) {
+	s, _ := NewGrpcServer(":4989", []string{"echo.proto"})
+	s.SetMethodHandler("grpc.examples.echo.Echo.UnaryEcho", func(in *dynamic.Message, out *dynamic.Message, stream grpc.ServerStream) error {
+		out.SetFieldByName("message", in.GetFieldByName("message"))
+		return nil
+	})
+	s.SetMethodHandler("grpc.examples.echo.Echo.ServerStreamingEcho", func(in *dynamic.Message, out *dynamic.Message, stream grpc.ServerStream) error {
+		stream.RecvMsg(in)
+		for i := 0; i < 3; i++ {
+			out.SetFieldByName("message", in.GetFieldByName("message"))
+			if err := stream.SendMsg(out); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	s.Start()
+	defer s.Close()
+	time.Sleep(time.Millisecond) // make sure server started
+
+	client, _ := NewGrpcClient("127.0.0.1:4989", []string{"echo.proto"}, grpc.WithInsecure())
+	defer client.Close()
+	req := map[string]interface{}{"message": "x"}
+
+	Convey("latency", t, func() {
+		So(s.SetFault("grpc.examples.echo.Echo.UnaryEcho", &Fault{Latency: &LatencyFault{Value: "50ms"}}), ShouldBeNil)
+		defer s.RemoveFault("grpc.examples.echo.Echo.UnaryEcho")
+		start := time.Now()
+		_, err := client.InvokeRPC("grpc.examples.echo.Echo.UnaryEcho", req)
+		So(err, ShouldBeNil)
+		So(time.Since(start), ShouldBeGreaterThanOrEqualTo, 50*time.Millisecond)
+	})
+
+	Convey("error rate applies to all methods", t, func() {
+		So(s.SetFault(AllMethods, &Fault{Error: &ErrorFault{Rate: 1, Codes: []codes.Code{codes.Unavailable, codes.Internal}}}), ShouldBeNil)
+		result, err := client.Invoke("grpc.examples.echo.Echo.UnaryEcho", req, nil)
+		So(err, ShouldBeNil)
+		So(result.Code, ShouldBeIn, codes.Unavailable, codes.Internal)
+		So(result.Message, ShouldEqual, "injected fault")
+
+		// method fault takes precedence
+		So(s.SetFault("grpc.examples.echo.Echo.UnaryEcho", &Fault{}), ShouldBeNil)
+		result, _ = client.Invoke("grpc.examples.echo.Echo.UnaryEcho", req, nil)
+		So(result.Code, ShouldEqual, codes.OK)
+		So(len(s.Faults()), ShouldEqual, 2)
+		s.RemoveFault(AllMethods)
+		s.RemoveFault("grpc.examples.echo.Echo.UnaryEcho")
+		So(s.Faults(), ShouldBeEmpty)
+	})
+
+	Convey("dropped response hangs until deadline", t, func() {
+		So(s.SetFault("grpc.examples.echo.Echo.UnaryEcho", &Fault{DropRate: 1}), ShouldBeNil)
+		defer s.RemoveFault("grpc.examples.echo.Echo.UnaryEcho")
+		result, _ := client.Invoke("grpc.examples.echo.Echo.UnaryEcho", req, &InvokeOptions{Timeout: "50ms"})
+		So(result.Code, ShouldEqual, codes.DeadlineExceeded)
+	})
+
+	Convey("stream terminated after N messages", t, func() {
+		So(s.SetFault("grpc.examples.echo.Echo.ServerStreamingEcho", &Fault{StreamLimit: 2}), ShouldBeNil)
+		defer s.RemoveFault("grpc.examples.echo.Echo.ServerStreamingEcho")
+		result, _ := client.Invoke("grpc.examples.echo.Echo.ServerStreamingEcho", req, nil)
+		So(result.Code, ShouldEqual, codes.Aborted)
+		So(len(result.Messages), ShouldEqual, 2)
+	})
+
+	Convey("methods without handler return missing handler status instead of faults", t, func() {
+		So(s.SetFault(AllMethods, &Fault{Error: &ErrorFault{Rate: 1, Codes: []codes.Code{codes.Internal}}}), ShouldBeNil)
+		defer s.RemoveFault(AllMethods)
+		result, _ := client.Invoke("grpc.examples.echo.Echo.UnaryEcho", req, nil)
+		So(result.Code, ShouldEqual, codes.Internal)
+		for _, mtd := range []string{"grpc.examples.echo.Echo.ClientStreamingEcho", "grpc.examples.echo.Echo.BidirectionalStreamingEcho"} {
+			result, _ = client.Invoke(mtd, req, nil)
+			So(result.Code, ShouldEqual, codes.Unimplemented)
+		}
+	})
+
+	Convey("connection reset", t, func() {
+		So(s.SetFault("grpc.examples.echo.Echo.UnaryEcho", &Fault{ResetRate: 1}), ShouldBeNil)
+		result, _ := client.Invoke("grpc.examples.echo.Echo.UnaryEcho", req, nil)
+		So(result.Code, ShouldEqual, codes.Unavailable)
+
+		s.RemoveFault("grpc.examples.echo.Echo.UnaryEcho")
+		result, _ = client.Invoke("grpc.examples.echo.Echo.UnaryEcho", req, &InvokeOptions{WaitForReady: true, Timeout: "5s"})
+		So(result.Code, ShouldEqual, codes.OK)
+	})
+}
@@ -5,23 +5,35 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net"
 	"reflect"
+	"runtime/debug"
+	"sort"
+	"sync"
+	"time"
 
 	"github.com/feiyuw/simgo/logger"
 
 	"github.com/fullstorydev/grpcurl"
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/golang/protobuf/proto"
 	"github.com/jhump/protoreflect/desc"
 	"github.com/jhump/protoreflect/dynamic"
 	"github.com/jhump/protoreflect/grpcreflect"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/encoding"
+	_ "google.golang.org/grpc/encoding/gzip" // register gzip compressor for clients and servers
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/peer"
 	rpb "google.golang.org/grpc/reflection/grpc_reflection_v1alpha"
+	"google.golang.org/grpc/status"
 )
 
 // ================================== client ==================================
 var (
-	clientCTX = context.Background() // TODO: add timeout, dialtime options
+	clientCTX = context.Background() // base context of calls, timeout and deadline are set per call by InvokeOptions
 )
 
 // client
@@ -35,8 +47,12 @@ type GrpcClient struct {
 // if protos set, will get services and methods from proto files
 // if addr set but protos empty, will get services and methods from server reflection
 func NewGrpcClient(addr string, protos []string, opts ...grpc.DialOption) (*GrpcClient, error) {
-	var descSource grpcurl.DescriptorSource
+	return NewGrpcClientWithSource(addr, &ProtoSource{Protos: protos}, opts...)
+}
 
+// create a grpc client with services and methods from source, server reflection is used if source is empty,
+// or after protos and protosets if source reflection set
+func NewGrpcClientWithSource(addr string, src *ProtoSource, opts ...grpc.DialOption) (*GrpcClient, error) {
 	if addr == "" {
 		return nil, fmt.Errorf("addr should not be empty")
 	}
@@ -46,20 +62,26 @@ func NewGrpcClient(addr string, protos []string, opts ...grpc.DialOption) (*Grpc
 		return nil, fmt.Errorf("did not connect: %v", err)
 	}
 
-	if len(protos) > 0 {
-		descSource, err := grpcurl.DescriptorSourceFromProtoFiles([]string{}, protos...)
+	sources := compositeSource{}
+	if !src.empty() {
+		descSource, err := src.descriptorSource()
 		if err != nil {
-			return nil, fmt.Errorf("cannot parse proto file: %v", err)
+			conn.Close()
+			return nil, err
 		}
-		return &GrpcClient{addr: addr, conn: conn, desc: descSource}, nil
+		sources = append(sources, descSource)
+	}
+	if src.empty() || src.Reflection {
+		// fetch from server reflection RPC, after protos and protosets
+		c := rpb.NewServerReflectionClient(conn)
+		refClient := grpcreflect.NewClient(clientCTX, c)
+		sources = append(sources, grpcurl.DescriptorSourceFromServer(clientCTX, refClient))
+	}
+	if len(sources) == 1 {
+		return &GrpcClient{addr: addr, conn: conn, desc: sources[0]}, nil
 	}
 
-	// fetch from server reflection RPC
-	c := rpb.NewServerReflectionClient(conn)
-	refClient := grpcreflect.NewClient(clientCTX, c)
-	descSource = grpcurl.DescriptorSourceFromServer(clientCTX, refClient)
-
-	return &GrpcClient{addr: addr, conn: conn, desc: descSource}, nil
+	return &GrpcClient{addr: addr, conn: conn, desc: sources}, nil
 }
 
 func (gc *GrpcClient) ListServices() ([]string, error) {
@@ -96,34 +118,157 @@ func (rr *rpcResponse) Write(p []byte) (int, error) {
 	return n, err
 }
 
-func (rr *rpcResponse) ToJSON() (interface{}, error) {
-	switch len(rr.messages) {
-	case 0:
-		return map[string]interface{}{}, nil
-	case 1:
-		resp := make(map[string]interface{})
-		if err := json.Unmarshal(rr.messages[0].Bytes(), &resp); err != nil {
+// decode response messages in json
+func (rr *rpcResponse) decode() ([]map[string]interface{}, error) {
+	msgs := make([]map[string]interface{}, len(rr.messages))
+	for idx, msg := range rr.messages {
+		oneMsg := make(map[string]interface{})
+		if err := json.Unmarshal(msg.Bytes(), &oneMsg); err != nil {
 			return nil, err
 		}
+		msgs[idx] = oneMsg
+	}
+	return msgs, nil
+}
 
-		return resp, nil
-	default:
-		resp := make([]map[string]interface{}, len(rr.messages))
-		for idx, msg := range rr.messages {
-			oneResp := make(map[string]interface{})
-			if err := json.Unmarshal(msg.Bytes(), &oneResp); err != nil {
-				return nil, err
-			}
-			resp[idx] = oneResp
+// options of one call
+type InvokeOptions struct {
+	Headers      map[string]string `json:"headers"`      // outgoing metadata
+	Timeout      string            `json:"timeout"`      // deadline of the call, eg. 500ms
+	Compression  string            `json:"compression"`  // compressor of requests, eg. gzip
+	WaitForReady bool              `json:"waitForReady"` // wait for connection ready instead of failing fast
+}
+
+// context with deadline, headers in "key: value" format and call options of one call,
+// cancel should be called after the call finished
+func (opts *InvokeOptions) prepare(ctx context.Context) (context.Context, context.CancelFunc, []string, []grpc.CallOption, error) {
+	if opts == nil {
+		opts = &InvokeOptions{}
+	}
+
+	callOpts := []grpc.CallOption{}
+	if opts.Compression != "" {
+		if encoding.GetCompressor(opts.Compression) == nil {
+			return nil, nil, nil, nil, fmt.Errorf("unsupported compression: %s", opts.Compression)
 		}
-		return resp, nil
+		callOpts = append(callOpts, grpc.UseCompressor(opts.Compression))
+	}
+	if opts.WaitForReady {
+		callOpts = append(callOpts, grpc.WaitForReady(true))
 	}
+	headers := make([]string, 0, len(opts.Headers))
+	for k, v := range opts.Headers {
+		headers = append(headers, k+": "+v)
+	}
+	sort.Strings(headers)
+
+	if opts.Timeout == "" {
+		ctx, cancel := context.WithCancel(ctx)
+		return ctx, cancel, headers, callOpts, nil
+	}
+	timeout, err := time.ParseDuration(opts.Timeout)
+	if err != nil {
+		return nil, nil, nil, nil, fmt.Errorf("invalid timeout: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	return ctx, cancel, headers, callOpts, nil
+}
+
+// result of one call with response messages, headers, trailers and final status
+type GrpcResult struct {
+	Messages []map[string]interface{} `json:"messages"`
+	Header   metadata.MD              `json:"header"`
+	Trailer  metadata.MD              `json:"trailer"`
+	Code     codes.Code               `json:"code"`
+	CodeName string                   `json:"codeName"` // eg. OK, NotFound
+	Message  string                   `json:"message"`
+	Details  []json.RawMessage        `json:"details"` // see NewStatus
+	Latency  time.Duration            `json:"latency"` // in nanoseconds
+
+	status *status.Status
 }
 
+// create result of an error which occurred before the call finished, eg. unknown method
+func NewGrpcErrorResult(err error) *GrpcResult {
+	return newGrpcResult(status.Convert(err))
+}
+
+func newGrpcResult(st *status.Status) *GrpcResult {
+	return &GrpcResult{
+		Messages: []map[string]interface{}{},
+		Code:     st.Code(),
+		CodeName: st.Code().String(),
+		Message:  st.Message(),
+		Details:  statusDetails(st),
+		status:   st,
+	}
+}
+
+// error of the final status, nil if status is OK
+func (gr *GrpcResult) Err() error {
+	return gr.status.Err()
+}
+
+// invoke method and returns *GrpcResult, error of the status returned together if it is not OK
 func (gc *GrpcClient) InvokeRPC(mtdName string, reqData interface{}) (interface{}, error) {
-	var in bytes.Buffer
+	result, err := gc.Invoke(mtdName, reqData, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return result, result.Err()
+}
+
+// invoke method with call options, result is returned even if status is not OK
+func (gc *GrpcClient) Invoke(mtdName string, reqData interface{}, opts *InvokeOptions) (*GrpcResult, error) {
+	return gc.InvokeContext(clientCTX, mtdName, reqData, opts)
+}
+
+// invoke method like Invoke, the call is canceled with ctx
+func (gc *GrpcClient) InvokeContext(ctx context.Context, mtdName string, reqData interface{}, opts *InvokeOptions) (*GrpcResult, error) {
 	var out = rpcResponse{messages: []bytes.Buffer{}}
 
+	in, err := encodeRequestData(reqData)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel, headers, callOpts, err := opts.prepare(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	rf, formatter, err := grpcurl.RequestParserAndFormatterFor(grpcurl.FormatJSON, gc.desc, true, false, in)
+	if err != nil {
+		return nil, err
+	}
+	h := &responseEventHandler{DefaultEventHandler: grpcurl.NewDefaultEventHandler(&out, gc.desc, formatter, false)}
+	start := time.Now()
+	if err = grpcurl.InvokeRPC(ctx, gc.desc, &callOptionsChannel{ClientConn: gc.conn, opts: callOpts}, mtdName, headers, h, rf.Next); err != nil {
+		return nil, err
+	}
+	latency := time.Since(start)
+
+	msgs, err := out.decode()
+	if err != nil {
+		return nil, err
+	}
+	st := h.Status
+	if st == nil {
+		st = status.New(codes.OK, "")
+	}
+	result := newGrpcResult(st)
+	result.Messages = msgs
+	result.Header = h.header
+	result.Trailer = h.trailer
+	result.Latency = latency
+	return result, nil
+}
+
+// request messages in json, reqData can be a map, a slice of maps or a json string
+func encodeRequestData(reqData interface{}) (*bytes.Buffer, error) {
+	var in bytes.Buffer
+
 	switch reflect.TypeOf(reqData).Kind() {
 	case reflect.Slice:
 		for _, data := range reqData.([]map[string]interface{}) {
@@ -142,53 +287,95 @@ func (gc *GrpcClient) InvokeRPC(mtdName string, reqData interface{}) (interface{
 	default:
 		in.WriteString(reqData.(string))
 	}
+	return &in, nil
+}
 
-	rf, formatter, err := grpcurl.RequestParserAndFormatterFor(grpcurl.FormatJSON, gc.desc, true, false, &in)
-	if err != nil {
-		return nil, err
-	}
-	h := grpcurl.NewDefaultEventHandler(&out, gc.desc, formatter, false)
-	if err = grpcurl.InvokeRPC(clientCTX, gc.desc, gc.conn, mtdName, []string{}, h, rf.Next); err != nil {
-		return nil, err
-	}
+// event handler which keeps headers and trailers of response
+type responseEventHandler struct {
+	*grpcurl.DefaultEventHandler
+	header  metadata.MD
+	trailer metadata.MD
+}
+
+func (h *responseEventHandler) OnReceiveHeaders(md metadata.MD) {
+	h.header = md
+	h.DefaultEventHandler.OnReceiveHeaders(md)
+}
+
+func (h *responseEventHandler) OnReceiveTrailers(stat *status.Status, md metadata.MD) {
+	h.trailer = md
+	h.DefaultEventHandler.OnReceiveTrailers(stat, md)
+}
+
+// client connection which adds call options to every call
+type callOptionsChannel struct {
+	*grpc.ClientConn
+	opts []grpc.CallOption
+}
+
+func (ch *callOptionsChannel) Invoke(ctx context.Context, method string, args, reply interface{}, opts ...grpc.CallOption) error {
+	return ch.ClientConn.Invoke(ctx, method, args, reply, append(ch.opts, opts...)...)
+}
 
-	return out.ToJSON()
+func (ch *callOptionsChannel) NewStream(ctx context.Context, desc *grpc.StreamDesc, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	return ch.ClientConn.NewStream(ctx, desc, method, append(ch.opts, opts...)...)
 }
 
 // ================================== server ==================================
 
 type GrpcServer struct {
-	addr      string
-	desc      grpcurl.DescriptorSource
-	server    *grpc.Server
-	handlerM  map[string]func(in *dynamic.Message, out *dynamic.Message, stream grpc.ServerStream) error
-	listeners []func(mtd, direction, from, to, body string) error
+	addr         string
+	desc         grpcurl.DescriptorSource
+	server       *grpc.Server
+	lis          net.Listener // closed by Close too, as it may be before serving
+	handlerM     map[string][]*MethodRule
+	handlerLock  sync.RWMutex
+	listeners    []func(mtd, direction, from, to, body string, seq int) error
+	listenerLock sync.RWMutex // listeners can be added to running server
+	proxy        *GrpcClient  // backend of methods without handler
+	recorders    []func(record *GrpcRecord)
+	received     ReceivedSource // request messages for verification, journal of server by default
+	faults       map[string]*Fault
+	faultLock    sync.RWMutex
+	conns        sync.Map       // accepted connections by remote address
+	health       *healthService // nil if health service is defined in proto files
+	autoMock     *AutoMock      // responds methods without handler and proxy, nil if disabled
+	missingCode  codes.Code     // of methods without handler, proxy and auto mock, or requests matching no rule
 }
 
 // create a new grpc server
 func NewGrpcServer(addr string, protos []string, opts ...grpc.ServerOption) (*GrpcServer, error) {
-	descFromProto, err := grpcurl.DescriptorSourceFromProtoFiles([]string{}, protos...)
+	return NewGrpcServerWithSource(addr, &ProtoSource{Protos: protos}, opts...)
+}
+
+// create a grpc server of services from source
+func NewGrpcServerWithSource(addr string, src *ProtoSource, opts ...grpc.ServerOption) (*GrpcServer, error) {
+	descFromProto, err := src.descriptorSource()
 	if err != nil {
-		return nil, fmt.Errorf("cannot parse proto file: %v", err)
+		return nil, err
 	}
 	gs := &GrpcServer{
-		addr:     addr,
-		desc:     descFromProto,
-		server:   grpc.NewServer(opts...),
-		handlerM: map[string]func(in *dynamic.Message, out *dynamic.Message, stream grpc.ServerStream) error{},
+		addr:        addr,
+		desc:        descFromProto,
+		server:      grpc.NewServer(opts...),
+		handlerM:    map[string][]*MethodRule{},
+		faults:      map[string]*Fault{},
+		missingCode: codes.Unimplemented,
+		received:    &messageJournal{},
 	}
 
-	gs.server = grpc.NewServer()
 	services, err := grpcurl.ListServices(gs.desc)
 	if err != nil {
 		return nil, fmt.Errorf("failed to list services")
 	}
+	files := []*desc.FileDescriptor{}
 	for _, svcName := range services {
 		dsc, err := gs.desc.FindSymbol(svcName)
 		if err != nil {
 			return nil, fmt.Errorf("unable to find service: %s, error: %v", svcName, err)
 		}
 		sd := dsc.(*desc.ServiceDescriptor)
+		files = append(files, sd.GetFile())
 
 		unaryMethods := []grpc.MethodDesc{}
 		streamMethods := []grpc.StreamDesc{}
@@ -219,6 +406,10 @@ func NewGrpcServer(addr string, protos []string, opts ...grpc.ServerOption) (*Gr
 		}
 		gs.server.RegisterService(&svcDesc, &mockServer{})
 	}
+	if err = gs.registerBuiltinServices(files); err != nil {
+		gs.Close()
+		return nil, err
+	}
 
 	return gs, nil
 }
@@ -230,8 +421,10 @@ func (gs *GrpcServer) Start() error {
 	}
 	logger.Infof("protocols/grpc", "server listening at %v", lis.Addr())
 
+	gs.lis = lis
+	server := gs.server // Close resets it, maybe before serving
 	go func() {
-		if err := gs.server.Serve(lis); err != nil {
+		if err := server.Serve(&trackedListener{Listener: lis, conns: &gs.conns}); err != nil {
 			logger.Errorf("protocols/grpc", "failed to serve: %v", err)
 		}
 	}()
@@ -241,39 +434,128 @@ func (gs *GrpcServer) Start() error {
 
 func (gs *GrpcServer) Close() error {
 	if gs.server != nil {
+		if gs.health != nil {
+			gs.health.Shutdown()
+		}
 		gs.server.Stop()
 		gs.server = nil
-		gs.handlerM = map[string]func(in *dynamic.Message, out *dynamic.Message, stream grpc.ServerStream) error{}
+		if gs.lis != nil {
+			gs.lis.Close()
+		}
+		gs.handlerLock.Lock()
+		gs.handlerM = map[string][]*MethodRule{}
+		gs.handlerLock.Unlock()
+		if gs.proxy != nil {
+			gs.proxy.Close()
+		}
 		logger.Infof("protocols/grpc", "grpc server %s stopped", gs.addr)
 	}
 
 	return nil
 }
 
-func (gs *GrpcServer) AddListener(listener func(mtd, direction, from, to, body string) error) {
+// add a listener for all messages received and sent by the server
+// seq is the sequence number of the message in its stream and direction, starts from 1
+func (gs *GrpcServer) AddListener(listener func(mtd, direction, from, to, body string, seq int) error) {
+	gs.listenerLock.Lock()
+	defer gs.listenerLock.Unlock()
 	gs.listeners = append(gs.listeners, listener)
 	logger.Infof("protocols/grpc", "new listener added, now %d listeners", len(gs.listeners))
 }
 
-// set specified method handler, one method only have one handler, it's the highest priority
+// verify request messages received, eg. method X was called N times with field Y,
+// waits until all expectations met if timeout set
+func (gs *GrpcServer) Verify(v *Verification) (*VerifyResult, error) {
+	return verifyWait(gs.received.Received, v, gs.receivedDefaults)
+}
+
+// json of request message with default values, nil if failed
+func (gs *GrpcServer) receivedDefaults(msg *ReceivedMessage) interface{} {
+	dsc, err := gs.desc.FindSymbol(msg.Method)
+	if err != nil {
+		return nil
+	}
+	mtd, ok := dsc.(*desc.MethodDescriptor)
+	if !ok {
+		return nil
+	}
+	in := dynamic.NewMessage(mtd.GetInputType())
+	if err := in.UnmarshalJSON([]byte(msg.Body)); err != nil {
+		return nil
+	}
+	doc, err := messageToJSONValue(in, defaultsMarshaler)
+	if err != nil {
+		return nil
+	}
+	return doc
+}
+
+// clear request messages received, so that later verifications only check new ones
+func (gs *GrpcServer) ClearReceived() {
+	gs.received.ClearReceived()
+}
+
+// verify and clear request messages of source instead of the journal of server
+// NOTE: thread unsafe, should be set before server started
+func (gs *GrpcServer) SetReceivedSource(src ReceivedSource) {
+	gs.received = src
+}
+
+// set specified method handler, it's used for all requests of the method and replaces existing rules
 // if you want to return error, see https://github.com/avinassh/grpc-errors/blob/master/go/server.go
-// NOTE: thread unsafe, use lock in ops level
+// stream of unary method only receives the request and sends the response once, its Context has
+// incoming metadata, deadline and peer, and headers and trailers can be set by it too
 func (gs *GrpcServer) SetMethodHandler(mtd string, handler func(in *dynamic.Message, out *dynamic.Message, stream grpc.ServerStream) error) error {
-	if _, exists := gs.handlerM[mtd]; exists {
-		logger.Warnf("protocols/grpc", "handler for method %s exists, will be overrided", mtd)
+	return gs.SetMethodRules(mtd, []*MethodRule{{Handler: handler}})
+}
+
+// set ordered rules of specified method, the first matched rule handles the request,
+// rule without matcher matches all requests, so it should be the last one as fallback
+// for streaming methods, request fields are matched with the first message of stream
+func (gs *GrpcServer) SetMethodRules(mtd string, rules []*MethodRule) error {
+	return gs.SetMethodsRules(map[string][]*MethodRule{mtd: rules})
+}
+
+// set rules of several methods at once, none of them is set if any rule is invalid
+func (gs *GrpcServer) SetMethodsRules(rulesM map[string][]*MethodRule) error {
+	for mtd, rules := range rulesM {
+		if err := validateMethodRules(mtd, rules); err != nil {
+			return err
+		}
+	}
+	gs.handlerLock.Lock()
+	defer gs.handlerLock.Unlock()
+	for mtd, rules := range rulesM {
+		if _, exists := gs.handlerM[mtd]; exists {
+			logger.Warnf("protocols/grpc", "handler for method %s exists, will be overrided", mtd)
+		}
+		gs.handlerM[mtd] = rules
 	}
-	gs.handlerM[mtd] = handler
 	return nil
 }
 
-// NOTE: thread unsafe, use lock in ops level
-func (gs *GrpcServer) RemoveMethodHandler(mtd string) error {
-	if _, exists := gs.handlerM[mtd]; exists {
-		delete(gs.handlerM, mtd)
+// check rules of method have handlers and valid matchers
+func validateMethodRules(mtd string, rules []*MethodRule) error {
+	for idx, rule := range rules {
+		if rule.Handler == nil {
+			return fmt.Errorf("rule %d of method %s has no handler", idx, mtd)
+		}
+		if rule.Matcher != nil {
+			if err := rule.Matcher.compile(); err != nil {
+				return fmt.Errorf("rule %d of method %s: %v", idx, mtd, err)
+			}
+		}
 	}
 	return nil
 }
 
+func (gs *GrpcServer) RemoveMethodHandler(mtd string) error {
+	gs.handlerLock.Lock()
+	defer gs.handlerLock.Unlock()
+	delete(gs.handlerM, mtd)
+	return nil
+}
+
 func (gs *GrpcServer) ListMethods() ([]string, error) {
 	methods := []string{}
 	services, err := grpcurl.ListServices(gs.desc)
@@ -295,51 +577,145 @@ func (gs *GrpcServer) ListMethods() ([]string, error) {
 	return methods, nil
 }
 
-func (gs *GrpcServer) getMethodHandler(mtd string) (func(in *dynamic.Message, out *dynamic.Message, stream grpc.ServerStream) error, error) {
-	handler, ok := gs.handlerM[mtd]
+func (gs *GrpcServer) getMethodRules(mtd string) ([]*MethodRule, error) {
+	gs.handlerLock.RLock()
+	defer gs.handlerLock.RUnlock()
+	rules, ok := gs.handlerM[mtd]
 	if !ok {
 		return nil, fmt.Errorf("handler for method %s not found", mtd)
 	}
-	return handler, nil
+	return rules, nil
 }
 
-func (gs *GrpcServer) getUnaryHandler(mtd *desc.MethodDescriptor) func(interface{}, context.Context, func(interface{}) error, grpc.UnaryServerInterceptor) (interface{}, error) {
+// select handler of the first matched rule
+func (gs *GrpcServer) selectMethodHandler(ctx context.Context, mtd string, rules []*MethodRule, in *dynamic.Message, peerAddr string) (func(in *dynamic.Message, out *dynamic.Message, stream grpc.ServerStream) error, error) {
+	for _, rule := range rules {
+		if rule.Matcher == nil {
+			return rule.Handler, nil
+		}
+		matched, err := rule.Matcher.Match(ctx, in, peerAddr)
+		if err != nil {
+			return nil, err
+		}
+		if matched {
+			return rule.Handler, nil
+		}
+	}
+	return nil, gs.missingHandler(mtd, peerAddr, fmt.Sprintf("no rule of method %s matched", mtd))
+}
+
+// set status code of methods without handler, proxy and auto mock, or requests matching no rule,
+// codes.Unimplemented by default
+// NOTE: thread unsafe, should be set before server started
+func (gs *GrpcServer) SetMissingHandlerCode(code codes.Code) {
+	gs.missingCode = code
+}
+
+// status error of missing handler, it is recorded as message of error direction
+func (gs *GrpcServer) missingHandler(mtd, peerAddr, msg string) error {
+	logger.Errorf("protocols/grpc", "no handler for %s: %s", mtd, msg)
+	st := status.New(gs.missingCode, msg)
+	gs.notifyError(mtd, peerAddr, st)
+	return st.Err()
+}
+
+// call handler, panic of it is recovered as Internal status error with stack logged,
+// and recorded as message of error direction
+func (gs *GrpcServer) callHandler(mtd, peerAddr string, handler func(in *dynamic.Message, out *dynamic.Message, stream grpc.ServerStream) error, in, out *dynamic.Message, stream grpc.ServerStream) (err error) {
+	defer func() {
+		if caught := recover(); caught != nil {
+			logger.Errorf("protocols/grpc", "handler of %s panicked: %v\n%s", mtd, caught, debug.Stack())
+			st := status.Newf(codes.Internal, "handler of %s panicked: %v", mtd, caught)
+			gs.notifyError(mtd, peerAddr, st)
+			err = st.Err()
+		}
+	}()
+	return handler(in, out, stream)
+}
+
+// notify listeners of error status in json format, see ParseStatus
+func (gs *GrpcServer) notifyError(mtd, peerAddr string, st *status.Status) {
+	body, err := marshalStatus(st)
+	if err != nil {
+		logger.Errorf("protocols/grpc", "failed to marshal status of %s: %v", mtd, err)
+		return
+	}
+	gs.notifyListeners(mtd, "error", gs.addr, peerAddr, string(body), 1)
+}
+
+// rules of method and its injected fault, in the same order for unary and streaming calls:
+// rules of handlers, nil rules for proxy, rules of auto mock, or missing handler status,
+// then fault of method is injected only to calls not missing handler
+func (gs *GrpcServer) prepareCall(ctx context.Context, mtd *desc.MethodDescriptor, peerAddr string) ([]*MethodRule, *Fault, error) {
 	mtdFqn := mtd.GetFullyQualifiedName()
 
-	return func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
-		var peerAddr string
+	rules, err := gs.getMethodRules(mtdFqn)
+	if err != nil {
+		switch {
+		case gs.proxy != nil:
+			rules = nil
+		case gs.autoMock != nil:
+			rules = gs.autoMock.rules(mtd)
+		default:
+			return nil, nil, gs.missingHandler(mtdFqn, peerAddr, err.Error())
+		}
+	}
 
-		if len(gs.listeners) > 0 {
-			p, ok := peer.FromContext(ctx)
-			if ok {
-				peerAddr = p.Addr.String()
-			} else {
-				logger.Error("protocols/grpc", "failed to get peer address")
-			}
+	fault := gs.getFault(mtdFqn)
+	if fault != nil {
+		if err := gs.injectFault(ctx, mtdFqn, fault, peerAddr); err != nil {
+			return nil, nil, err
 		}
+	}
+	return rules, fault, nil
+}
 
-		handler, err := gs.getMethodHandler(mtdFqn)
-		if err != nil {
-			logger.Errorf("protocols/grpc", "no handler for %s", mtdFqn)
-			return nil, err
+// whether request fields are used to select rule
+func needRequestFields(rules []*MethodRule) bool {
+	for _, rule := range rules {
+		if rule.Matcher != nil && len(rule.Matcher.Fields) > 0 {
+			return true
 		}
+	}
+	return false
+}
+
+func (gs *GrpcServer) getUnaryHandler(mtd *desc.MethodDescriptor) func(interface{}, context.Context, func(interface{}) error, grpc.UnaryServerInterceptor) (interface{}, error) {
+	mtdFqn := mtd.GetFullyQualifiedName()
+
+	return func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+		peerAddr := getPeerAddr(ctx)
+
 		in := dynamic.NewMessage(mtd.GetInputType())
 		if err := dec(in); err != nil {
 			return nil, err
 		}
 		// handle in message in listener
-		for _, listener := range gs.listeners {
-			listener(mtdFqn, "in", peerAddr, gs.addr, in.String())
+		gs.notifyListeners(mtdFqn, "in", peerAddr, gs.addr, messageString(in), 1)
+		rules, _, err := gs.prepareCall(ctx, mtd, peerAddr)
+		if err != nil {
+			return nil, err
 		}
 
-		out := dynamic.NewMessage(mtd.GetOutputType())
-		if err := handler(in, out, nil); err != nil {
-			return nil, err
+		var out *dynamic.Message
+		if rules == nil {
+			if out, err = gs.proxyUnary(ctx, mtd, in); err != nil {
+				return nil, err
+			}
+		} else {
+			handler, err := gs.selectMethodHandler(ctx, mtdFqn, rules, in, peerAddr)
+			if err != nil {
+				logger.Errorf("protocols/grpc", "failed to select handler for %s: %v", mtdFqn, err)
+				return nil, err
+			}
+
+			out = dynamic.NewMessage(mtd.GetOutputType())
+			if err := gs.callHandler(mtdFqn, peerAddr, handler, in, out, &unaryStream{ctx: ctx, in: in, out: out}); err != nil {
+				return nil, err
+			}
 		}
 		// handle out message in listener
-		for _, listener := range gs.listeners {
-			listener(mtdFqn, "out", gs.addr, peerAddr, out.String())
-		}
+		gs.notifyListeners(mtdFqn, "out", gs.addr, peerAddr, messageString(out), 1)
 
 		if interceptor == nil {
 			return out, nil
@@ -360,19 +736,192 @@ func (gs *GrpcServer) getStreamHandler(mtd *desc.MethodDescriptor) func(interfac
 	mtdFqn := mtd.GetFullyQualifiedName()
 
 	return func(srv interface{}, stream grpc.ServerStream) error {
-		// TODO: listeners for stream messages
-		handler, err := gs.getMethodHandler(mtdFqn)
+		peerAddr := getPeerAddr(stream.Context())
+
+		// always listened, so that request messages are kept for verification
+		stream = &listenedStream{ServerStream: stream, gs: gs, mtd: mtdFqn, peer: peerAddr}
+		rules, fault, err := gs.prepareCall(stream.Context(), mtd, peerAddr)
+		if err != nil {
+			return err
+		}
+		var limited *limitedStream
+		if fault != nil && fault.StreamLimit > 0 {
+			limited = &limitedStream{ServerStream: stream, limit: fault.StreamLimit}
+			stream = limited
+		}
+		if rules == nil {
+			return limited.wrapErr(gs.proxyStream(mtd, stream))
+		}
+
+		// match request fields with the first message, it will be returned again by stream.RecvMsg
+		var first *dynamic.Message
+		if needRequestFields(rules) {
+			first = dynamic.NewMessage(mtd.GetInputType())
+			ps := &peekedStream{ServerStream: stream, first: first}
+			ps.firstErr = stream.RecvMsg(first)
+			if ps.firstErr != nil && ps.firstErr != io.EOF {
+				return ps.firstErr
+			}
+			stream = ps
+		}
+		handler, err := gs.selectMethodHandler(stream.Context(), mtdFqn, rules, first, peerAddr)
 		if err != nil {
+			logger.Errorf("protocols/grpc", "failed to select handler for %s: %v", mtdFqn, err)
 			return err
 		}
+
 		in := dynamic.NewMessage(mtd.GetInputType())
 		out := dynamic.NewMessage(mtd.GetOutputType())
-		if err := handler(in, out, stream); err != nil {
-			return err
+		return limited.wrapErr(gs.callHandler(mtdFqn, peerAddr, handler, in, out, stream))
+	}
+}
+
+func (gs *GrpcServer) notifyListeners(mtd, direction, from, to, body string, seq int) {
+	if journal, ok := gs.received.(*messageJournal); ok && direction == "in" {
+		journal.add(&ReceivedMessage{Method: mtd, Peer: from, Body: body, Seq: seq})
+	}
+	gs.listenerLock.RLock()
+	listeners := gs.listeners
+	gs.listenerLock.RUnlock()
+	for _, listener := range listeners {
+		if err := listener(mtd, direction, from, to, body, seq); err != nil {
+			logger.Errorf("protocols/grpc", "listener failed to handle message of %s: %v", mtd, err)
 		}
+	}
+}
+
+func getPeerAddr(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		logger.Error("protocols/grpc", "failed to get peer address")
+		return ""
+	}
+	return p.Addr.String()
+}
+
+// server stream which reports every received and sent message to listeners
+// NOTE: like grpc.ServerStream, RecvMsg and SendMsg should not be called concurrently by multiple goroutines
+type listenedStream struct {
+	grpc.ServerStream
+	gs      *GrpcServer
+	mtd     string
+	peer    string
+	recvSeq int
+	sendSeq int
+}
 
+func (ls *listenedStream) RecvMsg(m interface{}) error {
+	if err := ls.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+	ls.recvSeq++
+	ls.gs.notifyListeners(ls.mtd, "in", ls.peer, ls.gs.addr, messageString(m), ls.recvSeq)
+	return nil
+}
+
+func (ls *listenedStream) SendMsg(m interface{}) error {
+	if err := ls.ServerStream.SendMsg(m); err != nil {
+		return err
+	}
+	ls.sendSeq++
+	ls.gs.notifyListeners(ls.mtd, "out", ls.gs.addr, ls.peer, messageString(m), ls.sendSeq)
+	return nil
+}
+
+// server stream whose first message is already received
+type peekedStream struct {
+	grpc.ServerStream
+	first    *dynamic.Message
+	firstErr error
+	consumed bool
+}
+
+func (ps *peekedStream) RecvMsg(m interface{}) error {
+	if ps.consumed {
+		return ps.ServerStream.RecvMsg(m)
+	}
+	ps.consumed = true
+	if ps.firstErr != nil {
+		return ps.firstErr
+	}
+	target, ok := m.(proto.Message)
+	if !ok {
+		return fmt.Errorf("unsupported message type %T", m)
+	}
+	target.Reset()
+	return ps.first.MergeInto(target)
+}
+
+// server stream of unary method, RecvMsg returns the request once, and SendMsg sets the response
+type unaryStream struct {
+	ctx      context.Context
+	in       *dynamic.Message
+	out      *dynamic.Message
+	received bool
+}
+
+func (us *unaryStream) SetHeader(md metadata.MD) error {
+	return grpc.SetHeader(us.ctx, md)
+}
+
+func (us *unaryStream) SendHeader(md metadata.MD) error {
+	return grpc.SendHeader(us.ctx, md)
+}
+
+func (us *unaryStream) SetTrailer(md metadata.MD) {
+	grpc.SetTrailer(us.ctx, md)
+}
+
+func (us *unaryStream) Context() context.Context {
+	return us.ctx
+}
+
+func (us *unaryStream) SendMsg(m interface{}) error {
+	if m == us.out {
 		return nil
 	}
+	msg, ok := m.(proto.Message)
+	if !ok {
+		return fmt.Errorf("unsupported message type %T", m)
+	}
+	us.out.Reset()
+	return us.out.MergeFrom(msg)
+}
+
+func (us *unaryStream) RecvMsg(m interface{}) error {
+	if us.received {
+		return io.EOF
+	}
+	us.received = true
+	if m == us.in {
+		return nil
+	}
+	target, ok := m.(proto.Message)
+	if !ok {
+		return fmt.Errorf("unsupported message type %T", m)
+	}
+	target.Reset()
+	return us.in.MergeInto(target)
+}
+
+// json format of messages in listeners, verification, rule matching and records,
+// with proto field names, and fields of default values omitted
+var messageMarshaler = &jsonpb.Marshaler{OrigName: true}
+
+// json format of messages with fields of default values, absent fields are matched as them
+var defaultsMarshaler = &jsonpb.Marshaler{OrigName: true, EmitDefaults: true}
+
+// json of message with proto field names, the same as other protocols, text format is used if failed
+func messageString(m interface{}) string {
+	if msg, ok := m.(proto.Message); ok {
+		if s, err := messageMarshaler.MarshalToString(msg); err == nil {
+			return s
+		}
+	}
+	if s, ok := m.(fmt.Stringer); ok {
+		return s.String()
+	}
+	return fmt.Sprintf("%v", m)
 }
 
 // mock server struct for service descriptor
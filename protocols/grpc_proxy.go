@@ -0,0 +1,301 @@
+package protocols
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/feiyuw/simgo/logger"
+
+	"github.com/jhump/protoreflect/desc"
+	"github.com/jhump/protoreflect/dynamic"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// recorded call of a method forwarded to backend
+type GrpcRecord struct {
+	Method   string               `json:"method"`
+	Metadata metadata.MD          `json:"metadata,omitempty"` // incoming metadata of request
+	Messages []*GrpcRecordMessage `json:"messages"`           // messages in order of sent and received
+	Header   metadata.MD          `json:"header,omitempty"`
+	Trailer  metadata.MD          `json:"trailer,omitempty"`
+	Status   json.RawMessage      `json:"status,omitempty"` // error status in ParseStatus format, empty if OK
+
+	lock     sync.Mutex
+	finished bool // no more changes after finished, so it can be read without lock
+}
+
+type GrpcRecordMessage struct {
+	Direction string          `json:"direction"` // in or out
+	Body      json.RawMessage `json:"body"`      // message in json with proto field names
+}
+
+func newGrpcRecord(ctx context.Context, mtd string) *GrpcRecord {
+	md, _ := metadata.FromIncomingContext(ctx)
+	return &GrpcRecord{Method: mtd, Metadata: md.Copy(), Messages: []*GrpcRecordMessage{}}
+}
+
+func (gr *GrpcRecord) addMessage(direction string, msg *dynamic.Message) {
+	doc, err := messageToJSONValue(msg, messageMarshaler)
+	if err != nil {
+		logger.Errorf("protocols/grpc", "failed to record message of %s: %v", gr.Method, err)
+		return
+	}
+	body, _ := json.Marshal(doc)
+	gr.lock.Lock()
+	defer gr.lock.Unlock()
+	if gr.finished {
+		logger.Debugf("protocols/grpc", "message of %s is dropped, the call is finished", gr.Method)
+		return
+	}
+	gr.Messages = append(gr.Messages, &GrpcRecordMessage{Direction: direction, Body: body})
+}
+
+func (gr *GrpcRecord) finish(header, trailer metadata.MD, err error) {
+	gr.lock.Lock()
+	defer gr.lock.Unlock()
+	gr.finished = true
+	gr.Header = header
+	gr.Trailer = trailer
+	if err != nil {
+		st, _ := status.FromError(err)
+		if gr.Status, err = marshalStatus(st); err != nil {
+			logger.Errorf("protocols/grpc", "failed to record status of %s: %v", gr.Method, err)
+		}
+	}
+}
+
+// forward calls of methods without handler to backend through client, client is closed with server
+func (gs *GrpcServer) SetProxy(client *GrpcClient) {
+	gs.proxy = client
+}
+
+// add a recorder for all calls forwarded to backend
+func (gs *GrpcServer) AddRecorder(recorder func(record *GrpcRecord)) {
+	gs.recorders = append(gs.recorders, recorder)
+}
+
+func (gs *GrpcServer) notifyRecorders(record *GrpcRecord) {
+	for _, recorder := range gs.recorders {
+		recorder(record)
+	}
+}
+
+func (gs *GrpcServer) proxyUnary(ctx context.Context, mtd *desc.MethodDescriptor, in *dynamic.Message) (*dynamic.Message, error) {
+	var header, trailer metadata.MD
+
+	record := newGrpcRecord(ctx, mtd.GetFullyQualifiedName())
+	record.addMessage("in", in)
+	out := dynamic.NewMessage(mtd.GetOutputType())
+	err := gs.proxy.conn.Invoke(outgoingContext(ctx), grpcMethodPath(mtd), in, out, grpc.Header(&header), grpc.Trailer(&trailer))
+	if len(header) > 0 {
+		grpc.SetHeader(ctx, header)
+	}
+	if len(trailer) > 0 {
+		grpc.SetTrailer(ctx, trailer)
+	}
+	if err == nil {
+		record.addMessage("out", out)
+	}
+	record.finish(header, trailer, err)
+	gs.notifyRecorders(record)
+
+	return out, err
+}
+
+func (gs *GrpcServer) proxyStream(mtd *desc.MethodDescriptor, stream grpc.ServerStream) error {
+	ctx := stream.Context()
+	record := newGrpcRecord(ctx, mtd.GetFullyQualifiedName())
+	streamDesc := &grpc.StreamDesc{StreamName: mtd.GetName(), ServerStreams: mtd.IsServerStreaming(), ClientStreams: mtd.IsClientStreaming()}
+	ctx, cancel := context.WithCancel(outgoingContext(ctx))
+	defer cancel()
+	cs, err := gs.proxy.conn.NewStream(ctx, streamDesc, grpcMethodPath(mtd))
+	if err != nil {
+		record.finish(nil, nil, err)
+		gs.notifyRecorders(record)
+		return err
+	}
+
+	// client -> backend, backend reports the error by RecvMsg if SendMsg failed, messages are received by a reader,
+	// so that the forwarder stops once canceled, the reader starts no more receiving after canceled, and its pending
+	// receiving returns when the call ends
+	received := make(chan *dynamic.Message)
+	recvErr := make(chan error, 1)
+	go func() {
+		for ctx.Err() == nil {
+			in := dynamic.NewMessage(mtd.GetInputType())
+			if err := stream.RecvMsg(in); err != nil {
+				recvErr <- err
+				return
+			}
+			select {
+			case received <- in:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	forwarded := make(chan struct{})
+	go func() {
+		defer close(forwarded)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case err := <-recvErr:
+				if err == io.EOF {
+					cs.CloseSend()
+				} else {
+					cancel()
+				}
+				return
+			case in := <-received:
+				record.addMessage("in", in)
+				if err := cs.SendMsg(in); err != nil {
+					return
+				}
+			}
+		}
+	}()
+
+	// backend -> client
+	headerSent := false
+	for {
+		out := dynamic.NewMessage(mtd.GetOutputType())
+		if err = cs.RecvMsg(out); err != nil {
+			break
+		}
+		if !headerSent {
+			if header, _ := cs.Header(); len(header) > 0 {
+				stream.SendHeader(header)
+			}
+			headerSent = true
+		}
+		record.addMessage("out", out)
+		if err = stream.SendMsg(out); err != nil {
+			break
+		}
+	}
+	if err == io.EOF {
+		err = nil
+	}
+	// stop forwarding to backend and wait for the forwarder, so that the record is not changed after finished
+	cancel()
+	<-forwarded
+	header, _ := cs.Header()
+	if !headerSent && len(header) > 0 {
+		stream.SetHeader(header)
+	}
+	trailer := cs.Trailer()
+	if len(trailer) > 0 {
+		stream.SetTrailer(trailer)
+	}
+	record.finish(header, trailer, err)
+	gs.notifyRecorders(record)
+
+	return err
+}
+
+// outgoing context with incoming metadata, reserved headers are removed
+func outgoingContext(ctx context.Context) context.Context {
+	md, _ := metadata.FromIncomingContext(ctx)
+	out := metadata.MD{}
+	for k, v := range md {
+		if strings.HasPrefix(k, ":") || k == "content-type" || k == "user-agent" || k == "te" || strings.HasPrefix(k, "grpc-") {
+			continue
+		}
+		out[k] = v
+	}
+	return metadata.NewOutgoingContext(ctx, out)
+}
+
+// method path in grpc request, eg. /helloworld.Greeter/SayHello
+func grpcMethodPath(mtd *desc.MethodDescriptor) string {
+	return "/" + mtd.GetService().GetFullyQualifiedName() + "/" + mtd.GetName()
+}
+
+// create rules which reply recorded calls of a method, a call is selected if its first request
+// equals the request, and the last call is used if none of them matched
+func NewReplayRules(records []*GrpcRecord) ([]*MethodRule, error) {
+	rules := []*MethodRule{}
+
+	for idx, record := range records {
+		handler, err := newReplayHandler(record)
+		if err != nil {
+			return nil, fmt.Errorf("record %d: %v", idx, err)
+		}
+		for _, msg := range record.Messages {
+			if msg.Direction != "in" {
+				continue
+			}
+			// same format as matched value, see ValueMatcher.match
+			var doc interface{}
+			decoder := json.NewDecoder(bytes.NewReader(msg.Body))
+			decoder.UseNumber()
+			if err := decoder.Decode(&doc); err != nil {
+				return nil, fmt.Errorf("record %d: invalid message: %v", idx, err)
+			}
+			matcher := &RuleMatcher{Fields: []*ValueMatcher{{Path: "$", Value: valueString(doc)}}}
+			rules = append(rules, &MethodRule{Matcher: matcher, Handler: handler})
+			break
+		}
+		if idx == len(records)-1 {
+			rules = append(rules, &MethodRule{Handler: handler})
+		}
+	}
+	return rules, nil
+}
+
+// reply with recorded headers, messages and trailers, messages are received and sent in recorded order
+func newReplayHandler(record *GrpcRecord) (func(in *dynamic.Message, out *dynamic.Message, stream grpc.ServerStream) error, error) {
+	var st *status.Status
+
+	if len(record.Status) > 0 {
+		var err error
+		if st, err = ParseStatus(record.Status); err != nil {
+			return nil, err
+		}
+	}
+
+	return func(in *dynamic.Message, out *dynamic.Message, stream grpc.ServerStream) error {
+		if len(record.Header) > 0 {
+			stream.SetHeader(record.Header)
+		}
+		if len(record.Trailer) > 0 {
+			stream.SetTrailer(record.Trailer)
+		}
+		clientDone := false
+		for _, msg := range record.Messages {
+			switch msg.Direction {
+			case "in":
+				if clientDone {
+					continue
+				}
+				if err := stream.RecvMsg(in); err != nil {
+					if err != io.EOF {
+						return err
+					}
+					clientDone = true
+				}
+			case "out":
+				out.Reset()
+				if err := out.UnmarshalJSON(msg.Body); err != nil {
+					return err
+				}
+				if err := stream.SendMsg(out); err != nil {
+					return err
+				}
+			}
+		}
+		if st != nil {
+			return st.Err()
+		}
+		return nil
+	}, nil
+}
@@ -0,0 +1,172 @@
+package protocols
+
+import (
+	"context"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/jhump/protoreflect/desc"
+	"github.com/jhump/protoreflect/dynamic"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestGrpcProxy(t *testing.T) {
+	protos := []string{"echo.proto", "helloworld.proto"}
+	backend, _ := NewGrpcServer(":4993", protos)
+	backend.SetMethodHandler("helloworld.Greeter.SayHello", func(in *dynamic.Message, out *dynamic.Message, stream grpc.ServerStream) error {
+		out.SetFieldByName("message", "hello "+in.GetFieldByName("name").(string))
+		return nil
+	})
+	backend.SetMethodHandler("grpc.examples.echo.Echo.UnaryEcho", func(in *dynamic.Message, out *dynamic.Message, stream grpc.ServerStream) error {
+		return status.Error(codes.NotFound, "no echo")
+	})
+	backend.SetMethodHandler("grpc.examples.echo.Echo.BidirectionalStreamingEcho", func(in *dynamic.Message, out *dynamic.Message, stream grpc.ServerStream) error {
+		stream.SetTrailer(metadata.Pairs("x-count", "2"))
+		for {
+			if err := stream.RecvMsg(in); err == io.EOF {
+				break
+			}
+			if in.GetFieldByName("message").(string) == "stop" {
+				return status.Error(codes.Aborted, "stopped")
+			}
+			out.SetFieldByName("message", "echo "+in.GetFieldByName("message").(string))
+			stream.SendMsg(out)
+		}
+		return nil
+	})
+	backend.Start()
+
+	proxy, _ := NewGrpcServer(":4992", protos)
+	backendClient, _ := NewGrpcClient("127.0.0.1:4993", protos, grpc.WithInsecure())
+	proxy.SetProxy(backendClient)
+	var lock sync.Mutex
+	records := []*GrpcRecord{}
+	proxy.AddRecorder(func(record *GrpcRecord) {
+		lock.Lock()
+		defer lock.Unlock()
+		records = append(records, record)
+	})
+	proxy.Start()
+	defer proxy.Close()
+	time.Sleep(10 * time.Millisecond)
+	client, _ := NewGrpcClient("127.0.0.1:4992", protos, grpc.WithInsecure())
+	defer client.Close()
+
+	Convey("forward unary calls and record them", t, func() {
+		out, err := client.InvokeRPC("helloworld.Greeter.SayHello", map[string]interface{}{"name": "you"})
+		So(err, ShouldBeNil)
+		So(out.(*GrpcResult).Messages[0]["message"], ShouldEqual, "hello you")
+		out, err = client.InvokeRPC("helloworld.Greeter.SayHello", map[string]interface{}{"name": "me"})
+		So(err, ShouldBeNil)
+		So(out.(*GrpcResult).Messages[0]["message"], ShouldEqual, "hello me")
+		_, err = client.InvokeRPC("grpc.examples.echo.Echo.UnaryEcho", map[string]interface{}{"message": "x"})
+		So(status.Code(err), ShouldEqual, codes.NotFound)
+
+		So(len(records), ShouldEqual, 3)
+		So(records[0].Method, ShouldEqual, "helloworld.Greeter.SayHello")
+		So(len(records[0].Messages), ShouldEqual, 2)
+		So(records[0].Messages[0].Direction, ShouldEqual, "in")
+		So(string(records[0].Messages[0].Body), ShouldEqual, `{"name":"you"}`)
+		So(string(records[0].Messages[1].Body), ShouldEqual, `{"message":"hello you"}`)
+		So(records[0].Metadata.Get("user-agent"), ShouldNotBeEmpty)
+		So(records[0].Status, ShouldBeEmpty)
+		So(len(records[2].Messages), ShouldEqual, 1)
+		So(string(records[2].Status), ShouldEqual, `{"code":5,"message":"no echo","details":[]}`)
+	})
+
+	Convey("forward streaming calls and record them", t, func() {
+		out, err := client.InvokeRPC("grpc.examples.echo.Echo.BidirectionalStreamingEcho", []map[string]interface{}{
+			{"message": "a"},
+			{"message": "b"},
+		})
+		So(err, ShouldBeNil)
+		So(out.(*GrpcResult).Messages[1]["message"], ShouldEqual, "echo b")
+
+		lock.Lock()
+		record := records[len(records)-1]
+		lock.Unlock()
+		So(record.Method, ShouldEqual, "grpc.examples.echo.Echo.BidirectionalStreamingEcho")
+		So(len(record.Messages), ShouldEqual, 4)
+		So(record.Trailer.Get("x-count"), ShouldResemble, []string{"2"})
+	})
+
+	Convey("bidirectional streaming calls finish without waiting for client", t, func() {
+		session, err := client.OpenSession("grpc.examples.echo.Echo.BidirectionalStreamingEcho", nil)
+		So(err, ShouldBeNil)
+		defer session.Cancel()
+		So(session.Send(map[string]interface{}{"message": "stop"}), ShouldBeNil)
+		_, err = session.Recv()
+		So(status.Code(err), ShouldEqual, codes.Aborted)
+		lock.Lock()
+		record := records[len(records)-1]
+		lock.Unlock()
+		So(record.Method, ShouldEqual, "grpc.examples.echo.Echo.BidirectionalStreamingEcho")
+		So(len(record.Messages), ShouldEqual, 1)
+		So(string(record.Status), ShouldContainSubstring, `"code":10`)
+	})
+
+	Convey("client messages are recorded before the call finished", t, func() {
+		_, err := client.InvokeRPC("grpc.examples.echo.Echo.ClientStreamingEcho", []map[string]interface{}{{"message": "a"}, {"message": "b"}})
+		So(status.Code(err), ShouldEqual, codes.Unimplemented)
+		lock.Lock()
+		record := records[len(records)-1]
+		lock.Unlock()
+		So(record.Method, ShouldEqual, "grpc.examples.echo.Echo.ClientStreamingEcho")
+		So(len(record.Messages), ShouldEqual, 2)
+		So(string(record.Status), ShouldContainSubstring, `"code":12`)
+
+		// messages after finished are dropped
+		dsc, _ := backendClient.desc.FindSymbol("grpc.examples.echo.EchoRequest")
+		record.addMessage("in", dynamic.NewMessage(dsc.(*desc.MessageDescriptor)))
+		So(len(record.Messages), ShouldEqual, 2)
+	})
+
+	Convey("replay recorded calls without backend", t, func() {
+		backend.Close()
+		So(proxy.SetMethodRules("helloworld.Greeter.SayHello", mustReplayRules(records[0], records[1])), ShouldBeNil)
+		So(proxy.SetMethodRules("grpc.examples.echo.Echo.UnaryEcho", mustReplayRules(records[2])), ShouldBeNil)
+		So(proxy.SetMethodRules("grpc.examples.echo.Echo.BidirectionalStreamingEcho", mustReplayRules(records[3])), ShouldBeNil)
+
+		out, err := client.InvokeRPC("helloworld.Greeter.SayHello", map[string]interface{}{"name": "you"})
+		So(err, ShouldBeNil)
+		So(out.(*GrpcResult).Messages[0]["message"], ShouldEqual, "hello you")
+		out, err = client.InvokeRPC("helloworld.Greeter.SayHello", map[string]interface{}{"name": "other"})
+		So(err, ShouldBeNil)
+		So(out.(*GrpcResult).Messages[0]["message"], ShouldEqual, "hello me")
+		_, err = client.InvokeRPC("grpc.examples.echo.Echo.UnaryEcho", map[string]interface{}{"message": "x"})
+		So(status.Code(err), ShouldEqual, codes.NotFound)
+		So(status.Convert(err).Message(), ShouldEqual, "no echo")
+		out, err = client.InvokeRPC("grpc.examples.echo.Echo.BidirectionalStreamingEcho", []map[string]interface{}{
+			{"message": "a"},
+			{"message": "b"},
+		})
+		So(err, ShouldBeNil)
+		So(out.(*GrpcResult).Messages[0]["message"], ShouldEqual, "echo a")
+		So(out.(*GrpcResult).Messages[1]["message"], ShouldEqual, "echo b")
+
+		// not recorded methods are still forwarded
+		_, err = client.InvokeRPC("grpc.examples.echo.Echo.ServerStreamingEcho", map[string]interface{}{"message": "x"})
+		So(status.Code(err), ShouldEqual, codes.Unavailable)
+	})
+
+	Convey("reserved headers are not forwarded", t, func() {
+		ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(":authority", "localhost", "content-type", "application/grpc", "x-token", "abc"))
+		md, _ := metadata.FromOutgoingContext(outgoingContext(ctx))
+		So(md, ShouldResemble, metadata.Pairs("x-token", "abc"))
+	})
+}
+
+func mustReplayRules(records ...*GrpcRecord) []*MethodRule {
+	rules, err := NewReplayRules(records)
+	if err != nil {
+		panic(err)
+	}
+	return rules
+}
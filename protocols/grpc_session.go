@@ -0,0 +1,195 @@
+package protocols
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fullstorydev/grpcurl"
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/jhump/protoreflect/desc"
+	"github.com/jhump/protoreflect/dynamic"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// interactive call of a method, request messages are sent and response messages are received step by step,
+// Send and Recv can be called in different goroutines
+type GrpcSession struct {
+	Method string
+
+	mtd       *desc.MethodDescriptor
+	stream    grpc.ClientStream
+	cancel    context.CancelFunc
+	resolver  jsonpb.AnyResolver
+	formatter grpcurl.Formatter
+	start     time.Time
+
+	sendLock sync.Mutex
+	recvLock sync.Mutex
+	messages []map[string]interface{} // received messages
+	done     bool
+	err      error // final error of the stream, io.EOF if status is OK
+	latency  time.Duration
+}
+
+// open a session of method, opts.Timeout is the deadline of the whole session
+func (gc *GrpcClient) OpenSession(mtdName string, opts *InvokeOptions) (*GrpcSession, error) {
+	mtd, err := gc.findMethod(mtdName)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel, headers, callOpts, err := opts.prepare(clientCTX)
+	if err != nil {
+		return nil, err
+	}
+	ctx = metadata.NewOutgoingContext(ctx, grpcurl.MetadataFromHeaders(headers))
+
+	streamDesc := &grpc.StreamDesc{StreamName: mtd.GetName(), ServerStreams: mtd.IsServerStreaming(), ClientStreams: mtd.IsClientStreaming()}
+	start := time.Now()
+	stream, err := gc.conn.NewStream(ctx, streamDesc, grpcMethodPath(mtd), callOpts...)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	resolver := grpcurl.AnyResolverFromDescriptorSource(gc.desc)
+
+	return &GrpcSession{
+		Method:    mtd.GetFullyQualifiedName(),
+		mtd:       mtd,
+		stream:    stream,
+		cancel:    cancel,
+		resolver:  resolver,
+		formatter: grpcurl.NewJSONFormatter(true, resolver),
+		start:     start,
+		messages:  []map[string]interface{}{},
+	}, nil
+}
+
+// find method by name like helloworld.Greeter.SayHello or helloworld.Greeter/SayHello
+func (gc *GrpcClient) findMethod(mtdName string) (*desc.MethodDescriptor, error) {
+	pos := strings.LastIndex(mtdName, "/")
+	if pos < 0 {
+		pos = strings.LastIndex(mtdName, ".")
+	}
+	if pos < 0 {
+		return nil, fmt.Errorf("invalid method: %s", mtdName)
+	}
+	svcName, name := strings.TrimPrefix(mtdName[:pos], "/"), mtdName[pos+1:]
+
+	dsc, err := gc.desc.FindSymbol(svcName)
+	if err != nil {
+		return nil, fmt.Errorf("unable to find service: %s, error: %v", svcName, err)
+	}
+	sd, ok := dsc.(*desc.ServiceDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a service", svcName)
+	}
+	mtd := sd.FindMethodByName(name)
+	if mtd == nil {
+		return nil, fmt.Errorf("service %s does not include a method named %s", svcName, name)
+	}
+	return mtd, nil
+}
+
+// send one request message, reqData can be a map or a json string
+func (gs *GrpcSession) Send(reqData interface{}) error {
+	var data []byte
+
+	switch v := reqData.(type) {
+	case string:
+		data = []byte(v)
+	case []byte:
+		data = v
+	default:
+		b, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		data = b
+	}
+	in := dynamic.NewMessage(gs.mtd.GetInputType())
+	unmarshaler := &jsonpb.Unmarshaler{AnyResolver: gs.resolver}
+	if err := unmarshaler.Unmarshal(bytes.NewReader(data), in); err != nil {
+		return fmt.Errorf("invalid request: %v", err)
+	}
+
+	gs.sendLock.Lock()
+	defer gs.sendLock.Unlock()
+	return gs.stream.SendMsg(in)
+}
+
+// half-close the session, no more request message can be sent
+func (gs *GrpcSession) CloseSend() error {
+	gs.sendLock.Lock()
+	defer gs.sendLock.Unlock()
+	return gs.stream.CloseSend()
+}
+
+// receive the next response message, io.EOF returned if the session finished with status OK,
+// otherwise the error of status returned
+func (gs *GrpcSession) Recv() (map[string]interface{}, error) {
+	gs.recvLock.Lock()
+	defer gs.recvLock.Unlock()
+
+	if gs.done {
+		return nil, gs.err
+	}
+	out := dynamic.NewMessage(gs.mtd.GetOutputType())
+	if err := gs.stream.RecvMsg(out); err != nil {
+		gs.finish(err)
+		return nil, gs.err
+	}
+	s, err := gs.formatter(out)
+	if err != nil {
+		return nil, err
+	}
+	msg := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(s), &msg); err != nil {
+		return nil, err
+	}
+	gs.messages = append(gs.messages, msg)
+	if !gs.mtd.IsServerStreaming() { // stream is finished after the only response message received
+		gs.finish(io.EOF)
+	}
+	return msg, nil
+}
+
+func (gs *GrpcSession) finish(err error) {
+	gs.done = true
+	gs.err = err
+	gs.latency = time.Since(gs.start)
+	gs.cancel()
+}
+
+// cancel the session, the next Recv returns status Canceled
+func (gs *GrpcSession) Cancel() {
+	gs.cancel()
+}
+
+// result of the finished session with all received messages, nil returned if it is not finished
+func (gs *GrpcSession) Result() *GrpcResult {
+	gs.recvLock.Lock()
+	defer gs.recvLock.Unlock()
+
+	if !gs.done {
+		return nil
+	}
+	st := status.New(codes.OK, "")
+	if gs.err != io.EOF {
+		st = status.Convert(gs.err)
+	}
+	result := newGrpcResult(st)
+	result.Messages = gs.messages
+	result.Header, _ = gs.stream.Header()
+	result.Trailer = gs.stream.Trailer()
+	result.Latency = gs.latency
+	return result
+}
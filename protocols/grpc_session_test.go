@@ -0,0 +1,114 @@
+package protocols
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/jhump/protoreflect/dynamic"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestGrpcSession(t *testing.T) {
+	s, _ := NewGrpcServer(":4991", []string{"echo.proto", "helloworld.proto"})
+	s.SetMethodHandler("grpc.examples.echo.Echo.BidirectionalStreamingEcho", func(in *dynamic.Message, out *dynamic.Message, stream grpc.ServerStream) error {
+		md, _ := metadata.FromIncomingContext(stream.Context())
+		stream.SetHeader(metadata.Pairs("x-user", md.Get("x-user")[0]))
+		stream.SetTrailer(metadata.Pairs("x-count", "2"))
+		for {
+			if err := stream.RecvMsg(in); err == io.EOF {
+				return nil
+			} else if err != nil {
+				return err
+			}
+			if in.GetFieldByName("message") == "fail" {
+				return status.Error(codes.Aborted, "aborted")
+			}
+			out.SetFieldByName("message", "echo "+in.GetFieldByName("message").(string))
+			if err := stream.SendMsg(out); err != nil {
+				return err
+			}
+		}
+	})
+	s.SetMethodHandler("helloworld.Greeter.SayHello", func(in *dynamic.Message, out *dynamic.Message, stream grpc.ServerStream) error {
+		out.SetFieldByName("message", "hello "+in.GetFieldByName("name").(string))
+		return nil
+	})
+	s.Start()
+	defer s.Close()
+	time.Sleep(time.Millisecond) // make sure server started
+
+	client, _ := NewGrpcClient("127.0.0.1:4991", []string{"echo.proto", "helloworld.proto"}, grpc.WithInsecure())
+	defer client.Close()
+
+	Convey("drive bidi stream step by step", t, func() {
+		session, err := client.OpenSession("grpc.examples.echo.Echo.BidirectionalStreamingEcho", &InvokeOptions{Headers: map[string]string{"x-user": "you"}})
+		So(err, ShouldBeNil)
+		So(session.Result(), ShouldBeNil)
+
+		So(session.Send(map[string]interface{}{"message": "a"}), ShouldBeNil)
+		msg, err := session.Recv()
+		So(err, ShouldBeNil)
+		So(msg["message"], ShouldEqual, "echo a")
+
+		So(session.Send(`{"message": "b"}`), ShouldBeNil)
+		msg, err = session.Recv()
+		So(err, ShouldBeNil)
+		So(msg["message"], ShouldEqual, "echo b")
+
+		So(session.CloseSend(), ShouldBeNil)
+		_, err = session.Recv()
+		So(err, ShouldEqual, io.EOF)
+		_, err = session.Recv()
+		So(err, ShouldEqual, io.EOF)
+
+		result := session.Result()
+		So(result.Code, ShouldEqual, codes.OK)
+		So(len(result.Messages), ShouldEqual, 2)
+		So(result.Header.Get("x-user"), ShouldResemble, []string{"you"})
+		So(result.Trailer.Get("x-count"), ShouldResemble, []string{"2"})
+	})
+
+	Convey("session finished with error status", t, func() {
+		session, err := client.OpenSession("grpc.examples.echo.Echo/BidirectionalStreamingEcho", &InvokeOptions{Headers: map[string]string{"x-user": "you"}})
+		So(err, ShouldBeNil)
+		So(session.Send(map[string]interface{}{"message": "fail"}), ShouldBeNil)
+		_, err = session.Recv()
+		So(status.Code(err), ShouldEqual, codes.Aborted)
+		So(session.Result().Message, ShouldEqual, "aborted")
+
+		So(session.Send(map[string]interface{}{"unknown": "x"}), ShouldNotBeNil)
+	})
+
+	Convey("cancel session", t, func() {
+		session, err := client.OpenSession("grpc.examples.echo.Echo.BidirectionalStreamingEcho", &InvokeOptions{Headers: map[string]string{"x-user": "you"}})
+		So(err, ShouldBeNil)
+		session.Cancel()
+		_, err = session.Recv()
+		So(status.Code(err), ShouldEqual, codes.Canceled)
+	})
+
+	Convey("unary method in session", t, func() {
+		session, err := client.OpenSession("helloworld.Greeter.SayHello", nil)
+		So(err, ShouldBeNil)
+		So(session.Send(map[string]interface{}{"name": "you"}), ShouldBeNil)
+		msg, err := session.Recv()
+		So(err, ShouldBeNil)
+		So(msg["message"], ShouldEqual, "hello you")
+		_, err = session.Recv()
+		So(err, ShouldEqual, io.EOF)
+		So(session.Result().Code, ShouldEqual, codes.OK)
+	})
+
+	Convey("open session of unknown method", t, func() {
+		_, err := client.OpenSession("helloworld.Greeter.NotExist", nil)
+		So(err, ShouldNotBeNil)
+		_, err = client.OpenSession("helloworld", nil)
+		So(err, ShouldNotBeNil)
+	})
+}
@@ -2,9 +2,12 @@ package protocols
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"io"
 	"net"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -15,7 +18,11 @@ import (
 	"github.com/jhump/protoreflect/dynamic"
 	"github.com/robertkrimen/otto"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
 	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
 
 	ecpb "google.golang.org/grpc/examples/features/proto/echo"
 	hwpb "google.golang.org/grpc/examples/helloworld/helloworld"
@@ -76,25 +83,25 @@ func TestGrpcClient(t *testing.T) {
 		client, _ := NewGrpcClient("127.0.0.1:3999", []string{"helloworld.proto"}, grpc.WithInsecure())
 		out, err := client.InvokeRPC("helloworld.Greeter.SayHello", map[string]interface{}{"name": "you"})
 		So(err, ShouldBeNil)
-		So(out.(map[string]interface{})["message"], ShouldEqual, "Hello you")
+		So(out.(*GrpcResult).Messages[0]["message"], ShouldEqual, "Hello you")
 	})
 
 	Convey("invoke rpc of sync method without proto files", t, func() {
 		client, _ := NewGrpcClient("127.0.0.1:3999", []string{}, grpc.WithInsecure())
 		out, err := client.InvokeRPC("grpc.examples.echo.Echo.UnaryEcho", map[string]interface{}{"message": "hello"})
 		So(err, ShouldBeNil)
-		So(out.(map[string]interface{})["message"], ShouldEqual, "hello")
+		So(out.(*GrpcResult).Messages[0]["message"], ShouldEqual, "hello")
 	})
 
 	Convey("invoke rpc of streaming method", t, func() {
 		client, _ := NewGrpcClient("127.0.0.1:3999", []string{}, grpc.WithInsecure())
 		out, err := client.InvokeRPC("grpc.examples.echo.Echo.ClientStreamingEcho", map[string]interface{}{"message": "hello"})
 		So(err, ShouldBeNil)
-		So(out.(map[string]interface{})["message"], ShouldEqual, "hello")
+		So(out.(*GrpcResult).Messages[0]["message"], ShouldEqual, "hello")
 
 		out, err = client.InvokeRPC("grpc.examples.echo.Echo.BidirectionalStreamingEcho", map[string]interface{}{"message": "hello"})
 		So(err, ShouldBeNil)
-		So(out.(map[string]interface{})["message"], ShouldEqual, "hello")
+		So(out.(*GrpcResult).Messages[0]["message"], ShouldEqual, "hello")
 	})
 }
 
@@ -117,17 +124,17 @@ func TestGrpcServer(t *testing.T) {
 	Convey("simulated server always return the same data", t, func() {
 		out, err := client.InvokeRPC("grpc.examples.echo.Echo.UnaryEcho", map[string]interface{}{"message": "xxxx"})
 		So(err, ShouldBeNil)
-		So(out.(map[string]interface{})["message"], ShouldEqual, "hello")
+		So(out.(*GrpcResult).Messages[0]["message"], ShouldEqual, "hello")
 	})
 
 	Convey("echo server always return the same data", t, func() {
 		out, err := client.InvokeRPC("helloworld.Greeter.SayHello", map[string]interface{}{"name": "this is a sentence"})
 		So(err, ShouldBeNil)
-		So(out.(map[string]interface{})["message"], ShouldEqual, "this is a sentence")
+		So(out.(*GrpcResult).Messages[0]["message"], ShouldEqual, "this is a sentence")
 
 		out, err = client.InvokeRPC("helloworld.Greeter.SayHello", map[string]interface{}{"name": "中文：你好世界！hello world"})
 		So(err, ShouldBeNil)
-		So(out.(map[string]interface{})["message"], ShouldEqual, "中文：你好世界！hello world")
+		So(out.(*GrpcResult).Messages[0]["message"], ShouldEqual, "中文：你好世界！hello world")
 	})
 
 	Convey("change method handler", t, func() {
@@ -141,7 +148,7 @@ func TestGrpcServer(t *testing.T) {
 		})
 		out, err := client.InvokeRPC("grpc.examples.echo.Echo.UnaryEcho", map[string]interface{}{"message": "xxxx"})
 		So(err, ShouldBeNil)
-		So(out.(map[string]interface{})["message"], ShouldEqual, "world")
+		So(out.(*GrpcResult).Messages[0]["message"], ShouldEqual, "world")
 	})
 
 	Convey("use javascript handler", t, func() {
@@ -161,7 +168,127 @@ func TestGrpcServer(t *testing.T) {
 		})
 		out, err := client.InvokeRPC("grpc.examples.echo.Echo.UnaryEcho", map[string]interface{}{"message": "zxyabc"})
 		So(err, ShouldBeNil)
-		So(out.(map[string]interface{})["message"], ShouldEqual, "javascript")
+		So(out.(*GrpcResult).Messages[0]["message"], ShouldEqual, "javascript")
+	})
+
+	Convey("return status error", t, func() {
+		s.SetMethodHandler("grpc.examples.echo.Echo.UnaryEcho", func(in *dynamic.Message, out *dynamic.Message, stream grpc.ServerStream) error {
+			return status.Error(codes.ResourceExhausted, "too many requests")
+		})
+		defer s.SetMethodHandler("grpc.examples.echo.Echo.UnaryEcho", func(in *dynamic.Message, out *dynamic.Message, stream grpc.ServerStream) error {
+			out.SetFieldByName("message", "hello")
+			return nil
+		})
+		out, err := client.InvokeRPC("grpc.examples.echo.Echo.UnaryEcho", map[string]interface{}{"message": "xxxx"})
+		So(err, ShouldNotBeNil)
+		So(status.Code(err), ShouldEqual, codes.ResourceExhausted)
+		So(status.Convert(err).Message(), ShouldEqual, "too many requests")
+		So(out.(*GrpcResult).Code, ShouldEqual, codes.ResourceExhausted)
+		So(out.(*GrpcResult).CodeName, ShouldEqual, "ResourceExhausted")
+		So(out.(*GrpcResult).Messages, ShouldBeEmpty)
+	})
+
+	Convey("invoke returns structured result", t, func() {
+		s.SetMethodHandler("grpc.examples.echo.Echo.UnaryEcho", func(in *dynamic.Message, out *dynamic.Message, stream grpc.ServerStream) error {
+			st, _ := NewStatus(codes.InvalidArgument, "invalid message", json.RawMessage(`{"@type": "type.googleapis.com/google.rpc.BadRequest", "fieldViolations": [{"field": "message"}]}`))
+			return st.Err()
+		})
+		defer s.SetMethodHandler("grpc.examples.echo.Echo.UnaryEcho", func(in *dynamic.Message, out *dynamic.Message, stream grpc.ServerStream) error {
+			out.SetFieldByName("message", "hello")
+			return nil
+		})
+		result, err := client.Invoke("grpc.examples.echo.Echo.UnaryEcho", map[string]interface{}{"message": "xxxx"}, nil)
+		So(err, ShouldBeNil)
+		So(result.Err(), ShouldNotBeNil)
+		So(result.Code, ShouldEqual, codes.InvalidArgument)
+		So(result.Message, ShouldEqual, "invalid message")
+		So(len(result.Details), ShouldEqual, 1)
+		So(string(result.Details[0]), ShouldContainSubstring, "google.rpc.BadRequest")
+		So(result.Latency, ShouldBeGreaterThan, 0)
+
+		result, err = client.Invoke("helloworld.Greeter.SayHello", map[string]interface{}{"name": "you"}, nil)
+		So(err, ShouldBeNil)
+		So(result.Err(), ShouldBeNil)
+		So(result.CodeName, ShouldEqual, "OK")
+		So(len(result.Messages), ShouldEqual, 1)
+		So(result.Details, ShouldBeEmpty)
+
+		_, err = client.Invoke("helloworld.Greeter.NotExist", map[string]interface{}{"name": "you"}, nil)
+		So(err, ShouldNotBeNil)
+		So(NewGrpcErrorResult(err).Code, ShouldEqual, codes.Unknown)
+	})
+
+	Convey("select handler with method rules", t, func() {
+		err := s.SetMethodRules("helloworld.Greeter.SayHello", []*MethodRule{
+			{
+				Matcher: &RuleMatcher{Fields: []*ValueMatcher{{Path: "name", Value: "alice"}}},
+				Handler: func(in *dynamic.Message, out *dynamic.Message, stream grpc.ServerStream) error {
+					out.SetFieldByName("message", "hi alice")
+					return nil
+				},
+			},
+			{
+				Matcher: &RuleMatcher{Fields: []*ValueMatcher{{Path: "name", Op: "regex", Value: "^b"}}},
+				Handler: func(in *dynamic.Message, out *dynamic.Message, stream grpc.ServerStream) error {
+					out.SetFieldByName("message", "hi b*")
+					return nil
+				},
+			},
+		})
+		So(err, ShouldBeNil)
+		defer s.SetMethodHandler("helloworld.Greeter.SayHello", func(in *dynamic.Message, out *dynamic.Message, stream grpc.ServerStream) error {
+			out.SetFieldByName("message", in.GetFieldByName("name"))
+			return nil
+		})
+
+		out, err := client.InvokeRPC("helloworld.Greeter.SayHello", map[string]interface{}{"name": "alice"})
+		So(err, ShouldBeNil)
+		So(out.(*GrpcResult).Messages[0]["message"], ShouldEqual, "hi alice")
+		out, err = client.InvokeRPC("helloworld.Greeter.SayHello", map[string]interface{}{"name": "bob"})
+		So(err, ShouldBeNil)
+		So(out.(*GrpcResult).Messages[0]["message"], ShouldEqual, "hi b*")
+		_, err = client.InvokeRPC("helloworld.Greeter.SayHello", map[string]interface{}{"name": "carol"})
+		So(err, ShouldNotBeNil)
+
+		err = s.SetMethodRules("helloworld.Greeter.SayHello", []*MethodRule{{Matcher: &RuleMatcher{Peer: "["}, Handler: func(in *dynamic.Message, out *dynamic.Message, stream grpc.ServerStream) error {
+			return nil
+		}}})
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("select streaming handler with the first message", t, func() {
+		s.SetMethodRules("grpc.examples.echo.Echo.ClientStreamingEcho", []*MethodRule{
+			{
+				Matcher: &RuleMatcher{Fields: []*ValueMatcher{{Path: "message", Value: "first"}}},
+				Handler: func(in *dynamic.Message, out *dynamic.Message, stream grpc.ServerStream) error {
+					cnt := 0
+					for {
+						if err := stream.RecvMsg(in); err == io.EOF {
+							break
+						}
+						cnt++
+					}
+					out.SetFieldByName("message", fmt.Sprintf("first rule with %d messages", cnt))
+					return stream.SendMsg(out)
+				},
+			},
+			{
+				Handler: func(in *dynamic.Message, out *dynamic.Message, stream grpc.ServerStream) error {
+					out.SetFieldByName("message", "fallback")
+					return stream.SendMsg(out)
+				},
+			},
+		})
+
+		out, err := client.InvokeRPC("grpc.examples.echo.Echo.ClientStreamingEcho", []map[string]interface{}{
+			map[string]interface{}{"message": "first"},
+			map[string]interface{}{"message": "second"},
+		})
+		So(err, ShouldBeNil)
+		So(out.(*GrpcResult).Messages[0]["message"], ShouldEqual, "first rule with 2 messages")
+		out, err = client.InvokeRPC("grpc.examples.echo.Echo.ClientStreamingEcho", map[string]interface{}{"message": "other"})
+		So(err, ShouldBeNil)
+		So(out.(*GrpcResult).Messages[0]["message"], ShouldEqual, "fallback")
 	})
 
 	Convey("reply after 1 millisecond delay", t, func() {
@@ -174,7 +301,7 @@ func TestGrpcServer(t *testing.T) {
 		out, err := client.InvokeRPC("helloworld.Greeter.SayHello", map[string]interface{}{"name": "what to do"})
 		duration := time.Now().UnixNano() - start
 		So(err, ShouldBeNil)
-		So(out.(map[string]interface{})["message"], ShouldEqual, "after sleep")
+		So(out.(*GrpcResult).Messages[0]["message"], ShouldEqual, "after sleep")
 		So(duration, ShouldBeGreaterThanOrEqualTo, 1000000)
 	})
 
@@ -201,7 +328,7 @@ func TestGrpcServer(t *testing.T) {
 		So(len(recvedMsgs), ShouldEqual, 2)
 		So(recvedMsgs[0].GetFieldByName("message"), ShouldEqual, "xxxx")
 		So(recvedMsgs[1].GetFieldByName("message"), ShouldEqual, "yyyy")
-		So(out.(map[string]interface{})["message"], ShouldEqual, "xxxyyy")
+		So(out.(*GrpcResult).Messages[0]["message"], ShouldEqual, "xxxyyy")
 	})
 
 	Convey("server streaming API", t, func() {
@@ -215,8 +342,8 @@ func TestGrpcServer(t *testing.T) {
 		})
 		out, err := client.InvokeRPC("grpc.examples.echo.Echo.ServerStreamingEcho", map[string]interface{}{"message": "xxxx"})
 		So(err, ShouldBeNil)
-		So(out.([]map[string]interface{})[0]["message"], ShouldEqual, "xxxx")
-		So(out.([]map[string]interface{})[1]["message"], ShouldEqual, "end")
+		So(out.(*GrpcResult).Messages[0]["message"], ShouldEqual, "xxxx")
+		So(out.(*GrpcResult).Messages[1]["message"], ShouldEqual, "end")
 	})
 
 	Convey("bidi streaming API", t, func() {
@@ -232,7 +359,7 @@ func TestGrpcServer(t *testing.T) {
 		})
 		out, err := client.InvokeRPC("grpc.examples.echo.Echo.BidirectionalStreamingEcho", map[string]interface{}{"message": "xxxx"})
 		So(err, ShouldBeNil)
-		So(out.(map[string]interface{})["message"], ShouldEqual, "xxxx")
+		So(out.(*GrpcResult).Messages[0]["message"], ShouldEqual, "xxxx")
 
 		out, err = client.InvokeRPC("grpc.examples.echo.Echo.BidirectionalStreamingEcho", []map[string]interface{}{
 			map[string]interface{}{"message": "a"},
@@ -240,7 +367,7 @@ func TestGrpcServer(t *testing.T) {
 			map[string]interface{}{"message": "c"},
 		})
 		So(err, ShouldBeNil)
-		outSlice := out.([]map[string]interface{})
+		outSlice := out.(*GrpcResult).Messages
 		So(len(outSlice), ShouldEqual, 3)
 		So(outSlice[0]["message"], ShouldEqual, "a")
 		So(outSlice[1]["message"], ShouldEqual, "b")
@@ -248,14 +375,19 @@ func TestGrpcServer(t *testing.T) {
 	})
 
 	Convey("handle listeners", t, func() {
+		var lock sync.Mutex // listeners are called in server goroutines
 		msgCnt := 0
 		inMsgs := [][]string{}
 		outMsgs := [][]string{}
-		s.AddListener(func(mtd, direction, from, to, body string) error {
+		s.AddListener(func(mtd, direction, from, to, body string, seq int) error {
+			lock.Lock()
+			defer lock.Unlock()
 			msgCnt++
 			return nil
 		})
-		s.AddListener(func(mtd, direction, from, to, body string) error {
+		s.AddListener(func(mtd, direction, from, to, body string, seq int) error {
+			lock.Lock()
+			defer lock.Unlock()
 			switch direction {
 			case "in":
 				inMsgs = append(inMsgs, []string{mtd, from, to, body})
@@ -267,6 +399,8 @@ func TestGrpcServer(t *testing.T) {
 		})
 
 		client.InvokeRPC("helloworld.Greeter.SayHello", map[string]interface{}{"name": "what to do"})
+		lock.Lock()
+		defer lock.Unlock()
 		So(msgCnt, ShouldEqual, 2)
 		So(len(inMsgs), ShouldEqual, 1)
 		So(inMsgs[0][0], ShouldEqual, "helloworld.Greeter.SayHello")
@@ -275,6 +409,117 @@ func TestGrpcServer(t *testing.T) {
 		So(outMsgs[0][0], ShouldEqual, "helloworld.Greeter.SayHello")
 		So(outMsgs[0][1], ShouldEqual, ":4999")
 	})
+
+	Convey("unary handler reads metadata and sets header and trailer", t, func() {
+		s.SetMethodHandler("helloworld.Greeter.SayHello", func(in *dynamic.Message, out *dynamic.Message, stream grpc.ServerStream) error {
+			md, _ := metadata.FromIncomingContext(stream.Context())
+			if len(md.Get("authorization")) == 0 {
+				return status.Error(codes.Unauthenticated, "no token")
+			}
+			_, hasDeadline := stream.Context().Deadline()
+			p, _ := peer.FromContext(stream.Context())
+			stream.SetHeader(metadata.Pairs("x-token", md.Get("authorization")[0]))
+			stream.SetTrailer(metadata.Pairs("x-deadline", fmt.Sprintf("%v", hasDeadline)))
+			stream.RecvMsg(in)
+			if err := stream.RecvMsg(in); err != io.EOF {
+				return fmt.Errorf("request received twice: %v", err)
+			}
+			return stream.SendMsg(&hwpb.HelloReply{Message: in.GetFieldByName("name").(string) + " from " + p.Addr.String()})
+		})
+		conn, _ := grpc.Dial("127.0.0.1:4999", grpc.WithInsecure())
+		defer conn.Close()
+		hwClient := hwpb.NewGreeterClient(conn)
+
+		_, err := hwClient.SayHello(context.Background(), &hwpb.HelloRequest{Name: "you"})
+		So(status.Code(err), ShouldEqual, codes.Unauthenticated)
+
+		var header, trailer metadata.MD
+		ctx, cancel := context.WithTimeout(metadata.AppendToOutgoingContext(context.Background(), "authorization", "abc"), time.Second)
+		defer cancel()
+		out, err := hwClient.SayHello(ctx, &hwpb.HelloRequest{Name: "you"}, grpc.Header(&header), grpc.Trailer(&trailer))
+		So(err, ShouldBeNil)
+		So(out.Message, ShouldStartWith, "you from 127.0.0.1:")
+		So(header.Get("x-token"), ShouldResemble, []string{"abc"})
+		So(trailer.Get("x-deadline"), ShouldResemble, []string{"true"})
+	})
+
+	Convey("invoke with metadata, timeout and call options", t, func() {
+		s.SetMethodHandler("helloworld.Greeter.SayHello", func(in *dynamic.Message, out *dynamic.Message, stream grpc.ServerStream) error {
+			md, _ := metadata.FromIncomingContext(stream.Context())
+			if len(md.Get("authorization")) == 0 {
+				stream.SetTrailer(metadata.Pairs("x-reason", "no token"))
+				return status.Error(codes.Unauthenticated, "no token")
+			}
+			if in.GetFieldByName("name") == "slow" {
+				time.Sleep(100 * time.Millisecond)
+			}
+			_, hasDeadline := stream.Context().Deadline()
+			stream.SetHeader(metadata.Pairs("x-tags", strings.Join(md.Get("x-tags"), ",")))
+			stream.SetTrailer(metadata.Pairs("x-deadline", fmt.Sprintf("%v", hasDeadline)))
+			out.SetFieldByName("message", md.Get("authorization")[0])
+			return nil
+		})
+		defer s.SetMethodHandler("helloworld.Greeter.SayHello", func(in *dynamic.Message, out *dynamic.Message, stream grpc.ServerStream) error {
+			out.SetFieldByName("message", in.GetFieldByName("name"))
+			return nil
+		})
+
+		resp, err := client.Invoke("helloworld.Greeter.SayHello", map[string]interface{}{"name": "you"}, nil)
+		So(err, ShouldBeNil)
+		So(resp.Code, ShouldEqual, codes.Unauthenticated)
+		So(resp.Message, ShouldEqual, "no token")
+		So(resp.Trailer.Get("x-reason"), ShouldResemble, []string{"no token"})
+
+		resp, err = client.Invoke("helloworld.Greeter.SayHello", map[string]interface{}{"name": "you"}, &InvokeOptions{
+			Headers:      map[string]string{"authorization": "abc", "x-tags": "a"},
+			Timeout:      "1s",
+			Compression:  "gzip",
+			WaitForReady: true,
+		})
+		So(err, ShouldBeNil)
+		So(resp.Code, ShouldEqual, codes.OK)
+		So(resp.Messages[0]["message"], ShouldEqual, "abc")
+		So(resp.Header.Get("x-tags"), ShouldResemble, []string{"a"})
+		So(resp.Trailer.Get("x-deadline"), ShouldResemble, []string{"true"})
+
+		resp, err = client.Invoke("helloworld.Greeter.SayHello", map[string]interface{}{"name": "slow"}, &InvokeOptions{
+			Headers: map[string]string{"authorization": "abc"},
+			Timeout: "10ms",
+		})
+		So(err, ShouldBeNil)
+		So(resp.Code, ShouldEqual, codes.DeadlineExceeded)
+
+		_, err = client.Invoke("helloworld.Greeter.SayHello", map[string]interface{}{"name": "you"}, &InvokeOptions{Compression: "unknown"})
+		So(err, ShouldNotBeNil)
+		_, err = client.Invoke("helloworld.Greeter.SayHello", map[string]interface{}{"name": "you"}, &InvokeOptions{Timeout: "1x"})
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("handle listeners of streaming messages", t, func() {
+		var lock sync.Mutex
+		msgs := [][]interface{}{}
+		s.AddListener(func(mtd, direction, from, to, body string, seq int) error {
+			lock.Lock()
+			defer lock.Unlock()
+			if mtd == "grpc.examples.echo.Echo.BidirectionalStreamingEcho" {
+				msgs = append(msgs, []interface{}{direction, seq})
+			}
+			return nil
+		})
+
+		_, err := client.InvokeRPC("grpc.examples.echo.Echo.BidirectionalStreamingEcho", []map[string]interface{}{
+			map[string]interface{}{"message": "a"},
+			map[string]interface{}{"message": "b"},
+		})
+		So(err, ShouldBeNil)
+		lock.Lock()
+		defer lock.Unlock()
+		So(len(msgs), ShouldEqual, 4)
+		So(msgs[0], ShouldResemble, []interface{}{"in", 1})
+		So(msgs[1], ShouldResemble, []interface{}{"out", 1})
+		So(msgs[2], ShouldResemble, []interface{}{"in", 2})
+		So(msgs[3], ShouldResemble, []interface{}{"out", 2})
+	})
 }
 
 // hwServer is used to implement helloworld.GreeterServer.
@@ -353,3 +598,91 @@ func startDemoServer(port int) *grpc.Server {
 
 	return s
 }
+
+func TestGrpcServerErrors(t *testing.T) {
+	s, _ := NewGrpcServer(":4983", []string{"echo.proto"})
+	s.Start()
+	defer s.Close()
+	client, _ := NewGrpcClient("127.0.0.1:4983", []string{"echo.proto"}, grpc.WithInsecure())
+	defer client.Close()
+	var lock sync.Mutex // listeners are called in server goroutines
+	errMsgs := []string{}
+	s.AddListener(func(mtd, direction, from, to, body string, seq int) error {
+		lock.Lock()
+		defer lock.Unlock()
+		if direction == "error" {
+			errMsgs = append(errMsgs, body)
+		}
+		return nil
+	})
+	received := func() []string {
+		lock.Lock()
+		defer lock.Unlock()
+		msgs := errMsgs
+		errMsgs = []string{}
+		return msgs
+	}
+
+	Convey("missing handlers return Unimplemented by default", t, func() {
+		received()
+		_, err := client.InvokeRPC("grpc.examples.echo.Echo.UnaryEcho", map[string]interface{}{"message": "hello"})
+		So(status.Code(err), ShouldEqual, codes.Unimplemented)
+		_, err = client.InvokeRPC("grpc.examples.echo.Echo.BidirectionalStreamingEcho", map[string]interface{}{"message": "hello"})
+		So(status.Code(err), ShouldEqual, codes.Unimplemented)
+		msgs := received()
+		So(len(msgs), ShouldEqual, 2)
+		st, err := ParseStatus([]byte(msgs[0]))
+		So(err, ShouldBeNil)
+		So(st.Code(), ShouldEqual, codes.Unimplemented)
+	})
+
+	Convey("status code of missing handlers is configurable", t, func() {
+		s.SetMissingHandlerCode(codes.NotFound)
+		defer s.SetMissingHandlerCode(codes.Unimplemented)
+		s.SetMethodRules("grpc.examples.echo.Echo.UnaryEcho", []*MethodRule{{
+			Matcher: &RuleMatcher{Fields: []*ValueMatcher{{Path: "message", Value: "hi"}}},
+			Handler: func(in, out *dynamic.Message, stream grpc.ServerStream) error { return nil },
+		}})
+		defer s.RemoveMethodHandler("grpc.examples.echo.Echo.UnaryEcho")
+
+		_, err := client.InvokeRPC("grpc.examples.echo.Echo.UnaryEcho", map[string]interface{}{"message": "hello"})
+		So(status.Code(err), ShouldEqual, codes.NotFound)
+		_, err = client.InvokeRPC("grpc.examples.echo.Echo.ServerStreamingEcho", map[string]interface{}{"message": "hello"})
+		So(status.Code(err), ShouldEqual, codes.NotFound)
+
+		_, err = NewRpcServer("grpc", "echo", 4983, map[string]interface{}{"protos": []interface{}{"echo.proto"}, "missingHandlerCode": "OK"})
+		So(err, ShouldNotBeNil)
+		rs, err := NewRpcServer("grpc", "echo", 4983, map[string]interface{}{"protos": []interface{}{"echo.proto"}, "missingHandlerCode": "NOT_FOUND"})
+		So(err, ShouldBeNil)
+		So(rs.(*GrpcServer).missingCode, ShouldEqual, codes.NotFound)
+	})
+
+	Convey("panics of handlers are recovered as Internal", t, func() {
+		received()
+		panicHandler := func(in, out *dynamic.Message, stream grpc.ServerStream) error {
+			panic("boom")
+		}
+		s.SetMethodHandler("grpc.examples.echo.Echo.UnaryEcho", panicHandler)
+		s.SetMethodHandler("grpc.examples.echo.Echo.ClientStreamingEcho", panicHandler)
+		defer s.RemoveMethodHandler("grpc.examples.echo.Echo.UnaryEcho")
+		defer s.RemoveMethodHandler("grpc.examples.echo.Echo.ClientStreamingEcho")
+
+		_, err := client.InvokeRPC("grpc.examples.echo.Echo.UnaryEcho", map[string]interface{}{"message": "hello"})
+		So(status.Code(err), ShouldEqual, codes.Internal)
+		So(status.Convert(err).Message(), ShouldContainSubstring, "boom")
+		_, err = client.InvokeRPC("grpc.examples.echo.Echo.ClientStreamingEcho", map[string]interface{}{"message": "hello"})
+		So(status.Code(err), ShouldEqual, codes.Internal)
+		msgs := received()
+		So(len(msgs), ShouldEqual, 2)
+		So(msgs[1], ShouldContainSubstring, "boom")
+
+		// server still works
+		s.SetMethodHandler("grpc.examples.echo.Echo.UnaryEcho", func(in, out *dynamic.Message, stream grpc.ServerStream) error {
+			out.SetFieldByName("message", "ok")
+			return nil
+		})
+		out, err := client.InvokeRPC("grpc.examples.echo.Echo.UnaryEcho", map[string]interface{}{"message": "hello"})
+		So(err, ShouldBeNil)
+		So(out.(*GrpcResult).Messages[0]["message"], ShouldEqual, "ok")
+	})
+}
@@ -0,0 +1,732 @@
+package protocols
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"sort"
+	"time"
+	"unicode/utf16"
+)
+
+// hessian2 serialization, see http://hessian.caucho.com/doc/hessian-serialization.html
+// java objects are represented as map with class name in "@class" key, eg. {"@class": "com.foo.User", "name": "you"}
+
+const (
+	hessianClassKey = "@class"
+
+	hessianChunkSize = 0x8000
+)
+
+// ---------------------------------- encoder ----------------------------------
+
+type hessianEncoder struct {
+	buf       *bytes.Buffer
+	classDefs map[string]int // class name and fields -> class definition index
+}
+
+func newHessianEncoder() *hessianEncoder {
+	return &hessianEncoder{buf: new(bytes.Buffer), classDefs: map[string]int{}}
+}
+
+func (e *hessianEncoder) Bytes() []byte {
+	return e.buf.Bytes()
+}
+
+func (e *hessianEncoder) Encode(v interface{}) error {
+	switch v := v.(type) {
+	case nil:
+		e.buf.WriteByte('N')
+	case bool:
+		if v {
+			e.buf.WriteByte('T')
+		} else {
+			e.buf.WriteByte('F')
+		}
+	case int8:
+		e.writeInt(int32(v))
+	case int16:
+		e.writeInt(int32(v))
+	case int32:
+		e.writeInt(v)
+	case uint8:
+		e.writeInt(int32(v))
+	case uint16:
+		e.writeInt(int32(v))
+	case int:
+		e.writeNumber(int64(v))
+	case uint32:
+		e.writeNumber(int64(v))
+	case int64:
+		e.writeLong(v)
+	case uint64:
+		e.writeLong(int64(v))
+	case float32:
+		e.writeDouble(float64(v))
+	case float64:
+		// numbers from json are float64, integral ones are written as int or long
+		if v == math.Trunc(v) && math.Abs(v) < 1<<53 {
+			e.writeNumber(int64(v))
+		} else {
+			e.writeDouble(v)
+		}
+	case string:
+		e.writeString(v)
+	case []byte:
+		e.writeBinary(v)
+	case time.Time:
+		e.buf.WriteByte(0x4a)
+		binary.Write(e.buf, binary.BigEndian, v.UnixNano()/int64(time.Millisecond))
+	case map[string]interface{}:
+		if className, ok := v[hessianClassKey].(string); ok {
+			return e.writeObject(className, v)
+		}
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		e.buf.WriteByte('H')
+		for _, k := range keys {
+			e.writeString(k)
+			if err := e.Encode(v[k]); err != nil {
+				return err
+			}
+		}
+		e.buf.WriteByte('Z')
+	case []interface{}:
+		e.writeListStart(len(v))
+		for _, item := range v {
+			if err := e.Encode(item); err != nil {
+				return err
+			}
+		}
+	default:
+		return e.encodeValue(reflect.ValueOf(v))
+	}
+
+	return nil
+}
+
+// encode other slices and maps with reflection
+func (e *hessianEncoder) encodeValue(rv reflect.Value) error {
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array:
+		e.writeListStart(rv.Len())
+		for idx := 0; idx < rv.Len(); idx++ {
+			if err := e.Encode(rv.Index(idx).Interface()); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		e.buf.WriteByte('H')
+		for _, k := range rv.MapKeys() {
+			if err := e.Encode(k.Interface()); err != nil {
+				return err
+			}
+			if err := e.Encode(rv.MapIndex(k).Interface()); err != nil {
+				return err
+			}
+		}
+		e.buf.WriteByte('Z')
+	case reflect.Ptr, reflect.Interface:
+		if rv.IsNil() {
+			e.buf.WriteByte('N')
+			return nil
+		}
+		return e.Encode(rv.Elem().Interface())
+	default:
+		return fmt.Errorf("unsupported hessian type: %s", rv.Type())
+	}
+	return nil
+}
+
+func (e *hessianEncoder) writeNumber(v int64) {
+	if v >= math.MinInt32 && v <= math.MaxInt32 {
+		e.writeInt(int32(v))
+	} else {
+		e.writeLong(v)
+	}
+}
+
+func (e *hessianEncoder) writeInt(v int32) {
+	switch {
+	case v >= -16 && v <= 47:
+		e.buf.WriteByte(byte(0x90 + v))
+	case v >= -2048 && v <= 2047:
+		e.buf.Write([]byte{byte(0xc8 + (v >> 8)), byte(v)})
+	case v >= -262144 && v <= 262143:
+		e.buf.Write([]byte{byte(0xd4 + (v >> 16)), byte(v >> 8), byte(v)})
+	default:
+		e.buf.WriteByte('I')
+		binary.Write(e.buf, binary.BigEndian, v)
+	}
+}
+
+func (e *hessianEncoder) writeLong(v int64) {
+	switch {
+	case v >= -8 && v <= 15:
+		e.buf.WriteByte(byte(0xe0 + v))
+	case v >= -2048 && v <= 2047:
+		e.buf.Write([]byte{byte(0xf8 + (v >> 8)), byte(v)})
+	case v >= -262144 && v <= 262143:
+		e.buf.Write([]byte{byte(0x3c + (v >> 16)), byte(v >> 8), byte(v)})
+	case v >= math.MinInt32 && v <= math.MaxInt32:
+		e.buf.WriteByte(0x59)
+		binary.Write(e.buf, binary.BigEndian, int32(v))
+	default:
+		e.buf.WriteByte('L')
+		binary.Write(e.buf, binary.BigEndian, v)
+	}
+}
+
+func (e *hessianEncoder) writeDouble(v float64) {
+	switch {
+	case v == 0:
+		e.buf.WriteByte(0x5b)
+	case v == 1:
+		e.buf.WriteByte(0x5c)
+	case v == math.Trunc(v) && v >= -128 && v <= 127:
+		e.buf.Write([]byte{0x5d, byte(int8(v))})
+	case v == math.Trunc(v) && v >= -32768 && v <= 32767:
+		e.buf.WriteByte(0x5e)
+		binary.Write(e.buf, binary.BigEndian, int16(v))
+	case v*1000 == math.Trunc(v*1000) && v*1000 >= math.MinInt32 && v*1000 <= math.MaxInt32:
+		e.buf.WriteByte(0x5f)
+		binary.Write(e.buf, binary.BigEndian, int32(v*1000))
+	default:
+		e.buf.WriteByte('D')
+		binary.Write(e.buf, binary.BigEndian, v)
+	}
+}
+
+// string length is the count of UTF-16 code units, every unit is encoded in UTF-8 like java
+func (e *hessianEncoder) writeString(s string) {
+	units := utf16.Encode([]rune(s))
+
+	for len(units) > hessianChunkSize {
+		e.buf.WriteByte('R')
+		binary.Write(e.buf, binary.BigEndian, uint16(hessianChunkSize))
+		writeUTF16Units(e.buf, units[:hessianChunkSize])
+		units = units[hessianChunkSize:]
+	}
+	switch n := len(units); {
+	case n <= 31:
+		e.buf.WriteByte(byte(n))
+	case n <= 1023:
+		e.buf.Write([]byte{byte(0x30 + (n >> 8)), byte(n)})
+	default:
+		e.buf.WriteByte('S')
+		binary.Write(e.buf, binary.BigEndian, uint16(n))
+	}
+	writeUTF16Units(e.buf, units)
+}
+
+func writeUTF16Units(buf *bytes.Buffer, units []uint16) {
+	for _, u := range units {
+		switch {
+		case u < 0x80:
+			buf.WriteByte(byte(u))
+		case u < 0x800:
+			buf.Write([]byte{byte(0xc0 + (u >> 6)), byte(0x80 + (u & 0x3f))})
+		default:
+			buf.Write([]byte{byte(0xe0 + (u >> 12)), byte(0x80 + ((u >> 6) & 0x3f)), byte(0x80 + (u & 0x3f))})
+		}
+	}
+}
+
+func (e *hessianEncoder) writeBinary(b []byte) {
+	for len(b) > hessianChunkSize {
+		e.buf.WriteByte('A')
+		binary.Write(e.buf, binary.BigEndian, uint16(hessianChunkSize))
+		e.buf.Write(b[:hessianChunkSize])
+		b = b[hessianChunkSize:]
+	}
+	switch n := len(b); {
+	case n <= 15:
+		e.buf.WriteByte(byte(0x20 + n))
+	case n <= 1023:
+		e.buf.Write([]byte{byte(0x34 + (n >> 8)), byte(n)})
+	default:
+		e.buf.WriteByte('B')
+		binary.Write(e.buf, binary.BigEndian, uint16(n))
+	}
+	e.buf.Write(b)
+}
+
+// untyped fixed length list
+func (e *hessianEncoder) writeListStart(n int) {
+	if n <= 7 {
+		e.buf.WriteByte(byte(0x78 + n))
+	} else {
+		e.buf.WriteByte(0x58)
+		e.writeInt(int32(n))
+	}
+}
+
+// object fields are written in name order
+func (e *hessianEncoder) writeObject(className string, fields map[string]interface{}) error {
+	names := make([]string, 0, len(fields))
+	for k := range fields {
+		if k != hessianClassKey {
+			names = append(names, k)
+		}
+	}
+	sort.Strings(names)
+
+	defKey := fmt.Sprintf("%s%v", className, names)
+	idx, exists := e.classDefs[defKey]
+	if !exists {
+		idx = len(e.classDefs)
+		e.classDefs[defKey] = idx
+		e.buf.WriteByte('C')
+		e.writeString(className)
+		e.writeInt(int32(len(names)))
+		for _, name := range names {
+			e.writeString(name)
+		}
+	}
+	if idx <= 15 {
+		e.buf.WriteByte(byte(0x60 + idx))
+	} else {
+		e.buf.WriteByte('O')
+		e.writeInt(int32(idx))
+	}
+	for _, name := range names {
+		if err := e.Encode(fields[name]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ---------------------------------- decoder ----------------------------------
+
+type hessianClassDef struct {
+	name   string
+	fields []string
+}
+
+type hessianDecoder struct {
+	r         *bufio.Reader
+	types     []string
+	classDefs []*hessianClassDef
+	refs      []interface{}
+}
+
+func newHessianDecoder(r io.Reader) *hessianDecoder {
+	return &hessianDecoder{r: bufio.NewReader(r)}
+}
+
+// decode next value, int is int32, long is int64, double is float64, date is time.Time,
+// binary is []byte, list is []interface{}, map is map[string]interface{} and
+// object is map[string]interface{} with class name in "@class"
+func (d *hessianDecoder) Decode() (interface{}, error) {
+	tag, err := d.r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	return d.decodeTag(tag)
+}
+
+func (d *hessianDecoder) decodeTag(tag byte) (interface{}, error) {
+	switch {
+	case tag <= 0x1f:
+		return d.readStringChunks(tag)
+	case tag >= 0x20 && tag <= 0x2f:
+		return d.readBytes(int(tag - 0x20))
+	case tag >= 0x30 && tag <= 0x33:
+		return d.readStringChunks(tag)
+	case tag >= 0x34 && tag <= 0x37:
+		b, err := d.r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		return d.readBytes(int(tag-0x34)<<8 + int(b))
+	case tag >= 0x38 && tag <= 0x3f:
+		b, err := d.readBytes(2)
+		if err != nil {
+			return nil, err
+		}
+		return int64(int(tag)-0x3c)<<16 + int64(b[0])<<8 + int64(b[1]), nil
+	case tag >= 0x60 && tag <= 0x6f:
+		return d.readObject(int(tag - 0x60))
+	case tag >= 0x70 && tag <= 0x77:
+		if _, err := d.readType(); err != nil {
+			return nil, err
+		}
+		return d.readList(int(tag - 0x70))
+	case tag >= 0x78 && tag <= 0x7f:
+		return d.readList(int(tag - 0x78))
+	case tag >= 0x80 && tag <= 0xbf:
+		return int32(tag) - 0x90, nil
+	case tag >= 0xc0 && tag <= 0xcf:
+		b, err := d.r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		return (int32(tag)-0xc8)<<8 + int32(b), nil
+	case tag >= 0xd0 && tag <= 0xd7:
+		b, err := d.readBytes(2)
+		if err != nil {
+			return nil, err
+		}
+		return (int32(tag)-0xd4)<<16 + int32(b[0])<<8 + int32(b[1]), nil
+	case tag >= 0xd8 && tag <= 0xef:
+		return int64(tag) - 0xe0, nil
+	case tag >= 0xf0:
+		b, err := d.r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		return (int64(tag)-0xf8)<<8 + int64(b), nil
+	}
+
+	switch tag {
+	case 'N':
+		return nil, nil
+	case 'T':
+		return true, nil
+	case 'F':
+		return false, nil
+	case 'I':
+		var v int32
+		err := binary.Read(d.r, binary.BigEndian, &v)
+		return v, err
+	case 'L':
+		var v int64
+		err := binary.Read(d.r, binary.BigEndian, &v)
+		return v, err
+	case 0x59:
+		var v int32
+		err := binary.Read(d.r, binary.BigEndian, &v)
+		return int64(v), err
+	case 'D':
+		var v float64
+		err := binary.Read(d.r, binary.BigEndian, &v)
+		return v, err
+	case 0x5b:
+		return float64(0), nil
+	case 0x5c:
+		return float64(1), nil
+	case 0x5d:
+		b, err := d.r.ReadByte()
+		return float64(int8(b)), err
+	case 0x5e:
+		var v int16
+		err := binary.Read(d.r, binary.BigEndian, &v)
+		return float64(v), err
+	case 0x5f:
+		var v int32
+		err := binary.Read(d.r, binary.BigEndian, &v)
+		return float64(v) * 0.001, err
+	case 0x4a:
+		var v int64
+		err := binary.Read(d.r, binary.BigEndian, &v)
+		return time.Unix(0, v*int64(time.Millisecond)), err
+	case 0x4b:
+		var v int32
+		err := binary.Read(d.r, binary.BigEndian, &v)
+		return time.Unix(int64(v)*60, 0), err
+	case 'R', 'S':
+		return d.readStringChunks(tag)
+	case 'A', 'B':
+		return d.readBinaryChunks(tag)
+	case 'C':
+		if err := d.readClassDef(); err != nil {
+			return nil, err
+		}
+		return d.Decode()
+	case 'O':
+		idx, err := d.readInt()
+		if err != nil {
+			return nil, err
+		}
+		return d.readObject(int(idx))
+	case 'H':
+		return d.readMap()
+	case 'M':
+		if _, err := d.readType(); err != nil {
+			return nil, err
+		}
+		return d.readMap()
+	case 'U':
+		if _, err := d.readType(); err != nil {
+			return nil, err
+		}
+		return d.readList(-1)
+	case 'W':
+		return d.readList(-1)
+	case 'V':
+		if _, err := d.readType(); err != nil {
+			return nil, err
+		}
+		n, err := d.readInt()
+		if err != nil {
+			return nil, err
+		}
+		return d.readList(int(n))
+	case 'X':
+		n, err := d.readInt()
+		if err != nil {
+			return nil, err
+		}
+		return d.readList(int(n))
+	case 'Q':
+		idx, err := d.readInt()
+		if err != nil {
+			return nil, err
+		}
+		if idx < 0 || int(idx) >= len(d.refs) {
+			return nil, fmt.Errorf("invalid hessian reference: %d", idx)
+		}
+		return d.refs[idx], nil
+	default:
+		return nil, fmt.Errorf("unsupported hessian tag: 0x%x", tag)
+	}
+}
+
+func (d *hessianDecoder) readBytes(n int) ([]byte, error) {
+	b := make([]byte, n)
+	_, err := io.ReadFull(d.r, b)
+	return b, err
+}
+
+func (d *hessianDecoder) readInt() (int32, error) {
+	v, err := d.Decode()
+	if err != nil {
+		return 0, err
+	}
+	i, ok := v.(int32)
+	if !ok {
+		return 0, fmt.Errorf("hessian int expected, got %T", v)
+	}
+	return i, nil
+}
+
+func (d *hessianDecoder) readString() (string, error) {
+	v, err := d.Decode()
+	if err != nil {
+		return "", err
+	}
+	if v == nil {
+		return "", nil
+	}
+	s, ok := v.(string)
+	if !ok {
+		return "", fmt.Errorf("hessian string expected, got %T", v)
+	}
+	return s, nil
+}
+
+// type is a string or an int reference to previous types
+func (d *hessianDecoder) readType() (string, error) {
+	v, err := d.Decode()
+	if err != nil {
+		return "", err
+	}
+	switch v := v.(type) {
+	case string:
+		d.types = append(d.types, v)
+		return v, nil
+	case int32:
+		if v < 0 || int(v) >= len(d.types) {
+			return "", fmt.Errorf("invalid hessian type reference: %d", v)
+		}
+		return d.types[v], nil
+	default:
+		return "", fmt.Errorf("invalid hessian type: %v", v)
+	}
+}
+
+func (d *hessianDecoder) readStringChunks(tag byte) (string, error) {
+	units := []uint16{}
+
+	for {
+		var (
+			n     int
+			final = true
+		)
+		switch {
+		case tag <= 0x1f:
+			n = int(tag)
+		case tag >= 0x30 && tag <= 0x33:
+			b, err := d.r.ReadByte()
+			if err != nil {
+				return "", err
+			}
+			n = int(tag-0x30)<<8 + int(b)
+		case tag == 'R' || tag == 'S':
+			var l uint16
+			if err := binary.Read(d.r, binary.BigEndian, &l); err != nil {
+				return "", err
+			}
+			n, final = int(l), tag == 'S'
+		default:
+			return "", fmt.Errorf("invalid hessian string chunk: 0x%x", tag)
+		}
+		for idx := 0; idx < n; idx++ {
+			u, err := d.readUTF16Unit()
+			if err != nil {
+				return "", err
+			}
+			units = append(units, u)
+		}
+		if final {
+			return string(utf16.Decode(units)), nil
+		}
+		var err error
+		if tag, err = d.r.ReadByte(); err != nil {
+			return "", err
+		}
+	}
+}
+
+func (d *hessianDecoder) readUTF16Unit() (uint16, error) {
+	b0, err := d.r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	switch {
+	case b0 < 0x80:
+		return uint16(b0), nil
+	case b0&0xe0 == 0xc0:
+		b1, err := d.r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		return uint16(b0&0x1f)<<6 + uint16(b1&0x3f), nil
+	case b0&0xf0 == 0xe0:
+		b, err := d.readBytes(2)
+		if err != nil {
+			return 0, err
+		}
+		return uint16(b0&0x0f)<<12 + uint16(b[0]&0x3f)<<6 + uint16(b[1]&0x3f), nil
+	default:
+		return 0, fmt.Errorf("invalid hessian string byte: 0x%x", b0)
+	}
+}
+
+func (d *hessianDecoder) readBinaryChunks(tag byte) ([]byte, error) {
+	var data []byte
+
+	for {
+		var l uint16
+		if err := binary.Read(d.r, binary.BigEndian, &l); err != nil {
+			return nil, err
+		}
+		b, err := d.readBytes(int(l))
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, b...)
+		if tag == 'B' {
+			return data, nil
+		}
+		if tag, err = d.r.ReadByte(); err != nil {
+			return nil, err
+		}
+		switch {
+		case tag >= 0x20 && tag <= 0x2f:
+			b, err := d.readBytes(int(tag - 0x20))
+			return append(data, b...), err
+		case tag == 'A' || tag == 'B':
+		default:
+			return nil, fmt.Errorf("invalid hessian binary chunk: 0x%x", tag)
+		}
+	}
+}
+
+// read list with length, -1 length means variable list ended with 'Z'
+func (d *hessianDecoder) readList(n int) ([]interface{}, error) {
+	items := []interface{}{}
+	refIdx := len(d.refs)
+	d.refs = append(d.refs, items)
+
+	for idx := 0; n < 0 || idx < n; idx++ {
+		tag, err := d.r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 && tag == 'Z' {
+			break
+		}
+		item, err := d.decodeTag(tag)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	d.refs[refIdx] = items
+	return items, nil
+}
+
+// map keys are converted to string
+func (d *hessianDecoder) readMap() (map[string]interface{}, error) {
+	m := map[string]interface{}{}
+	d.refs = append(d.refs, m)
+
+	for {
+		tag, err := d.r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		if tag == 'Z' {
+			return m, nil
+		}
+		k, err := d.decodeTag(tag)
+		if err != nil {
+			return nil, err
+		}
+		v, err := d.Decode()
+		if err != nil {
+			return nil, err
+		}
+		if ks, ok := k.(string); ok {
+			m[ks] = v
+		} else {
+			m[fmt.Sprintf("%v", k)] = v
+		}
+	}
+}
+
+func (d *hessianDecoder) readClassDef() error {
+	name, err := d.readString()
+	if err != nil {
+		return err
+	}
+	n, err := d.readInt()
+	if err != nil {
+		return err
+	}
+	def := &hessianClassDef{name: name, fields: make([]string, n)}
+	for idx := range def.fields {
+		if def.fields[idx], err = d.readString(); err != nil {
+			return err
+		}
+	}
+	d.classDefs = append(d.classDefs, def)
+	return nil
+}
+
+func (d *hessianDecoder) readObject(defIdx int) (map[string]interface{}, error) {
+	if defIdx < 0 || defIdx >= len(d.classDefs) {
+		return nil, fmt.Errorf("invalid hessian class definition: %d", defIdx)
+	}
+	def := d.classDefs[defIdx]
+	obj := map[string]interface{}{hessianClassKey: def.name}
+	d.refs = append(d.refs, obj)
+
+	for _, field := range def.fields {
+		v, err := d.Decode()
+		if err != nil {
+			return nil, err
+		}
+		obj[field] = v
+	}
+	return obj, nil
+}
@@ -0,0 +1,123 @@
+package protocols
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func hessianEncode(v interface{}) []byte {
+	enc := newHessianEncoder()
+	if err := enc.Encode(v); err != nil {
+		panic(err)
+	}
+	return enc.Bytes()
+}
+
+func hessianDecode(b []byte) (interface{}, error) {
+	return newHessianDecoder(bytes.NewReader(b)).Decode()
+}
+
+func TestHessianEncode(t *testing.T) {
+	Convey("encode with spec examples", t, func() {
+		So(hessianEncode(nil), ShouldResemble, []byte{'N'})
+		So(hessianEncode(true), ShouldResemble, []byte{'T'})
+		So(hessianEncode(false), ShouldResemble, []byte{'F'})
+		So(hessianEncode(int32(0)), ShouldResemble, []byte{0x90})
+		So(hessianEncode(int32(-16)), ShouldResemble, []byte{0x80})
+		So(hessianEncode(int32(47)), ShouldResemble, []byte{0xbf})
+		So(hessianEncode(int32(-2048)), ShouldResemble, []byte{0xc0, 0x00})
+		So(hessianEncode(int32(2047)), ShouldResemble, []byte{0xcf, 0xff})
+		So(hessianEncode(int32(262143)), ShouldResemble, []byte{0xd7, 0xff, 0xff})
+		So(hessianEncode(int32(1<<20)), ShouldResemble, []byte{'I', 0x00, 0x10, 0x00, 0x00})
+		So(hessianEncode(int64(0)), ShouldResemble, []byte{0xe0})
+		So(hessianEncode(int64(-8)), ShouldResemble, []byte{0xd8})
+		So(hessianEncode(int64(2047)), ShouldResemble, []byte{0xff, 0xff})
+		So(hessianEncode(int64(300)), ShouldResemble, []byte{0xf9, 0x2c})
+		So(hessianEncode(12.25), ShouldResemble, []byte{0x5f, 0x00, 0x00, 0x2f, 0xda})
+		So(hessianEncode(0.0001), ShouldResemble, []byte{'D', 0x3f, 0x1a, 0x36, 0xe2, 0xeb, 0x1c, 0x43, 0x2d})
+		So(hessianEncode(""), ShouldResemble, []byte{0x00})
+		So(hessianEncode("hello"), ShouldResemble, []byte{0x05, 'h', 'e', 'l', 'l', 'o'})
+		So(hessianEncode([]byte{1, 2}), ShouldResemble, []byte{0x22, 1, 2})
+	})
+
+	Convey("encode double in compact format", t, func() {
+		for v, expected := range map[float64][]byte{
+			0.0:    {0x5b},
+			1.0:    {0x5c},
+			-128.0: {0x5d, 0x80},
+			1000.0: {0x5e, 0x03, 0xe8},
+			0.5:    {0x5f, 0x00, 0x00, 0x01, 0xf4},
+		} {
+			enc := newHessianEncoder()
+			enc.writeDouble(v)
+			So(enc.Bytes(), ShouldResemble, expected)
+			out, err := hessianDecode(enc.Bytes())
+			So(err, ShouldBeNil)
+			So(out, ShouldEqual, v)
+		}
+	})
+
+	Convey("json numbers are encoded as int or long", t, func() {
+		So(hessianEncode(float64(3)), ShouldResemble, []byte{0x93})
+		So(hessianEncode(float64(1 << 40))[0], ShouldEqual, byte('L'))
+		So(hessianEncode(3.5)[0], ShouldEqual, byte(0x5f))
+	})
+}
+
+func TestHessianRoundTrip(t *testing.T) {
+	Convey("decode what encoded", t, func() {
+		for _, v := range []interface{}{
+			nil, true, false,
+			int32(0), int32(-17), int32(1000), int32(-200000), int32(1 << 30),
+			int64(0), int64(-9), int64(100000), int64(1 << 40),
+			0.125, -2.5, 3.1415926,
+			"", "hello", "中文😀", strings.Repeat("x", 40000),
+			[]byte{}, []byte("binary"),
+		} {
+			out, err := hessianDecode(hessianEncode(v))
+			So(err, ShouldBeNil)
+			So(out, ShouldResemble, v)
+		}
+	})
+
+	Convey("date, list and map", t, func() {
+		now := time.Unix(1571000000, 123000000).UTC()
+		out, err := hessianDecode(hessianEncode(now))
+		So(err, ShouldBeNil)
+		So(out.(time.Time).Equal(now), ShouldBeTrue)
+
+		out, err = hessianDecode(hessianEncode([]interface{}{"a", int32(1), nil}))
+		So(err, ShouldBeNil)
+		So(out, ShouldResemble, []interface{}{"a", int32(1), nil})
+
+		out, err = hessianDecode(hessianEncode(map[string]interface{}{"name": "you", "tags": []interface{}{"x"}}))
+		So(err, ShouldBeNil)
+		So(out, ShouldResemble, map[string]interface{}{"name": "you", "tags": []interface{}{"x"}})
+	})
+
+	Convey("java objects with class definitions reused", t, func() {
+		enc := newHessianEncoder()
+		So(enc.Encode([]interface{}{
+			map[string]interface{}{hessianClassKey: "com.foo.User", "name": "a", "age": int32(1)},
+			map[string]interface{}{hessianClassKey: "com.foo.User", "name": "b", "age": int32(2)},
+		}), ShouldBeNil)
+		So(bytes.Count(enc.Bytes(), []byte("com.foo.User")), ShouldEqual, 1)
+		out, err := hessianDecode(enc.Bytes())
+		So(err, ShouldBeNil)
+		So(out, ShouldResemble, []interface{}{
+			map[string]interface{}{hessianClassKey: "com.foo.User", "name": "a", "age": int32(1)},
+			map[string]interface{}{hessianClassKey: "com.foo.User", "name": "b", "age": int32(2)},
+		})
+	})
+
+	Convey("invalid data", t, func() {
+		_, err := hessianDecode([]byte{'I', 0x00})
+		So(err, ShouldNotBeNil)
+		_, err = hessianDecode([]byte{'Q', 0x90})
+		So(err, ShouldNotBeNil)
+	})
+}
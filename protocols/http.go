@@ -1,9 +1,515 @@
 package protocols
 
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/feiyuw/simgo/logger"
+)
+
+// ================================== client ==================================
+
+// request of http client
+type HTTPRequest struct {
+	Headers  map[string]string `json:"headers"`
+	Query    map[string]string `json:"query"`
+	BodyType string            `json:"bodyType"` // json(default), form or raw
+	Body     interface{}       `json:"body"`     // object for json and form, string for raw
+	Timeout  string            `json:"timeout"`  // timeout of this request, eg. 500ms, override client timeout
+}
+
+// response of http request, body is decoded if it's json
+type HTTPResponse struct {
+	Status  int               `json:"status"`
+	Headers map[string]string `json:"headers"`
+	Body    interface{}       `json:"body"`
+}
+
 type HTTPClient struct {
-	addr string // connected service addr, eg. http://127.0.0.1:2345
+	addr   string // connected service addr, eg. http://127.0.0.1:2345
+	client *http.Client
+}
+
+// Create a new http client, 0 timeout means no timeout, https used if tlsConfig set
+func NewHTTPClient(addr string, timeout time.Duration, tlsConfig *tls.Config) (*HTTPClient, error) {
+	if addr == "" {
+		return nil, fmt.Errorf("addr should not be empty")
+	}
+	if !strings.HasPrefix(addr, "http://") && !strings.HasPrefix(addr, "https://") {
+		if tlsConfig != nil {
+			addr = "https://" + addr
+		} else {
+			addr = "http://" + addr
+		}
+	}
+	if _, err := url.Parse(addr); err != nil {
+		return nil, fmt.Errorf("invalid addr: %v", err)
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	return &HTTPClient{
+		addr:   strings.TrimSuffix(addr, "/"),
+		client: &http.Client{Timeout: timeout, Transport: transport},
+	}, nil
+}
+
+func (hc *HTTPClient) Close() error {
+	hc.client.CloseIdleConnections()
+	return nil
+}
+
+// invoke http request, mtd is http method and path, eg. "GET /users/1"
+// reqData is a HTTPRequest, or its json in string or map
+func (hc *HTTPClient) InvokeRPC(mtd string, reqData interface{}) (interface{}, error) {
+	method, path := parseHTTPMethod(mtd)
+	req, err := toHTTPRequest(reqData)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := context.Background()
+	if req.Timeout != "" {
+		timeout, err := time.ParseDuration(req.Timeout)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timeout: %v", err)
+		}
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	body, contentType, err := encodeHTTPBody(req.BodyType, req.Body)
+	if err != nil {
+		return nil, err
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, method, hc.addr+path, body)
+	if err != nil {
+		return nil, err
+	}
+	if len(req.Query) > 0 {
+		query := httpReq.URL.Query()
+		for k, v := range req.Query {
+			query.Set(k, v)
+		}
+		httpReq.URL.RawQuery = query.Encode()
+	}
+	if contentType != "" {
+		httpReq.Header.Set("Content-Type", contentType)
+	}
+	for k, v := range req.Headers {
+		httpReq.Header.Set(k, v)
+	}
+
+	resp, err := hc.client.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	return readHTTPResponse(resp)
+}
+
+// parse http method and path, eg. "POST /users", path is "/" if not set
+func parseHTTPMethod(mtd string) (string, string) {
+	parts := strings.SplitN(strings.TrimSpace(mtd), " ", 2)
+	method := strings.ToUpper(parts[0])
+	if method == "" {
+		method = http.MethodGet
+	}
+	path := "/"
+	if len(parts) == 2 {
+		path = strings.TrimSpace(parts[1])
+	}
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	return method, path
+}
+
+func toHTTPRequest(reqData interface{}) (*HTTPRequest, error) {
+	var b []byte
+
+	switch reqData := reqData.(type) {
+	case nil:
+		return &HTTPRequest{}, nil
+	case *HTTPRequest:
+		return reqData, nil
+	case HTTPRequest:
+		return &reqData, nil
+	case string:
+		if strings.TrimSpace(reqData) == "" {
+			return &HTTPRequest{}, nil
+		}
+		b = []byte(reqData)
+	default:
+		var err error
+		if b, err = json.Marshal(reqData); err != nil {
+			return nil, err
+		}
+	}
+
+	req := new(HTTPRequest)
+	if err := json.Unmarshal(b, req); err != nil {
+		return nil, fmt.Errorf("invalid http request: %v", err)
+	}
+	return req, nil
 }
 
-func NewHTTPClient(addr string) (*HTTPClient, error) {
-	return &HTTPClient{addr: addr}, nil
+// encode body with its type, returns body reader and content type
+func encodeHTTPBody(bodyType string, body interface{}) (io.Reader, string, error) {
+	if body == nil {
+		return nil, "", nil
+	}
+
+	switch bodyType {
+	case "", "json":
+		if s, ok := body.(string); ok {
+			return strings.NewReader(s), "application/json", nil
+		}
+		b, err := json.Marshal(body)
+		if err != nil {
+			return nil, "", err
+		}
+		return bytes.NewReader(b), "application/json", nil
+	case "form":
+		fields, ok := body.(map[string]interface{})
+		if !ok {
+			return nil, "", fmt.Errorf("form body should be an object")
+		}
+		values := url.Values{}
+		for k, v := range fields {
+			values.Set(k, valueString(v))
+		}
+		return strings.NewReader(values.Encode()), "application/x-www-form-urlencoded", nil
+	case "raw":
+		s, ok := body.(string)
+		if !ok {
+			return nil, "", fmt.Errorf("raw body should be a string")
+		}
+		return strings.NewReader(s), "", nil
+	default:
+		return nil, "", fmt.Errorf("unsupported body type: %s", bodyType)
+	}
+}
+
+func readHTTPResponse(resp *http.Response) (*HTTPResponse, error) {
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	out := &HTTPResponse{Status: resp.StatusCode, Headers: flattenHeaders(resp.Header), Body: string(b)}
+	if strings.Contains(resp.Header.Get("Content-Type"), "json") && len(b) > 0 {
+		var body interface{}
+		if err := json.Unmarshal(b, &body); err == nil {
+			out.Body = body
+		}
+	}
+	return out, nil
+}
+
+// multiple values of one header are joined with ", "
+func flattenHeaders(header http.Header) map[string]string {
+	headers := make(map[string]string, len(header))
+	for k, v := range header {
+		headers[k] = strings.Join(v, ", ")
+	}
+	return headers
+}
+
+// ================================== server ==================================
+
+// request received by http server
+type HTTPServerRequest struct {
+	Method  string            `json:"method"`
+	Path    string            `json:"path"`
+	Params  map[string]string `json:"params"` // path parameters, eg. id of /users/:id
+	Query   map[string]string `json:"query"`
+	Headers map[string]string `json:"headers"`
+	Body    string            `json:"body"`
+	Peer    string            `json:"peer"`
+}
+
+// route of http server, pattern is like /users/:id or /static/*
+type httpRoute struct {
+	method   string // http method or ANY
+	pattern  string
+	segments []string
+	handler  func(req *HTTPServerRequest, resp *HTTPResponse) error
+}
+
+type HTTPServer struct {
+	sync.RWMutex
+
+	addr      string
+	tlsConfig *tls.Config
+	server    *http.Server
+	lis       net.Listener
+	routes    []*httpRoute
+	listeners []func(mtd, direction, from, to, body string, seq int) error
+}
+
+// create a new http server, https used if tlsConfig set
+func NewHTTPServer(addr string, tlsConfig *tls.Config) (*HTTPServer, error) {
+	hs := &HTTPServer{addr: addr, tlsConfig: tlsConfig, routes: []*httpRoute{}}
+	hs.server = &http.Server{Addr: addr, Handler: hs}
+	return hs, nil
+}
+
+func (hs *HTTPServer) Start() error {
+	lis, err := net.Listen("tcp", hs.addr)
+	if err != nil {
+		return err
+	}
+	if hs.tlsConfig != nil {
+		lis = tls.NewListener(lis, hs.tlsConfig)
+	}
+	hs.lis = lis
+	logger.Infof("protocols/http", "server listening at %v", lis.Addr())
+
+	go func() {
+		if err := hs.server.Serve(lis); err != nil && err != http.ErrServerClosed {
+			logger.Errorf("protocols/http", "failed to serve: %v", err)
+		}
+	}()
+
+	return nil
+}
+
+func (hs *HTTPServer) Close() error {
+	if err := hs.server.Close(); err != nil {
+		return err
+	}
+	// listener may be not tracked by server if Serve not called yet
+	if hs.lis != nil {
+		hs.lis.Close()
+	}
+	hs.Lock()
+	hs.routes = []*httpRoute{}
+	hs.Unlock()
+	logger.Infof("protocols/http", "http server %s stopped", hs.addr)
+
+	return nil
+}
+
+// add a listener for all requests and responses of the server, seq is always 1
+func (hs *HTTPServer) AddListener(listener func(mtd, direction, from, to, body string, seq int) error) {
+	hs.listeners = append(hs.listeners, listener)
+	logger.Infof("protocols/http", "new listener added, now %d listeners", len(hs.listeners))
+}
+
+// set handler of method, mtd is http method and path pattern, eg. "GET /users/:id", "ANY /static/*"
+// :name matches one path segment, * matches the rest of path, they can be got from req.Params
+func (hs *HTTPServer) SetMethodHandler(mtd string, handler func(req *HTTPServerRequest, resp *HTTPResponse) error) error {
+	method, pattern := parseHTTPMethod(mtd)
+	segments := splitHTTPPath(pattern)
+	for idx, seg := range segments {
+		if seg == "*" && idx != len(segments)-1 {
+			return fmt.Errorf("invalid path pattern %s: * should be the last segment", pattern)
+		}
+	}
+	route := &httpRoute{method: method, pattern: pattern, segments: segments, handler: handler}
+
+	hs.Lock()
+	defer hs.Unlock()
+	for idx, r := range hs.routes {
+		if r.method == method && r.pattern == pattern {
+			logger.Warnf("protocols/http", "handler for method %s exists, will be overrided", mtd)
+			hs.routes[idx] = route
+			return nil
+		}
+	}
+	hs.routes = append(hs.routes, route)
+	return nil
+}
+
+func (hs *HTTPServer) RemoveMethodHandler(mtd string) error {
+	method, pattern := parseHTTPMethod(mtd)
+
+	hs.Lock()
+	defer hs.Unlock()
+	for idx, r := range hs.routes {
+		if r.method == method && r.pattern == pattern {
+			hs.routes = append(hs.routes[:idx], hs.routes[idx+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+// list methods with handler, eg. "GET /users/:id"
+func (hs *HTTPServer) ListMethods() ([]string, error) {
+	hs.RLock()
+	defer hs.RUnlock()
+	methods := make([]string, len(hs.routes))
+	for idx, r := range hs.routes {
+		methods[idx] = r.method + " " + r.pattern
+	}
+	return methods, nil
+}
+
+func (hs *HTTPServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	req := &HTTPServerRequest{
+		Method:  r.Method,
+		Path:    r.URL.Path,
+		Params:  map[string]string{},
+		Query:   map[string]string{},
+		Headers: flattenHeaders(r.Header),
+		Body:    string(body),
+		Peer:    r.RemoteAddr,
+	}
+	for k, v := range r.URL.Query() {
+		req.Query[k] = strings.Join(v, ", ")
+	}
+
+	mtd := r.Method + " " + r.URL.Path
+	route := hs.matchRoute(req)
+	if route != nil {
+		mtd = route.method + " " + route.pattern
+	}
+	hs.notifyListeners(mtd, "in", req.Peer, hs.addr, req)
+
+	resp := &HTTPResponse{Status: http.StatusOK, Headers: map[string]string{}}
+	if route == nil {
+		resp.Status = http.StatusNotFound
+		resp.Body = fmt.Sprintf("handler for %s %s not found", r.Method, r.URL.Path)
+	} else if err := route.handler(req, resp); err != nil {
+		logger.Errorf("protocols/http", "failed to handle %s: %v", mtd, err)
+		resp.Status = http.StatusInternalServerError
+		resp.Body = err.Error()
+	}
+
+	hs.notifyListeners(mtd, "out", hs.addr, req.Peer, resp)
+	if err := writeHTTPResponse(w, resp); err != nil {
+		logger.Errorf("protocols/http", "failed to write response of %s: %v", mtd, err)
+	}
+}
+
+// find the most specific route, static segments are preferred to parameters and wildcard
+func (hs *HTTPServer) matchRoute(req *HTTPServerRequest) *httpRoute {
+	var (
+		matched   *httpRoute
+		params    map[string]string
+		bestScore = -1
+	)
+
+	segments := splitHTTPPath(req.Path)
+	hs.RLock()
+	defer hs.RUnlock()
+	for _, route := range hs.routes {
+		if route.method != "ANY" && route.method != req.Method {
+			continue
+		}
+		routeParams, score, ok := route.match(segments)
+		if ok && score > bestScore {
+			matched, params, bestScore = route, routeParams, score
+		}
+	}
+	if matched != nil {
+		req.Params = params
+	}
+	return matched
+}
+
+// match path segments, returns path parameters and score of the match
+func (r *httpRoute) match(segments []string) (map[string]string, int, bool) {
+	params := map[string]string{}
+	score := 0
+
+	for idx, seg := range r.segments {
+		if seg == "*" {
+			params["*"] = strings.Join(segments[idx:], "/")
+			return params, score, true
+		}
+		if idx >= len(segments) {
+			return nil, 0, false
+		}
+		if strings.HasPrefix(seg, ":") {
+			params[seg[1:]] = segments[idx]
+			score++
+			continue
+		}
+		if seg != segments[idx] {
+			return nil, 0, false
+		}
+		score += 2
+	}
+	if len(r.segments) != len(segments) {
+		return nil, 0, false
+	}
+	if r.method != "ANY" {
+		score++
+	}
+	return params, score, true
+}
+
+func (hs *HTTPServer) notifyListeners(mtd, direction, from, to string, msg interface{}) {
+	if len(hs.listeners) == 0 {
+		return
+	}
+	b, err := json.Marshal(msg)
+	if err != nil {
+		logger.Errorf("protocols/http", "failed to encode message of %s: %v", mtd, err)
+		return
+	}
+	for _, listener := range hs.listeners {
+		if err := listener(mtd, direction, from, to, string(b), 1); err != nil {
+			logger.Errorf("protocols/http", "listener failed to handle message of %s: %v", mtd, err)
+		}
+	}
+}
+
+func splitHTTPPath(path string) []string {
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return []string{}
+	}
+	return strings.Split(path, "/")
+}
+
+// write response, string and bytes body are written as is, others are encoded to json
+func writeHTTPResponse(w http.ResponseWriter, resp *HTTPResponse) error {
+	var body []byte
+
+	switch b := resp.Body.(type) {
+	case nil:
+	case string:
+		body = []byte(b)
+	case []byte:
+		body = b
+	default:
+		var err error
+		if body, err = json.Marshal(b); err != nil {
+			return err
+		}
+		if _, exists := resp.Headers["Content-Type"]; !exists {
+			w.Header().Set("Content-Type", "application/json")
+		}
+	}
+	for k, v := range resp.Headers {
+		w.Header().Set(k, v)
+	}
+	if resp.Status == 0 {
+		resp.Status = http.StatusOK
+	}
+	w.WriteHeader(resp.Status)
+	_, err := w.Write(body)
+	return err
 }
@@ -0,0 +1,183 @@
+package protocols
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestHTTPClient(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/echo":
+			body, _ := ioutil.ReadAll(r.Body)
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Add("X-Multi", "a")
+			w.Header().Add("X-Multi", "b")
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"method":      r.Method,
+				"query":       r.URL.Query().Get("q"),
+				"token":       r.Header.Get("Authorization"),
+				"contentType": r.Header.Get("Content-Type"),
+				"body":        string(body),
+			})
+		case "/slow":
+			time.Sleep(100 * time.Millisecond)
+			w.Write([]byte("slow"))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte("not found"))
+		}
+	}))
+	defer ts.Close()
+
+	Convey("invoke with json body, headers and query", t, func() {
+		client, err := NewHTTPClient(ts.URL, time.Second, nil)
+		So(err, ShouldBeNil)
+		out, err := client.InvokeRPC("post /echo", `{"headers": {"Authorization": "Bearer abc"}, "query": {"q": "simgo"}, "body": {"name": "you"}}`)
+		So(err, ShouldBeNil)
+		resp := out.(*HTTPResponse)
+		So(resp.Status, ShouldEqual, http.StatusOK)
+		So(resp.Headers["X-Multi"], ShouldEqual, "a, b")
+		body := resp.Body.(map[string]interface{})
+		So(body["method"], ShouldEqual, "POST")
+		So(body["query"], ShouldEqual, "simgo")
+		So(body["token"], ShouldEqual, "Bearer abc")
+		So(body["contentType"], ShouldEqual, "application/json")
+		So(body["body"], ShouldEqual, `{"name":"you"}`)
+	})
+
+	Convey("invoke with form and raw body", t, func() {
+		client, _ := NewHTTPClient(ts.URL, time.Second, nil)
+		out, err := client.InvokeRPC("PUT /echo", map[string]interface{}{"bodyType": "form", "body": map[string]interface{}{"name": "you", "age": 3}})
+		So(err, ShouldBeNil)
+		body := out.(*HTTPResponse).Body.(map[string]interface{})
+		So(body["contentType"], ShouldEqual, "application/x-www-form-urlencoded")
+		So(body["body"], ShouldEqual, "age=3&name=you")
+
+		out, err = client.InvokeRPC("PATCH /echo", &HTTPRequest{BodyType: "raw", Body: "plain text", Headers: map[string]string{"Content-Type": "text/plain"}})
+		So(err, ShouldBeNil)
+		body = out.(*HTTPResponse).Body.(map[string]interface{})
+		So(body["contentType"], ShouldEqual, "text/plain")
+		So(body["body"], ShouldEqual, "plain text")
+
+		_, err = client.InvokeRPC("POST /echo", `{"bodyType": "xml", "body": "<a/>"}`)
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("non-json response and error status", t, func() {
+		client, _ := NewHTTPClient(ts.URL[len("http://"):], 0, nil)
+		out, err := client.InvokeRPC("GET /notexist", "")
+		So(err, ShouldBeNil)
+		So(out.(*HTTPResponse).Status, ShouldEqual, http.StatusNotFound)
+		So(out.(*HTTPResponse).Body, ShouldEqual, "not found")
+	})
+
+	Convey("client and request timeout", t, func() {
+		client, _ := NewRpcClient("http", ts.URL, map[string]interface{}{"timeout": "50ms"})
+		_, err := client.InvokeRPC("GET /slow", nil)
+		So(err, ShouldNotBeNil)
+
+		client, _ = NewRpcClient("http", ts.URL, map[string]interface{}{"timeout": "1s"})
+		out, err := client.InvokeRPC("GET /slow", nil)
+		So(err, ShouldBeNil)
+		So(out.(*HTTPResponse).Body, ShouldEqual, "slow")
+		_, err = client.InvokeRPC("GET /slow", `{"timeout": "10ms"}`)
+		So(err, ShouldNotBeNil)
+
+		_, err = NewRpcClient("http", ts.URL, map[string]interface{}{"timeout": "abc"})
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestHTTPServer(t *testing.T) {
+	s, _ := NewHTTPServer(":4996", nil)
+	s.SetMethodHandler("GET /users/:id", func(req *HTTPServerRequest, resp *HTTPResponse) error {
+		resp.Body = map[string]interface{}{"id": req.Params["id"], "verbose": req.Query["verbose"]}
+		return nil
+	})
+	s.SetMethodHandler("GET /users/me", func(req *HTTPServerRequest, resp *HTTPResponse) error {
+		resp.Body = "me"
+		return nil
+	})
+	s.SetMethodHandler("ANY /static/*", func(req *HTTPServerRequest, resp *HTTPResponse) error {
+		resp.Headers["Content-Type"] = "text/plain"
+		resp.Body = req.Method + " " + req.Params["*"]
+		return nil
+	})
+	s.SetMethodHandler("post /users", func(req *HTTPServerRequest, resp *HTTPResponse) error {
+		resp.Status = http.StatusCreated
+		resp.Headers["Location"] = "/users/1"
+		resp.Body = req.Body
+		return nil
+	})
+	s.Start()
+	defer s.Close()
+	time.Sleep(time.Millisecond) // make sure server started
+
+	client, _ := NewHTTPClient("127.0.0.1:4996", time.Second, nil)
+
+	Convey("path parameters and query", t, func() {
+		out, err := client.InvokeRPC("GET /users/1", `{"query": {"verbose": "true"}}`)
+		So(err, ShouldBeNil)
+		resp := out.(*HTTPResponse)
+		So(resp.Status, ShouldEqual, http.StatusOK)
+		So(resp.Headers["Content-Type"], ShouldEqual, "application/json")
+		So(resp.Body, ShouldResemble, map[string]interface{}{"id": "1", "verbose": "true"})
+	})
+
+	Convey("static path is preferred to path parameter", t, func() {
+		out, err := client.InvokeRPC("GET /users/me", nil)
+		So(err, ShouldBeNil)
+		So(out.(*HTTPResponse).Body, ShouldEqual, "me")
+	})
+
+	Convey("wildcard path and any method", t, func() {
+		out, err := client.InvokeRPC("DELETE /static/js/app.js", nil)
+		So(err, ShouldBeNil)
+		So(out.(*HTTPResponse).Body, ShouldEqual, "DELETE js/app.js")
+	})
+
+	Convey("status, headers and body", t, func() {
+		out, err := client.InvokeRPC("POST /users", `{"bodyType": "raw", "body": "xyz"}`)
+		So(err, ShouldBeNil)
+		resp := out.(*HTTPResponse)
+		So(resp.Status, ShouldEqual, http.StatusCreated)
+		So(resp.Headers["Location"], ShouldEqual, "/users/1")
+		So(resp.Body, ShouldEqual, "xyz")
+	})
+
+	Convey("not found and removed handlers", t, func() {
+		out, err := client.InvokeRPC("PUT /users/1", nil)
+		So(err, ShouldBeNil)
+		So(out.(*HTTPResponse).Status, ShouldEqual, http.StatusNotFound)
+
+		s.RemoveMethodHandler("GET /users/me")
+		out, err = client.InvokeRPC("GET /users/me", nil)
+		So(err, ShouldBeNil)
+		So(out.(*HTTPResponse).Body.(map[string]interface{})["id"], ShouldEqual, "me")
+		So(s.SetMethodHandler("GET /a/*/b", nil), ShouldNotBeNil)
+	})
+
+	Convey("handle listeners", t, func() {
+		msgs := [][]string{}
+		s.AddListener(func(mtd, direction, from, to, body string, seq int) error {
+			msgs = append(msgs, []string{mtd, direction, from, to, body})
+			return nil
+		})
+		_, err := client.InvokeRPC("GET /users/2", nil)
+		So(err, ShouldBeNil)
+		So(len(msgs), ShouldEqual, 2)
+		So(msgs[0][0], ShouldEqual, "GET /users/:id")
+		So(msgs[0][1], ShouldEqual, "in")
+		So(msgs[0][3], ShouldEqual, ":4996")
+		So(msgs[1][1], ShouldEqual, "out")
+		So(msgs[1][2], ShouldEqual, ":4996")
+		So(msgs[1][4], ShouldContainSubstring, `"status":200`)
+	})
+}
@@ -0,0 +1,219 @@
+package protocols
+
+import (
+	"io"
+	"math/rand"
+	"sync"
+
+	"github.com/feiyuw/simgo/logger"
+	"github.com/feiyuw/simgo/utils"
+
+	"github.com/golang/protobuf/protoc-gen-go/descriptor"
+	"github.com/jhump/protoreflect/desc"
+	"github.com/jhump/protoreflect/dynamic"
+	"google.golang.org/grpc"
+)
+
+const (
+	autoMockMaxDepth = 3 // nested message fields deeper than it are not set, so recursive types terminate
+	autoMockMaxItems = 3 // max items of repeated and map fields with random values
+)
+
+// response generator of methods without handler, values are generated from descriptor of out message,
+// they are sample values like field names and 1, or random values if seeded
+type AutoMock struct {
+	lock sync.Mutex
+	rand *rand.Rand // nil for sample values
+}
+
+// auto mock with sample values if seed is 0, or random values of seed, so that responses are reproducible
+func NewAutoMock(seed int64) *AutoMock {
+	am := &AutoMock{}
+	if seed != 0 {
+		am.rand = rand.New(rand.NewSource(seed))
+	}
+	return am
+}
+
+// respond methods without handler and proxy by auto mock, nil to disable
+// NOTE: thread unsafe, should be set before server started
+func (gs *GrpcServer) SetAutoMock(am *AutoMock) {
+	gs.autoMock = am
+}
+
+// rules of method handled by auto mock, unary and server streaming methods respond the request with one message,
+// client streaming methods respond all requests with one message, and bidirectional ones respond every request
+func (am *AutoMock) rules(mtd *desc.MethodDescriptor) []*MethodRule {
+	handler := func(in *dynamic.Message, out *dynamic.Message, stream grpc.ServerStream) error {
+		if !mtd.IsClientStreaming() && !mtd.IsServerStreaming() {
+			am.Fill(out)
+			return nil
+		}
+		for {
+			err := stream.RecvMsg(in)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return err
+			}
+			if !mtd.IsClientStreaming() {
+				break
+			}
+			if mtd.IsServerStreaming() {
+				msg := dynamic.NewMessage(mtd.GetOutputType())
+				am.Fill(msg)
+				if err := stream.SendMsg(msg); err != nil {
+					return err
+				}
+			}
+		}
+		if mtd.IsClientStreaming() && mtd.IsServerStreaming() {
+			return nil
+		}
+		am.Fill(out)
+		return stream.SendMsg(out)
+	}
+	return []*MethodRule{{Handler: handler}}
+}
+
+// set fields of message with generated values, one field of each oneof is set
+func (am *AutoMock) Fill(msg *dynamic.Message) {
+	am.lock.Lock()
+	defer am.lock.Unlock()
+	am.fillMessage(msg, 0)
+}
+
+func (am *AutoMock) fillMessage(msg *dynamic.Message, depth int) {
+	md := msg.GetMessageDescriptor()
+	for _, fd := range md.GetFields() {
+		if fd.GetOneOf() == nil {
+			am.fillField(msg, fd, depth)
+		}
+	}
+	for _, oo := range md.GetOneOfs() {
+		choices := oo.GetChoices()
+		if len(choices) == 0 {
+			continue
+		}
+		am.fillField(msg, choices[am.intn(len(choices))], depth)
+	}
+}
+
+func (am *AutoMock) fillField(msg *dynamic.Message, fd *desc.FieldDescriptor, depth int) {
+	var err error
+	switch {
+	case fd.IsMap():
+		for idx := 0; idx < am.items(); idx++ {
+			value := am.value(fd.GetMapValueType(), depth)
+			if value == nil {
+				return
+			}
+			if err = msg.TryPutMapField(fd, am.value(fd.GetMapKeyType(), depth), value); err != nil {
+				break
+			}
+		}
+	case fd.IsRepeated():
+		for idx := 0; idx < am.items(); idx++ {
+			value := am.value(fd, depth)
+			if value == nil {
+				return
+			}
+			if err = msg.TryAddRepeatedField(fd, value); err != nil {
+				break
+			}
+		}
+	default:
+		if value := am.value(fd, depth); value != nil {
+			err = msg.TrySetField(fd, value)
+		}
+	}
+	if err != nil {
+		logger.Warnf("protocols/grpc", "failed to mock field %s: %v", fd.GetFullyQualifiedName(), err)
+	}
+}
+
+// generated value of field, nil if field should not be set
+func (am *AutoMock) value(fd *desc.FieldDescriptor, depth int) interface{} {
+	switch fd.GetType() {
+	case descriptor.FieldDescriptorProto_TYPE_MESSAGE, descriptor.FieldDescriptorProto_TYPE_GROUP:
+		mt := fd.GetMessageType()
+		// Any without a resolvable type cannot be marshaled
+		if depth >= autoMockMaxDepth || mt.GetFullyQualifiedName() == "google.protobuf.Any" {
+			return nil
+		}
+		nested := dynamic.NewMessage(mt)
+		am.fillMessage(nested, depth+1)
+		return nested
+	case descriptor.FieldDescriptorProto_TYPE_ENUM:
+		values := fd.GetEnumType().GetValues()
+		if am.rand != nil {
+			return values[am.rand.Intn(len(values))].GetNumber()
+		}
+		// the first non-zero value, zero ones are omitted in json
+		for _, v := range values {
+			if v.GetNumber() != 0 {
+				return v.GetNumber()
+			}
+		}
+		return values[0].GetNumber()
+	case descriptor.FieldDescriptorProto_TYPE_STRING:
+		return am.string(fd)
+	case descriptor.FieldDescriptorProto_TYPE_BYTES:
+		return []byte(am.string(fd))
+	case descriptor.FieldDescriptorProto_TYPE_BOOL:
+		return am.rand == nil || am.rand.Intn(2) == 1
+	case descriptor.FieldDescriptorProto_TYPE_INT32, descriptor.FieldDescriptorProto_TYPE_SINT32, descriptor.FieldDescriptorProto_TYPE_SFIXED32:
+		return int32(am.number())
+	case descriptor.FieldDescriptorProto_TYPE_INT64, descriptor.FieldDescriptorProto_TYPE_SINT64, descriptor.FieldDescriptorProto_TYPE_SFIXED64:
+		return int64(am.number())
+	case descriptor.FieldDescriptorProto_TYPE_UINT32, descriptor.FieldDescriptorProto_TYPE_FIXED32:
+		return uint32(am.number())
+	case descriptor.FieldDescriptorProto_TYPE_UINT64, descriptor.FieldDescriptorProto_TYPE_FIXED64:
+		return uint64(am.number())
+	case descriptor.FieldDescriptorProto_TYPE_FLOAT:
+		return float32(am.float())
+	case descriptor.FieldDescriptorProto_TYPE_DOUBLE:
+		return am.float()
+	default:
+		return nil
+	}
+}
+
+// field name, or random letters
+func (am *AutoMock) string(fd *desc.FieldDescriptor) string {
+	if am.rand == nil {
+		return fd.GetName()
+	}
+	return utils.RandStringFrom(am.rand, 8)
+}
+
+func (am *AutoMock) number() int {
+	if am.rand == nil {
+		return 1
+	}
+	return am.rand.Intn(1000)
+}
+
+func (am *AutoMock) float() float64 {
+	if am.rand == nil {
+		return 1.5
+	}
+	return am.rand.Float64() * 1000
+}
+
+// index of n choices, the first one for sample values
+func (am *AutoMock) intn(n int) int {
+	if am.rand == nil {
+		return 0
+	}
+	return am.rand.Intn(n)
+}
+
+// count of repeated items or map entries
+func (am *AutoMock) items() int {
+	if am.rand == nil {
+		return 1
+	}
+	return 1 + am.rand.Intn(autoMockMaxItems)
+}
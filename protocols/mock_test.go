@@ -0,0 +1,112 @@
+package protocols
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jhump/protoreflect/desc"
+	"github.com/jhump/protoreflect/dynamic"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestAutoMock(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "simgo")
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(filepath.Join(dir, "mock.proto"), []byte(`syntax = "proto3";
+package mock;
+import "google/protobuf/any.proto";
+import "google/protobuf/timestamp.proto";
+enum Status { UNKNOWN = 0; ACTIVE = 1; }
+message Node {
+  string name = 1;
+  int64 size = 2;
+  double ratio = 3;
+  bool ok = 4;
+  bytes raw = 5;
+  Status status = 6;
+  repeated string tags = 7;
+  map<string, int32> counts = 8;
+  repeated Node children = 9;
+  oneof target { string url = 10; uint32 port = 11; }
+  google.protobuf.Timestamp created = 12;
+  google.protobuf.Any extra = 13;
+}
+service Nodes { rpc Get(Node) returns (Node); }`), 0644)
+
+	Convey("methods without handler are responded with sample values", t, func() {
+		s, err := NewRpcServer("grpc", "mock", 4984, map[string]interface{}{"importPaths": []interface{}{dir}, "protos": []interface{}{"mock.proto"}, "autoMock": true})
+		So(err, ShouldBeNil)
+		So(s.Start(), ShouldBeNil)
+		defer s.Close()
+
+		client, err := NewRpcClient("grpc", "127.0.0.1:4984", map[string]interface{}{"importPaths": []interface{}{dir}, "protos": []interface{}{"mock.proto"}})
+		So(err, ShouldBeNil)
+		defer client.Close()
+		out, err := client.InvokeRPC("mock.Nodes.Get", map[string]interface{}{})
+		So(err, ShouldBeNil)
+		node := out.(*GrpcResult).Messages[0]
+		So(node["name"], ShouldEqual, "name")
+		So(node["size"], ShouldEqual, "1")
+		So(node["ratio"], ShouldEqual, 1.5)
+		So(node["ok"], ShouldBeTrue)
+		So(node["status"], ShouldEqual, "ACTIVE")
+		So(node["tags"], ShouldResemble, []interface{}{"tags"})
+		So(node["counts"], ShouldResemble, map[string]interface{}{"key": float64(1)})
+		So(node["url"], ShouldEqual, "url")
+		So(node, ShouldNotContainKey, "port")
+		So(node["extra"], ShouldBeNil)
+		So(node, ShouldContainKey, "created")
+		// nested messages are limited by depth
+		child := node["children"].([]interface{})[0].(map[string]interface{})
+		grandchild := child["children"].([]interface{})[0].(map[string]interface{})
+		leaf := grandchild["children"].([]interface{})[0].(map[string]interface{})
+		So(leaf["name"], ShouldEqual, "name")
+		So(leaf["children"], ShouldBeEmpty)
+	})
+
+	Convey("streaming methods are responded too", t, func() {
+		s, err := NewRpcServer("grpc", "echo", 4984, map[string]interface{}{"protos": []interface{}{"echo.proto"}, "autoMock": true, "autoMockSeed": float64(7)})
+		So(err, ShouldBeNil)
+		So(s.Start(), ShouldBeNil)
+		defer s.Close()
+
+		client, err := NewRpcClient("grpc", "127.0.0.1:4984", map[string]interface{}{"protos": []interface{}{"echo.proto"}})
+		So(err, ShouldBeNil)
+		defer client.Close()
+		for _, mtd := range []string{"UnaryEcho", "ServerStreamingEcho", "ClientStreamingEcho", "BidirectionalStreamingEcho"} {
+			out, err := client.InvokeRPC("grpc.examples.echo.Echo."+mtd, map[string]interface{}{"message": "hello"})
+			So(err, ShouldBeNil)
+			So(out.(*GrpcResult).Messages, ShouldHaveLength, 1)
+			So(out.(*GrpcResult).Messages[0]["message"], ShouldHaveLength, 8)
+		}
+	})
+
+	Convey("random values are reproducible by seed", t, func() {
+		fds, err := desc.LoadFileDescriptor("google/protobuf/descriptor.proto")
+		So(err, ShouldBeNil)
+		md := fds.FindMessage("google.protobuf.FileDescriptorProto")
+		m1, m2, m3 := dynamic.NewMessage(md), dynamic.NewMessage(md), dynamic.NewMessage(md)
+		NewAutoMock(42).Fill(m1)
+		NewAutoMock(42).Fill(m2)
+		NewAutoMock(43).Fill(m3)
+		So(dynamic.Equal(m1, m2), ShouldBeTrue)
+		So(dynamic.Equal(m1, m3), ShouldBeFalse)
+	})
+
+	Convey("methods without handler fail if auto mock disabled", t, func() {
+		_, err := NewRpcServer("grpc", "echo", 4984, map[string]interface{}{"protos": []interface{}{"echo.proto"}, "autoMock": true, "autoMockSeed": 1.5})
+		So(err, ShouldNotBeNil)
+		s, err := NewRpcServer("grpc", "echo", 4984, map[string]interface{}{"protos": []interface{}{"echo.proto"}})
+		So(err, ShouldBeNil)
+		So(s.Start(), ShouldBeNil)
+		defer s.Close()
+
+		client, err := NewRpcClient("grpc", "127.0.0.1:4984", map[string]interface{}{"protos": []interface{}{"echo.proto"}})
+		So(err, ShouldBeNil)
+		defer client.Close()
+		_, err = client.InvokeRPC("grpc.examples.echo.Echo.UnaryEcho", map[string]interface{}{"message": "hello"})
+		So(err, ShouldNotBeNil)
+	})
+}
@@ -1,10 +1,15 @@
 package protocols
 
 import (
+	"crypto/tls"
 	"errors"
-	"google.golang.org/grpc"
+	"fmt"
+	"math"
 	"strconv"
 	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 )
 
 type RpcClient interface {
@@ -15,15 +20,48 @@ type RpcClient interface {
 func NewRpcClient(protocol string, server string, options map[string]interface{}) (RpcClient, error) {
 	switch protocol {
 	case "grpc":
-		protos, exists := options["protos"]
-		if !exists {
+		src, err := newProtoSource(options)
+		if err != nil {
+			return nil, err
+		}
+		if src.empty() && !src.Reflection {
 			return nil, errors.New("no protos specified")
 		}
-		protosStr := make([]string, len(protos.([]interface{})))
-		for idx, proto := range protos.([]interface{}) {
-			protosStr[idx] = proto.(string)
+		tlsConfig, err := newClientTLSConfig(options)
+		if err != nil {
+			return nil, err
+		}
+		opts := []grpc.DialOption{grpc.WithBlock(), grpc.WithTimeout(2 * time.Second)}
+		if tlsConfig != nil {
+			opts = append(opts, grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)))
+		} else {
+			opts = append(opts, grpc.WithInsecure())
+		}
+		return NewGrpcClientWithSource(server, src, opts...)
+	case "http":
+		timeout, err := getDurationOption(options, "timeout")
+		if err != nil {
+			return nil, err
 		}
-		return NewGrpcClient(server, protosStr, grpc.WithBlock(), grpc.WithInsecure(), grpc.WithTimeout(2*time.Second)) // TODO: add SSL support
+		tlsConfig, err := newClientTLSConfig(options)
+		if err != nil {
+			return nil, err
+		}
+		return NewHTTPClient(server, timeout, tlsConfig)
+	case "dubbo":
+		opts := map[string]string{}
+		for _, key := range []string{"interface", "version", "group"} {
+			v, err := getStringOption(options, key)
+			if err != nil {
+				return nil, err
+			}
+			opts[key] = v
+		}
+		timeout, err := getDurationOption(options, "timeout")
+		if err != nil {
+			return nil, err
+		}
+		return NewDubboClient(server, opts["interface"], opts["version"], opts["group"], timeout)
 	default:
 		return nil, errors.New("unsupported protocol: " + protocol)
 	}
@@ -32,22 +70,167 @@ func NewRpcClient(protocol string, server string, options map[string]interface{}
 type RpcServer interface {
 	Start() error
 	Close() error
-	AddListener(func(mtd, direction, from, to, body string) error)
+	AddListener(func(mtd, direction, from, to, body string, seq int) error)
 }
 
 func NewRpcServer(protocol string, name string, port int, options map[string]interface{}) (RpcServer, error) {
 	switch protocol {
 	case "grpc":
-		protos, exists := options["protos"]
-		if !exists {
-			return nil, errors.New("no protos specified")
+		src, err := newProtoSource(options)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig, err := newServerTLSConfig(options)
+		if err != nil {
+			return nil, err
+		}
+		opts := []grpc.ServerOption{}
+		if tlsConfig != nil {
+			opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
 		}
-		protosStr := make([]string, len(protos.([]interface{})))
-		for idx, proto := range protos.([]interface{}) {
-			protosStr[idx] = proto.(string)
+		gs, err := NewGrpcServerWithSource(":"+strconv.Itoa(port), src, opts...)
+		if err != nil {
+			return nil, err
 		}
-		return NewGrpcServer(":"+strconv.Itoa(port), protosStr)
+		if err := setGrpcServerOptions(gs, src, options); err != nil {
+			gs.Close()
+			return nil, err
+		}
+		return gs, nil
+	case "http":
+		tlsConfig, err := newServerTLSConfig(options)
+		if err != nil {
+			return nil, err
+		}
+		return NewHTTPServer(":"+strconv.Itoa(port), tlsConfig)
+	case "dubbo":
+		return NewDubboServer(":" + strconv.Itoa(port))
 	default:
 		return nil, errors.New("unsupported protocol: " + protocol)
 	}
 }
+
+// set options of missing handlers, auto mock and proxy to grpc server
+func setGrpcServerOptions(gs *GrpcServer, src *ProtoSource, options map[string]interface{}) error {
+	// status code of methods without handler, eg. "NOT_FOUND", Unimplemented by default
+	if v, exists := options["missingHandlerCode"]; exists && v != nil {
+		code, err := parseCode(v)
+		if err != nil {
+			return fmt.Errorf("option missingHandlerCode: %v", err)
+		}
+		gs.SetMissingHandlerCode(code)
+	}
+	// respond methods without handler by generated messages, random values are used if seed set
+	autoMock, err := getBoolOption(options, "autoMock")
+	if err != nil {
+		return err
+	}
+	if autoMock {
+		seed, err := getIntOption(options, "autoMockSeed")
+		if err != nil {
+			return err
+		}
+		gs.SetAutoMock(NewAutoMock(seed))
+	}
+	// forward to backend if proxy set, connect in background so that server can start without backend
+	proxy, err := getStringOption(options, "proxy")
+	if err != nil || proxy == "" {
+		return err
+	}
+	proxyTLS, err := getBoolOption(options, "proxyTLS")
+	if err != nil {
+		return err
+	}
+	dialOpt := grpc.WithInsecure()
+	if proxyTLS {
+		dialOpt = grpc.WithTransportCredentials(credentials.NewTLS(&tls.Config{}))
+	}
+	client, err := NewGrpcClientWithSource(proxy, src, dialOpt)
+	if err != nil {
+		return err
+	}
+	gs.SetProxy(client)
+	return nil
+}
+
+// get string option, empty string returned if not exists
+func getStringOption(options map[string]interface{}, key string) (string, error) {
+	v, exists := options[key]
+	if !exists || v == nil {
+		return "", nil
+	}
+	s, ok := v.(string)
+	if !ok {
+		return "", fmt.Errorf("option %s should be a string", key)
+	}
+	return s, nil
+}
+
+// get string list option, nil returned if not exists
+func getStringsOption(options map[string]interface{}, key string) ([]string, error) {
+	v, exists := options[key]
+	if !exists || v == nil {
+		return nil, nil
+	}
+	switch v := v.(type) {
+	case []string:
+		return v, nil
+	case []interface{}:
+		items := make([]string, len(v))
+		for idx, item := range v {
+			s, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("option %s should be a list of string", key)
+			}
+			items[idx] = s
+		}
+		return items, nil
+	default:
+		return nil, fmt.Errorf("option %s should be a list of string", key)
+	}
+}
+
+// get duration option like "1s", "500ms", 0 returned if not exists
+func getDurationOption(options map[string]interface{}, key string) (time.Duration, error) {
+	s, err := getStringOption(options, key)
+	if err != nil || s == "" {
+		return 0, err
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("option %s should be a duration: %v", key, err)
+	}
+	return d, nil
+}
+
+// get bool option, false returned if not exists
+func getBoolOption(options map[string]interface{}, key string) (bool, error) {
+	v, exists := options[key]
+	if !exists || v == nil {
+		return false, nil
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return false, fmt.Errorf("option %s should be a bool", key)
+	}
+	return b, nil
+}
+
+// get integer option, 0 returned if not exists
+func getIntOption(options map[string]interface{}, key string) (int64, error) {
+	v, exists := options[key]
+	if !exists || v == nil {
+		return 0, nil
+	}
+	switch v := v.(type) {
+	case int:
+		return int64(v), nil
+	case int64:
+		return v, nil
+	case float64: // numbers decoded from json
+		if v == math.Trunc(v) {
+			return int64(v), nil
+		}
+	}
+	return 0, fmt.Errorf("option %s should be an integer", key)
+}
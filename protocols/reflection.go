@@ -0,0 +1,249 @@
+package protocols
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/jhump/protoreflect/desc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	rpb "google.golang.org/grpc/reflection/grpc_reflection_v1alpha"
+	"google.golang.org/grpc/status"
+)
+
+// reflection service describing all services of a server, including those loaded from proto files,
+// which are not known by the reflection service of grpc
+type reflectionServer struct {
+	files    map[string]*desc.FileDescriptor // by file name, with dependencies
+	services []string
+}
+
+// register reflection and health services if not defined in proto files,
+// files are the descriptors of services loaded from proto files
+func (gs *GrpcServer) registerBuiltinServices(files []*desc.FileDescriptor) error {
+	registered := gs.server.GetServiceInfo()
+
+	if _, exists := registered["grpc.health.v1.Health"]; !exists {
+		gs.health = &healthService{Server: health.NewServer(), statuses: map[string]string{}}
+		healthpb.RegisterHealthServer(gs.server, gs.health)
+	}
+	rs := &reflectionServer{files: map[string]*desc.FileDescriptor{}}
+	if _, exists := registered["grpc.reflection.v1alpha.ServerReflection"]; !exists {
+		rpb.RegisterServerReflectionServer(gs.server, rs)
+	}
+
+	for _, fd := range files {
+		rs.addFile(fd)
+	}
+	for name, info := range gs.server.GetServiceInfo() {
+		rs.services = append(rs.services, name)
+		if rs.findSymbol(name) != nil {
+			continue
+		}
+		// services compiled in, eg. health
+		file, ok := info.Metadata.(string)
+		if !ok {
+			continue
+		}
+		fd, err := desc.LoadFileDescriptor(file)
+		if err != nil {
+			return fmt.Errorf("failed to load descriptor of %s: %v", name, err)
+		}
+		rs.addFile(fd)
+	}
+	sort.Strings(rs.services)
+
+	if gs.health != nil {
+		gs.health.setStatus("", healthpb.HealthCheckResponse_SERVING)
+		for _, name := range rs.services {
+			gs.health.setStatus(name, healthpb.HealthCheckResponse_SERVING)
+		}
+	}
+	return nil
+}
+
+func (rs *reflectionServer) addFile(fd *desc.FileDescriptor) {
+	if _, exists := rs.files[fd.GetName()]; exists {
+		return
+	}
+	rs.files[fd.GetName()] = fd
+	for _, dep := range fd.GetDependencies() {
+		rs.addFile(dep)
+	}
+}
+
+// file defining the symbol, nil if not found
+func (rs *reflectionServer) findSymbol(name string) *desc.FileDescriptor {
+	for _, fd := range rs.files {
+		if fd.FindSymbol(name) != nil {
+			return fd
+		}
+	}
+	return nil
+}
+
+// extensions of message type in all files
+func (rs *reflectionServer) findExtensions(typeName string) []*desc.FieldDescriptor {
+	exts := []*desc.FieldDescriptor{}
+	var walk func(fields []*desc.FieldDescriptor, msgs []*desc.MessageDescriptor)
+	walk = func(fields []*desc.FieldDescriptor, msgs []*desc.MessageDescriptor) {
+		for _, fld := range fields {
+			if fld.GetOwner().GetFullyQualifiedName() == typeName {
+				exts = append(exts, fld)
+			}
+		}
+		for _, msg := range msgs {
+			walk(msg.GetNestedExtensions(), msg.GetNestedMessageTypes())
+		}
+	}
+	for _, fd := range rs.files {
+		walk(fd.GetExtensions(), fd.GetMessageTypes())
+	}
+	return exts
+}
+
+// serialized file and its dependencies, the file is the first one
+func (rs *reflectionServer) encodeFile(fd *desc.FileDescriptor) ([][]byte, error) {
+	encoded := [][]byte{}
+	sent := map[string]bool{}
+	var encode func(fd *desc.FileDescriptor) error
+	encode = func(fd *desc.FileDescriptor) error {
+		if sent[fd.GetName()] {
+			return nil
+		}
+		sent[fd.GetName()] = true
+		b, err := proto.Marshal(fd.AsFileDescriptorProto())
+		if err != nil {
+			return err
+		}
+		encoded = append(encoded, b)
+		for _, dep := range fd.GetDependencies() {
+			if err := encode(dep); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return encoded, encode(fd)
+}
+
+func (rs *reflectionServer) ServerReflectionInfo(stream rpb.ServerReflection_ServerReflectionInfoServer) error {
+	for {
+		in, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		out := &rpb.ServerReflectionResponse{ValidHost: in.Host, OriginalRequest: in}
+		var fd *desc.FileDescriptor
+		switch req := in.MessageRequest.(type) {
+		case *rpb.ServerReflectionRequest_FileByFilename:
+			if fd = rs.files[req.FileByFilename]; fd == nil {
+				err = fmt.Errorf("unknown file: %s", req.FileByFilename)
+			}
+		case *rpb.ServerReflectionRequest_FileContainingSymbol:
+			if fd = rs.findSymbol(req.FileContainingSymbol); fd == nil {
+				err = fmt.Errorf("unknown symbol: %s", req.FileContainingSymbol)
+			}
+		case *rpb.ServerReflectionRequest_FileContainingExtension:
+			ext := req.FileContainingExtension
+			for _, fld := range rs.findExtensions(ext.ContainingType) {
+				if fld.GetNumber() == ext.ExtensionNumber {
+					fd = fld.GetFile()
+				}
+			}
+			if fd == nil {
+				err = fmt.Errorf("unknown extension %d of %s", ext.ExtensionNumber, ext.ContainingType)
+			}
+		case *rpb.ServerReflectionRequest_AllExtensionNumbersOfType:
+			if rs.findSymbol(req.AllExtensionNumbersOfType) == nil {
+				err = fmt.Errorf("unknown type: %s", req.AllExtensionNumbersOfType)
+				break
+			}
+			nums := []int32{}
+			for _, fld := range rs.findExtensions(req.AllExtensionNumbersOfType) {
+				nums = append(nums, fld.GetNumber())
+			}
+			out.MessageResponse = &rpb.ServerReflectionResponse_AllExtensionNumbersResponse{
+				AllExtensionNumbersResponse: &rpb.ExtensionNumberResponse{BaseTypeName: req.AllExtensionNumbersOfType, ExtensionNumber: nums},
+			}
+		case *rpb.ServerReflectionRequest_ListServices:
+			services := make([]*rpb.ServiceResponse, len(rs.services))
+			for idx, name := range rs.services {
+				services[idx] = &rpb.ServiceResponse{Name: name}
+			}
+			out.MessageResponse = &rpb.ServerReflectionResponse_ListServicesResponse{
+				ListServicesResponse: &rpb.ListServiceResponse{Service: services},
+			}
+		default:
+			return status.Errorf(codes.InvalidArgument, "invalid MessageRequest: %v", in.MessageRequest)
+		}
+
+		if fd != nil {
+			var files [][]byte
+			if files, err = rs.encodeFile(fd); err == nil {
+				out.MessageResponse = &rpb.ServerReflectionResponse_FileDescriptorResponse{
+					FileDescriptorResponse: &rpb.FileDescriptorResponse{FileDescriptorProto: files},
+				}
+			}
+		}
+		if err != nil {
+			out.MessageResponse = &rpb.ServerReflectionResponse_ErrorResponse{
+				ErrorResponse: &rpb.ErrorResponse{ErrorCode: int32(codes.NotFound), ErrorMessage: err.Error()},
+			}
+		}
+		if err := stream.Send(out); err != nil {
+			return err
+		}
+	}
+}
+
+// standard health service which keeps serving status of services
+type healthService struct {
+	*health.Server
+
+	lock     sync.Mutex
+	statuses map[string]string
+}
+
+func (hs *healthService) setStatus(service string, st healthpb.HealthCheckResponse_ServingStatus) {
+	hs.lock.Lock()
+	defer hs.lock.Unlock()
+	hs.statuses[service] = st.String()
+	hs.SetServingStatus(service, st)
+}
+
+// set serving status reported by health service, service is empty for the whole server,
+// status can be SERVING, NOT_SERVING or SERVICE_UNKNOWN
+func (gs *GrpcServer) SetServingStatus(service, st string) error {
+	if gs.health == nil {
+		return fmt.Errorf("health service is defined in proto files")
+	}
+	value, ok := healthpb.HealthCheckResponse_ServingStatus_value[st]
+	if !ok || value == int32(healthpb.HealthCheckResponse_UNKNOWN) {
+		return fmt.Errorf("invalid serving status: %s", st)
+	}
+	gs.health.setStatus(service, healthpb.HealthCheckResponse_ServingStatus(value))
+	return nil
+}
+
+// serving statuses by service, the whole server is ""
+func (gs *GrpcServer) ServingStatuses() map[string]string {
+	statuses := map[string]string{}
+	if gs.health == nil {
+		return statuses
+	}
+	gs.health.lock.Lock()
+	defer gs.health.lock.Unlock()
+	for service, st := range gs.health.statuses {
+		statuses[service] = st
+	}
+	return statuses
+}
@@ -0,0 +1,74 @@
+package protocols
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/jhump/protoreflect/dynamic"
+	"google.golang.org/grpc"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func TestGrpcServerReflection(t *testing.T) {
+	s, _ := NewGrpcServer(":4987", []string{"helloworld.proto", "echo.proto"})
+	s.SetMethodHandler("helloworld.Greeter.SayHello", func(in *dynamic.Message, out *dynamic.Message, stream grpc.ServerStream) error {
+		out.SetFieldByName("message", "hello "+in.GetFieldByName("name").(string))
+		return nil
+	})
+	s.Start()
+	defer s.Close()
+	time.Sleep(time.Millisecond) // make sure server started
+
+	// no protos, services are got from server reflection
+	client, _ := NewGrpcClient("127.0.0.1:4987", nil, grpc.WithInsecure())
+	defer client.Close()
+
+	Convey("discover services by reflection", t, func() {
+		services, err := client.ListServices()
+		So(err, ShouldBeNil)
+		So(services, ShouldResemble, []string{
+			"grpc.examples.echo.Echo",
+			"grpc.health.v1.Health",
+			"grpc.reflection.v1alpha.ServerReflection",
+			"helloworld.Greeter",
+		})
+		methods, err := client.ListMethods("grpc.health.v1.Health")
+		So(err, ShouldBeNil)
+		So(methods, ShouldContain, "grpc.health.v1.Health.Check")
+
+		out, err := client.InvokeRPC("helloworld.Greeter.SayHello", map[string]interface{}{"name": "you"})
+		So(err, ShouldBeNil)
+		So(out.(*GrpcResult).Messages[0]["message"], ShouldEqual, "hello you")
+
+		_, err = client.InvokeRPC("helloworld.Unknown.SayHello", map[string]interface{}{})
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("health of services can be changed", t, func() {
+		conn, _ := grpc.Dial("127.0.0.1:4987", grpc.WithInsecure())
+		defer conn.Close()
+		hc := healthpb.NewHealthClient(conn)
+		check := func(service string) healthpb.HealthCheckResponse_ServingStatus {
+			resp, err := hc.Check(context.Background(), &healthpb.HealthCheckRequest{Service: service})
+			So(err, ShouldBeNil)
+			return resp.Status
+		}
+
+		So(check(""), ShouldEqual, healthpb.HealthCheckResponse_SERVING)
+		So(check("helloworld.Greeter"), ShouldEqual, healthpb.HealthCheckResponse_SERVING)
+
+		So(s.SetServingStatus("helloworld.Greeter", "NOT_SERVING"), ShouldBeNil)
+		So(check("helloworld.Greeter"), ShouldEqual, healthpb.HealthCheckResponse_NOT_SERVING)
+		So(check("grpc.examples.echo.Echo"), ShouldEqual, healthpb.HealthCheckResponse_SERVING)
+		So(s.ServingStatuses()["helloworld.Greeter"], ShouldEqual, "NOT_SERVING")
+		So(s.ServingStatuses()[""], ShouldEqual, "SERVING")
+
+		So(s.SetServingStatus("helloworld.Greeter", "UNKNOWN"), ShouldNotBeNil)
+		So(s.SetServingStatus("helloworld.Greeter", "xxx"), ShouldNotBeNil)
+		So(s.SetServingStatus("helloworld.Greeter", "SERVING"), ShouldBeNil)
+		So(check("helloworld.Greeter"), ShouldEqual, healthpb.HealthCheckResponse_SERVING)
+	})
+}
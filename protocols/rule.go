@@ -0,0 +1,289 @@
+package protocols
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/jhump/protoreflect/dynamic"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// one rule of method, handler is used when request matched
+type MethodRule struct {
+	Matcher *RuleMatcher // nil matcher matches all requests, used as fallback
+	Handler func(in *dynamic.Message, out *dynamic.Message, stream grpc.ServerStream) error
+}
+
+// request conditions of a rule, all of them should be matched
+type RuleMatcher struct {
+	Fields   []*ValueMatcher `json:"fields,omitempty"`   // match request fields, path like $.user.name or items[*].id
+	Metadata []*ValueMatcher `json:"metadata,omitempty"` // match incoming metadata, path is metadata key
+	Peer     string          `json:"peer,omitempty"`     // regexp of peer address, eg. ^127\.0\.0\.1:
+
+	peerRe *regexp.Regexp
+}
+
+// match value in path with an operator, supported operators:
+// equals(default), regex, contains, exists and absent
+type ValueMatcher struct {
+	Path  string `json:"path"`
+	Op    string `json:"op,omitempty"`
+	Value string `json:"value,omitempty"`
+
+	re *regexp.Regexp
+}
+
+func (rm *RuleMatcher) compile() error {
+	if rm.Peer != "" {
+		re, err := regexp.Compile(rm.Peer)
+		if err != nil {
+			return fmt.Errorf("invalid peer regexp %s: %v", rm.Peer, err)
+		}
+		rm.peerRe = re
+	}
+	for _, vm := range rm.Fields {
+		if _, err := parsePath(vm.Path); err != nil {
+			return err
+		}
+		if err := vm.compile(); err != nil {
+			return err
+		}
+	}
+	for _, vm := range rm.Metadata {
+		if err := vm.compile(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// match request with its message, incoming context and peer address
+func (rm *RuleMatcher) Match(ctx context.Context, in *dynamic.Message, peerAddr string) (bool, error) {
+	if rm.peerRe != nil && !rm.peerRe.MatchString(peerAddr) {
+		return false, nil
+	}
+
+	if len(rm.Metadata) > 0 {
+		md, _ := metadata.FromIncomingContext(ctx)
+		for _, vm := range rm.Metadata {
+			values := md.Get(vm.Path)
+			items := make([]interface{}, len(values))
+			for idx, v := range values {
+				items[idx] = v
+			}
+			if !vm.match(items) {
+				return false, nil
+			}
+		}
+	}
+
+	if len(rm.Fields) > 0 {
+		doc, err := messageToJSONValue(in, messageMarshaler)
+		if err != nil {
+			return false, err
+		}
+		return matchFields(rm.Fields, doc, func() interface{} {
+			full, _ := messageToJSONValue(in, defaultsMarshaler)
+			return full
+		})
+	}
+
+	return true, nil
+}
+
+// match fields with json of message, and json with default values returned by full, nil if unknown,
+// exists and absent only match the former, other operators match either of them, so that absent
+// scalars are matched as their default values
+func matchFields(fields []*ValueMatcher, doc interface{}, full func() interface{}) (bool, error) {
+	var fullDoc interface{}
+
+	for _, vm := range fields {
+		items, err := lookupPath(doc, vm.Path)
+		if err != nil {
+			return false, err
+		}
+		if vm.match(items) {
+			continue
+		}
+		if vm.Op == "exists" || vm.Op == "absent" {
+			return false, nil
+		}
+		if fullDoc == nil {
+			if fullDoc = full(); fullDoc == nil {
+				return false, nil
+			}
+		}
+		if items, err = lookupPath(fullDoc, vm.Path); err != nil || !vm.match(items) {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func (vm *ValueMatcher) compile() error {
+	switch vm.Op {
+	case "", "equals", "contains", "exists", "absent":
+	case "regex":
+		re, err := regexp.Compile(vm.Value)
+		if err != nil {
+			return fmt.Errorf("invalid regexp %s: %v", vm.Value, err)
+		}
+		vm.re = re
+	default:
+		return fmt.Errorf("unsupported match operator: %s", vm.Op)
+	}
+	return nil
+}
+
+// match if any of the items matched
+func (vm *ValueMatcher) match(items []interface{}) bool {
+	switch vm.Op {
+	case "exists":
+		return len(items) > 0
+	case "absent":
+		return len(items) == 0
+	}
+
+	for _, item := range items {
+		s := valueString(item)
+		switch vm.Op {
+		case "", "equals":
+			if s == vm.Value {
+				return true
+			}
+		case "contains":
+			if strings.Contains(s, vm.Value) {
+				return true
+			}
+		case "regex":
+			if vm.re.MatchString(s) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// convert message to json value by marshaler, messageMarshaler for the same format as messageString,
+// or defaultsMarshaler with fields of default values, numbers are json.Number
+func messageToJSONValue(msg *dynamic.Message, marshaler *jsonpb.Marshaler) (interface{}, error) {
+	var doc interface{}
+
+	if msg == nil {
+		return map[string]interface{}{}, nil
+	}
+	b, err := msg.MarshalJSONPB(marshaler)
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	decoder.UseNumber()
+	if err := decoder.Decode(&doc); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+func valueString(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	case bool:
+		return strconv.FormatBool(v)
+	case nil:
+		return ""
+	default:
+		b, _ := json.Marshal(v)
+		return string(b)
+	}
+}
+
+// path segment, key for object, index for array, -1 index means all items
+type pathSegment struct {
+	key   string
+	index int
+	isKey bool
+}
+
+// parse JSONPath like expression, eg. $.user.name, items[0].id, items[*].id
+func parsePath(path string) ([]pathSegment, error) {
+	segments := []pathSegment{}
+	p := strings.TrimPrefix(strings.TrimPrefix(path, "$"), ".")
+
+	if p == "" {
+		return segments, nil
+	}
+	for _, part := range strings.Split(p, ".") {
+		key := part
+		indexes := []int{}
+		if pos := strings.Index(part, "["); pos >= 0 {
+			key = part[:pos]
+			for _, idxStr := range strings.Split(strings.TrimSuffix(part[pos+1:], "]"), "][") {
+				if idxStr == "*" {
+					indexes = append(indexes, -1)
+					continue
+				}
+				idx, err := strconv.Atoi(idxStr)
+				if err != nil || idx < 0 {
+					return nil, fmt.Errorf("invalid path %s: bad index %s", path, idxStr)
+				}
+				indexes = append(indexes, idx)
+			}
+		}
+		if key == "" && len(indexes) == 0 {
+			return nil, fmt.Errorf("invalid path %s: empty key", path)
+		}
+		if key != "" {
+			segments = append(segments, pathSegment{key: key, isKey: true})
+		}
+		for _, idx := range indexes {
+			segments = append(segments, pathSegment{index: idx})
+		}
+	}
+
+	return segments, nil
+}
+
+// find all values in path of json document
+func lookupPath(doc interface{}, path string) ([]interface{}, error) {
+	segments, err := parsePath(path)
+	if err != nil {
+		return nil, err
+	}
+
+	items := []interface{}{doc}
+	for _, seg := range segments {
+		next := []interface{}{}
+		for _, item := range items {
+			if seg.isKey {
+				if obj, ok := item.(map[string]interface{}); ok {
+					if v, exists := obj[seg.key]; exists {
+						next = append(next, v)
+					}
+				}
+				continue
+			}
+			arr, ok := item.([]interface{})
+			if !ok {
+				continue
+			}
+			if seg.index < 0 {
+				next = append(next, arr...)
+			} else if seg.index < len(arr) {
+				next = append(next, arr[seg.index])
+			}
+		}
+		items = next
+	}
+
+	return items, nil
+}
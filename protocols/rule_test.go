@@ -0,0 +1,133 @@
+package protocols
+
+import (
+	"context"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/jhump/protoreflect/desc/protoparse"
+	"github.com/jhump/protoreflect/dynamic"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestLookupPath(t *testing.T) {
+	doc := map[string]interface{}{
+		"name": "you",
+		"user": map[string]interface{}{"id": "1", "tags": []interface{}{"a", "b"}},
+		"items": []interface{}{
+			map[string]interface{}{"id": "x"},
+			map[string]interface{}{"id": "y"},
+		},
+	}
+
+	Convey("lookup object keys", t, func() {
+		items, err := lookupPath(doc, "$.name")
+		So(err, ShouldBeNil)
+		So(items, ShouldResemble, []interface{}{"you"})
+		items, err = lookupPath(doc, "user.id")
+		So(err, ShouldBeNil)
+		So(items, ShouldResemble, []interface{}{"1"})
+		items, err = lookupPath(doc, "user.notexist")
+		So(err, ShouldBeNil)
+		So(len(items), ShouldEqual, 0)
+	})
+
+	Convey("lookup array items", t, func() {
+		items, err := lookupPath(doc, "items[1].id")
+		So(err, ShouldBeNil)
+		So(items, ShouldResemble, []interface{}{"y"})
+		items, err = lookupPath(doc, "$.items[*].id")
+		So(err, ShouldBeNil)
+		So(items, ShouldResemble, []interface{}{"x", "y"})
+		items, err = lookupPath(doc, "user.tags[5]")
+		So(err, ShouldBeNil)
+		So(len(items), ShouldEqual, 0)
+	})
+
+	Convey("invalid path", t, func() {
+		_, err := lookupPath(doc, "items[a].id")
+		So(err, ShouldNotBeNil)
+		_, err = lookupPath(doc, "user..id")
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestRuleMatcher(t *testing.T) {
+	fds, _ := protoparse.Parser{}.ParseFiles("helloworld.proto")
+	in := dynamic.NewMessage(fds[0].FindMessage("helloworld.HelloRequest"))
+	in.SetFieldByName("name", "simgo")
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Bearer abc"))
+
+	Convey("match request fields", t, func() {
+		rm := &RuleMatcher{Fields: []*ValueMatcher{{Path: "name", Value: "simgo"}}}
+		So(rm.compile(), ShouldBeNil)
+		matched, err := rm.Match(ctx, in, "127.0.0.1:1234")
+		So(err, ShouldBeNil)
+		So(matched, ShouldBeTrue)
+
+		rm = &RuleMatcher{Fields: []*ValueMatcher{{Path: "name", Op: "regex", Value: "^sim"}, {Path: "name", Op: "contains", Value: "xx"}}}
+		So(rm.compile(), ShouldBeNil)
+		matched, err = rm.Match(ctx, in, "127.0.0.1:1234")
+		So(err, ShouldBeNil)
+		So(matched, ShouldBeFalse)
+	})
+
+	Convey("fields of default values are absent", t, func() {
+		empty := dynamic.NewMessage(fds[0].FindMessage("helloworld.HelloRequest"))
+		for _, c := range []struct {
+			msg     *dynamic.Message
+			op      string
+			matched bool
+		}{{in, "exists", true}, {in, "absent", false}, {empty, "exists", false}, {empty, "absent", true}} {
+			rm := &RuleMatcher{Fields: []*ValueMatcher{{Path: "name", Op: c.op}}}
+			So(rm.compile(), ShouldBeNil)
+			matched, err := rm.Match(ctx, c.msg, "127.0.0.1:1234")
+			So(err, ShouldBeNil)
+			So(matched, ShouldEqual, c.matched)
+		}
+	})
+
+	Convey("absent fields equal default values", t, func() {
+		fds, err := protoparse.Parser{Accessor: protoparse.FileContentsFromMap(map[string]string{"order.proto": `syntax = "proto3";
+package order;
+enum State { NEW = 0; PAID = 1; }
+message Order { string id = 1; int32 count = 2; bool paid = 3; State state = 4; }`})}.ParseFiles("order.proto")
+		So(err, ShouldBeNil)
+		order := dynamic.NewMessage(fds[0].FindMessage("order.Order"))
+		for _, vm := range []*ValueMatcher{{Path: "count", Value: "0"}, {Path: "paid", Value: "false"}, {Path: "id", Value: ""}, {Path: "state", Value: "NEW"}, {Path: "count", Op: "regex", Value: "^0$"}, {Path: "count", Op: "absent"}} {
+			rm := &RuleMatcher{Fields: []*ValueMatcher{vm}}
+			So(rm.compile(), ShouldBeNil)
+			matched, err := rm.Match(ctx, order, "127.0.0.1:1234")
+			So(err, ShouldBeNil)
+			So(matched, ShouldBeTrue)
+		}
+		rm := &RuleMatcher{Fields: []*ValueMatcher{{Path: "count", Value: "1"}}}
+		So(rm.compile(), ShouldBeNil)
+		matched, _ := rm.Match(ctx, order, "127.0.0.1:1234")
+		So(matched, ShouldBeFalse)
+	})
+
+	Convey("match metadata and peer", t, func() {
+		rm := &RuleMatcher{Metadata: []*ValueMatcher{{Path: "Authorization", Op: "regex", Value: "^Bearer "}}, Peer: `^127\.0\.0\.1:`}
+		So(rm.compile(), ShouldBeNil)
+		matched, err := rm.Match(ctx, in, "127.0.0.1:1234")
+		So(err, ShouldBeNil)
+		So(matched, ShouldBeTrue)
+		matched, err = rm.Match(ctx, in, "10.0.0.1:1234")
+		So(err, ShouldBeNil)
+		So(matched, ShouldBeFalse)
+
+		rm = &RuleMatcher{Metadata: []*ValueMatcher{{Path: "x-user-id", Op: "absent"}}}
+		So(rm.compile(), ShouldBeNil)
+		matched, err = rm.Match(ctx, in, "127.0.0.1:1234")
+		So(err, ShouldBeNil)
+		So(matched, ShouldBeTrue)
+	})
+
+	Convey("invalid matchers", t, func() {
+		So((&RuleMatcher{Peer: "("}).compile(), ShouldNotBeNil)
+		So((&RuleMatcher{Fields: []*ValueMatcher{{Path: "name", Op: "regex", Value: "["}}}).compile(), ShouldNotBeNil)
+		So((&RuleMatcher{Fields: []*ValueMatcher{{Path: "name", Op: "like"}}}).compile(), ShouldNotBeNil)
+	})
+}
@@ -0,0 +1,88 @@
+package protocols
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/golang/protobuf/ptypes/any"
+	_ "google.golang.org/genproto/googleapis/rpc/errdetails" // register error details types, eg. google.rpc.BadRequest
+	spb "google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type statusJSON struct {
+	Code    codes.Code        `json:"code"`
+	Message string            `json:"message"`
+	Details []json.RawMessage `json:"details"`
+}
+
+// parse grpc status from json content, eg.
+// {"code": "INVALID_ARGUMENT", "message": "invalid name", "details": [{"@type": "type.googleapis.com/google.rpc.BadRequest", "fieldViolations": [{"field": "name"}]}]}
+// code can be a number or a name, OK is not allowed
+func ParseStatus(content []byte) (*status.Status, error) {
+	var st statusJSON
+
+	if err := json.Unmarshal(content, &st); err != nil {
+		return nil, fmt.Errorf("invalid status: %v", err)
+	}
+	if st.Code == codes.OK {
+		return nil, fmt.Errorf("invalid status: code should not be OK")
+	}
+
+	return NewStatus(st.Code, st.Message, st.Details...)
+}
+
+// parse grpc code from a name like "NOT_FOUND" or a number, OK is not allowed
+func parseCode(v interface{}) (codes.Code, error) {
+	var code codes.Code
+
+	content, err := json.Marshal(v)
+	if err != nil {
+		return code, fmt.Errorf("invalid code: %v", err)
+	}
+	if err = json.Unmarshal(content, &code); err != nil {
+		return code, fmt.Errorf("invalid code: %v", err)
+	}
+	if code == codes.OK {
+		return code, fmt.Errorf("invalid code: code should not be OK")
+	}
+	return code, nil
+}
+
+// create grpc status with details, each detail is a google.protobuf.Any in json format, eg.
+// {"@type": "type.googleapis.com/google.rpc.RetryInfo", "retryDelay": "1s"}
+func NewStatus(code codes.Code, msg string, details ...json.RawMessage) (*status.Status, error) {
+	st := &spb.Status{Code: int32(code), Message: msg, Details: make([]*any.Any, len(details))}
+
+	for idx, detail := range details {
+		st.Details[idx] = &any.Any{}
+		if err := jsonpb.UnmarshalString(string(detail), st.Details[idx]); err != nil {
+			return nil, fmt.Errorf("invalid status detail: %v", err)
+		}
+	}
+
+	return status.FromProto(st), nil
+}
+
+// convert grpc status to json content which can be parsed by ParseStatus,
+// details whose types are not registered are ignored
+func marshalStatus(st *status.Status) (json.RawMessage, error) {
+	return json.Marshal(statusJSON{Code: st.Code(), Message: st.Message(), Details: statusDetails(st)})
+}
+
+// details of grpc status in json format, details whose types are not registered are ignored
+func statusDetails(st *status.Status) []json.RawMessage {
+	details := []json.RawMessage{}
+	marshaler := &jsonpb.Marshaler{}
+
+	for _, detail := range st.Proto().GetDetails() {
+		s, err := marshaler.MarshalToString(detail)
+		if err != nil {
+			continue
+		}
+		details = append(details, json.RawMessage(s))
+	}
+	return details
+}
@@ -0,0 +1,50 @@
+package protocols
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+)
+
+func TestParseStatus(t *testing.T) {
+	Convey("status code can be a number or a name", t, func() {
+		st, err := ParseStatus([]byte(`{"code": 5, "message": "not found"}`))
+		So(err, ShouldBeNil)
+		So(st.Code(), ShouldEqual, codes.NotFound)
+		So(st.Message(), ShouldEqual, "not found")
+
+		st, err = ParseStatus([]byte(`{"code": "PERMISSION_DENIED"}`))
+		So(err, ShouldBeNil)
+		So(st.Code(), ShouldEqual, codes.PermissionDenied)
+	})
+
+	Convey("OK and invalid codes are not allowed", t, func() {
+		_, err := ParseStatus([]byte(`{"code": 0, "message": "ok"}`))
+		So(err, ShouldNotBeNil)
+		_, err = ParseStatus([]byte(`{"code": "NOT_EXIST"}`))
+		So(err, ShouldNotBeNil)
+		_, err = ParseStatus([]byte(`{"code": 100}`))
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("status with error details", t, func() {
+		st, err := ParseStatus([]byte(`{"code": "INVALID_ARGUMENT", "message": "bad request", "details": [
+			{"@type": "type.googleapis.com/google.rpc.BadRequest", "fieldViolations": [{"field": "name", "description": "empty"}]},
+			{"@type": "type.googleapis.com/google.rpc.RetryInfo", "retryDelay": "1.500s"}
+		]}`))
+		So(err, ShouldBeNil)
+		details := st.Details()
+		So(len(details), ShouldEqual, 2)
+		So(details[0].(*errdetails.BadRequest).FieldViolations[0].Field, ShouldEqual, "name")
+		So(details[1].(*errdetails.RetryInfo).RetryDelay.Seconds, ShouldEqual, 1)
+		So(details[1].(*errdetails.RetryInfo).RetryDelay.Nanos, ShouldEqual, 500000000)
+	})
+
+	Convey("unknown error detail type", t, func() {
+		_, err := ParseStatus([]byte(`{"code": 3, "details": [{"@type": "type.googleapis.com/not.Exist"}]}`))
+		So(err, ShouldNotBeNil)
+	})
+}
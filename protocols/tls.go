@@ -0,0 +1,125 @@
+package protocols
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+)
+
+// TLS related options:
+//
+//	caFile: CA bundle to verify the peer certificate
+//	certFile, keyFile: certificate and private key of this side
+//	serverName: (client only) override server name used to verify server certificate
+//	tls: (client only) use TLS with system CAs even if no CA or certificate set
+//	clientCertRequired: (server only) require and verify client certificate, caFile should be set
+const (
+	optCAFile             = "caFile"
+	optCertFile           = "certFile"
+	optKeyFile            = "keyFile"
+	optServerName         = "serverName"
+	optTLS                = "tls"
+	optClientCertRequired = "clientCertRequired"
+)
+
+// create client TLS config from options, nil returned if TLS not enabled
+func newClientTLSConfig(options map[string]interface{}) (*tls.Config, error) {
+	caFile, err := getStringOption(options, optCAFile)
+	if err != nil {
+		return nil, err
+	}
+	certFile, err := getStringOption(options, optCertFile)
+	if err != nil {
+		return nil, err
+	}
+	keyFile, err := getStringOption(options, optKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	serverName, err := getStringOption(options, optServerName)
+	if err != nil {
+		return nil, err
+	}
+	enabled, err := getBoolOption(options, optTLS)
+	if err != nil {
+		return nil, err
+	}
+	if !enabled && caFile == "" && certFile == "" {
+		return nil, nil
+	}
+
+	cfg := &tls.Config{ServerName: serverName}
+	if caFile != "" {
+		if cfg.RootCAs, err = loadCertPool(caFile); err != nil {
+			return nil, err
+		}
+	}
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %v", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+
+	return cfg, nil
+}
+
+// create server TLS config from options, nil returned if no certificate set
+func newServerTLSConfig(options map[string]interface{}) (*tls.Config, error) {
+	caFile, err := getStringOption(options, optCAFile)
+	if err != nil {
+		return nil, err
+	}
+	certFile, err := getStringOption(options, optCertFile)
+	if err != nil {
+		return nil, err
+	}
+	keyFile, err := getStringOption(options, optKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	clientCertRequired, err := getBoolOption(options, optClientCertRequired)
+	if err != nil {
+		return nil, err
+	}
+	if certFile == "" && keyFile == "" {
+		if caFile != "" || clientCertRequired {
+			return nil, fmt.Errorf("%s and %s should be set to enable TLS", optCertFile, optKeyFile)
+		}
+		return nil, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load server certificate: %v", err)
+	}
+	cfg := &tls.Config{Certificates: []tls.Certificate{cert}}
+	if caFile != "" {
+		if cfg.ClientCAs, err = loadCertPool(caFile); err != nil {
+			return nil, err
+		}
+		cfg.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	if clientCertRequired {
+		if caFile == "" {
+			return nil, fmt.Errorf("%s should be set if client certificate required", optCAFile)
+		}
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return cfg, nil
+}
+
+func loadCertPool(caFile string) (*x509.CertPool, error) {
+	b, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA file: %v", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(b) {
+		return nil, fmt.Errorf("no certificate found in CA file %s", caFile)
+	}
+	return pool, nil
+}
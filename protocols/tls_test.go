@@ -0,0 +1,128 @@
+package protocols
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/jhump/protoreflect/dynamic"
+	"google.golang.org/grpc"
+)
+
+func TestGrpcTLS(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "simgo-tls")
+	defer os.RemoveAll(dir)
+	ca, caKey := genCert(dir, "ca", nil, nil)
+	genCert(dir, "server", ca, caKey)
+	genCert(dir, "client", ca, caKey)
+	pemFile := func(name string) string { return filepath.Join(dir, name) }
+
+	s, err := NewRpcServer("grpc", "tls", 4998, map[string]interface{}{
+		"protos":             []interface{}{"helloworld.proto"},
+		"certFile":           pemFile("server.crt"),
+		"keyFile":            pemFile("server.key"),
+		"caFile":             pemFile("ca.crt"),
+		"clientCertRequired": true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.(*GrpcServer).SetMethodHandler("helloworld.Greeter.SayHello", func(in *dynamic.Message, out *dynamic.Message, stream grpc.ServerStream) error {
+		out.SetFieldByName("message", "secured")
+		return nil
+	})
+	s.Start()
+	defer s.Close()
+	time.Sleep(time.Millisecond) // make sure server started
+
+	Convey("mutual TLS client", t, func() {
+		client, err := NewRpcClient("grpc", "127.0.0.1:4998", map[string]interface{}{
+			"protos":     []interface{}{"helloworld.proto"},
+			"caFile":     pemFile("ca.crt"),
+			"certFile":   pemFile("client.crt"),
+			"keyFile":    pemFile("client.key"),
+			"serverName": "simgo.test",
+		})
+		So(err, ShouldBeNil)
+		defer client.Close()
+		out, err := client.InvokeRPC("helloworld.Greeter.SayHello", map[string]interface{}{"name": "you"})
+		So(err, ShouldBeNil)
+		So(out.(*GrpcResult).Messages[0]["message"], ShouldEqual, "secured")
+	})
+
+	Convey("client without certificate is rejected", t, func() {
+		_, err := NewRpcClient("grpc", "127.0.0.1:4998", map[string]interface{}{
+			"protos":     []interface{}{"helloworld.proto"},
+			"caFile":     pemFile("ca.crt"),
+			"serverName": "simgo.test",
+		})
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("insecure client is rejected", t, func() {
+		_, err := NewRpcClient("grpc", "127.0.0.1:4998", map[string]interface{}{
+			"protos": []interface{}{"helloworld.proto"},
+		})
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("invalid TLS options", t, func() {
+		_, err := NewRpcServer("grpc", "tls", 4997, map[string]interface{}{
+			"protos":             []interface{}{"helloworld.proto"},
+			"clientCertRequired": true,
+		})
+		So(err, ShouldNotBeNil)
+		_, err = NewRpcServer("grpc", "tls", 4997, map[string]interface{}{
+			"protos":   []interface{}{"helloworld.proto"},
+			"certFile": pemFile("server.crt"),
+			"keyFile":  pemFile("notexist.key"),
+		})
+		So(err, ShouldNotBeNil)
+		_, err = NewRpcClient("grpc", "127.0.0.1:4998", map[string]interface{}{
+			"protos": []interface{}{"helloworld.proto"},
+			"caFile": pemFile("server.key"),
+		})
+		So(err, ShouldNotBeNil)
+	})
+}
+
+// generate certificate signed by parent, self-signed CA generated if parent is nil
+// name.crt and name.key are written to dir
+func genCert(dir, name string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		DNSNames:     []string{"simgo.test"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	if parent == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+		tmpl.KeyUsage |= x509.KeyUsageCertSign
+		parent, parentKey = tmpl, key
+	}
+	der, _ := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+	cert, _ := x509.ParseCertificate(der)
+	keyDer, _ := x509.MarshalECPrivateKey(key)
+	ioutil.WriteFile(filepath.Join(dir, name+".crt"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	ioutil.WriteFile(filepath.Join(dir, name+".key"), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600)
+
+	return cert, key
+}
@@ -0,0 +1,236 @@
+package protocols
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// request messages kept by server for verification, the oldest one is dropped if full
+	JOURNALSIZE = 10000
+	// interval of checking expectations when waiting
+	verifyInterval = 10 * time.Millisecond
+)
+
+// request message received by server
+type ReceivedMessage struct {
+	Method string `json:"method"`
+	Peer   string `json:"peer"`
+	Body   string `json:"body"` // json of message
+	Seq    int    `json:"seq"`  // sequence number in stream
+}
+
+// expectation of request messages, at least one message is expected if no count is set
+type Expectation struct {
+	Method  string          `json:"method"`
+	Fields  []*ValueMatcher `json:"fields,omitempty"` // match body fields, path like $.user.name or items[*].id
+	Peer    string          `json:"peer,omitempty"`   // regexp of peer address
+	Times   *int            `json:"times,omitempty"`  // exact count of matched messages
+	AtLeast *int            `json:"atLeast,omitempty"`
+	AtMost  *int            `json:"atMost,omitempty"`
+
+	peerRe *regexp.Regexp
+}
+
+// expectations to verify, waits until all of them met if timeout set, eg. 1s
+type Verification struct {
+	Expectations []*Expectation `json:"expectations"`
+	Ordered      bool           `json:"ordered"` // the first matched messages of expectations are received in order
+	Timeout      string         `json:"timeout"`
+}
+
+type VerifyResult struct {
+	OK      bool                 `json:"ok"`
+	Results []*ExpectationResult `json:"results"`
+	Message string               `json:"message,omitempty"` // reason of failure
+}
+
+type ExpectationResult struct {
+	Method  string `json:"method"`
+	Count   int    `json:"count"` // count of matched messages
+	OK      bool   `json:"ok"`
+	Message string `json:"message,omitempty"`
+}
+
+func (exp *Expectation) compile() error {
+	if exp.Method == "" {
+		return errors.New("method of expectation should not be empty")
+	}
+	if exp.Peer != "" {
+		re, err := regexp.Compile(exp.Peer)
+		if err != nil {
+			return fmt.Errorf("invalid peer regexp %s: %v", exp.Peer, err)
+		}
+		exp.peerRe = re
+	}
+	for _, vm := range exp.Fields {
+		if _, err := parsePath(vm.Path); err != nil {
+			return err
+		}
+		if err := vm.compile(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// match message, defaults returns json of message with default values, nil if unknown
+func (exp *Expectation) match(msg *ReceivedMessage, defaults func(msg *ReceivedMessage) interface{}) bool {
+	if exp.Method != msg.Method {
+		return false
+	}
+	if exp.peerRe != nil && !exp.peerRe.MatchString(msg.Peer) {
+		return false
+	}
+	if len(exp.Fields) == 0 {
+		return true
+	}
+
+	var doc interface{}
+	decoder := json.NewDecoder(strings.NewReader(msg.Body))
+	decoder.UseNumber()
+	if err := decoder.Decode(&doc); err != nil {
+		return false
+	}
+	matched, err := matchFields(exp.Fields, doc, func() interface{} {
+		if defaults == nil {
+			return nil
+		}
+		return defaults(msg)
+	})
+	return err == nil && matched
+}
+
+// check count of matched messages
+func (exp *Expectation) check(count int) (bool, string) {
+	switch {
+	case exp.Times != nil && count != *exp.Times:
+		return false, fmt.Sprintf("expected %d times, but got %d", *exp.Times, count)
+	case exp.AtLeast != nil && count < *exp.AtLeast:
+		return false, fmt.Sprintf("expected at least %d times, but got %d", *exp.AtLeast, count)
+	case exp.AtMost != nil && count > *exp.AtMost:
+		return false, fmt.Sprintf("expected at most %d times, but got %d", *exp.AtMost, count)
+	case exp.Times == nil && exp.AtLeast == nil && exp.AtMost == nil && count == 0:
+		return false, "expected at least once, but got 0"
+	}
+	return true, ""
+}
+
+// verify messages in received order, error returned if any expectation is invalid
+func Verify(msgs []*ReceivedMessage, v *Verification) (*VerifyResult, error) {
+	for _, exp := range v.Expectations {
+		if err := exp.compile(); err != nil {
+			return nil, err
+		}
+	}
+	return verify(msgs, v, nil), nil
+}
+
+func verify(msgs []*ReceivedMessage, v *Verification, defaults func(msg *ReceivedMessage) interface{}) *VerifyResult {
+	result := &VerifyResult{OK: true, Results: make([]*ExpectationResult, len(v.Expectations))}
+
+	for idx, exp := range v.Expectations {
+		count := 0
+		for _, msg := range msgs {
+			if exp.match(msg, defaults) {
+				count++
+			}
+		}
+		ok, reason := exp.check(count)
+		result.Results[idx] = &ExpectationResult{Method: exp.Method, Count: count, OK: ok, Message: reason}
+		result.OK = result.OK && ok
+	}
+
+	if result.OK && v.Ordered {
+		pos := 0
+		for idx, exp := range v.Expectations {
+			for pos < len(msgs) && !exp.match(msgs[pos], defaults) {
+				pos++
+			}
+			if pos >= len(msgs) {
+				result.OK = false
+				result.Message = fmt.Sprintf("expectation %d of %s is not received in order", idx, exp.Method)
+				break
+			}
+			pos++
+		}
+	}
+
+	return result
+}
+
+// verify messages returned by fetch until all expectations met or timeout
+func VerifyWait(fetch func() []*ReceivedMessage, v *Verification) (*VerifyResult, error) {
+	return verifyWait(fetch, v, nil)
+}
+
+func verifyWait(fetch func() []*ReceivedMessage, v *Verification, defaults func(msg *ReceivedMessage) interface{}) (*VerifyResult, error) {
+	var timeout time.Duration
+
+	if v.Timeout != "" {
+		d, err := time.ParseDuration(v.Timeout)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timeout: %v", err)
+		}
+		timeout = d
+	}
+	for _, exp := range v.Expectations {
+		if err := exp.compile(); err != nil {
+			return nil, err
+		}
+	}
+	result := verify(fetch(), v, defaults)
+
+	deadline := time.Now().Add(timeout)
+	for !result.OK && time.Now().Before(deadline) {
+		time.Sleep(verifyInterval)
+		result = verify(fetch(), v, defaults)
+	}
+	return result, nil
+}
+
+// source of request messages for verification, eg. messages kept by ops, so that they are verified and cleared
+// in one place, the journal of server is used if not set
+type ReceivedSource interface {
+	Received() []*ReceivedMessage // in received order
+	ClearReceived()
+}
+
+// request messages received by server, the latest JOURNALSIZE ones are kept in a ring
+type messageJournal struct {
+	sync.RWMutex
+
+	msgs  []*ReceivedMessage
+	start int // index of the oldest message if full
+}
+
+func (mj *messageJournal) add(msg *ReceivedMessage) {
+	mj.Lock()
+	defer mj.Unlock()
+	if len(mj.msgs) < JOURNALSIZE {
+		mj.msgs = append(mj.msgs, msg)
+		return
+	}
+	mj.msgs[mj.start] = msg
+	mj.start = (mj.start + 1) % JOURNALSIZE
+}
+
+func (mj *messageJournal) Received() []*ReceivedMessage {
+	mj.RLock()
+	defer mj.RUnlock()
+	msgs := make([]*ReceivedMessage, 0, len(mj.msgs))
+	msgs = append(msgs, mj.msgs[mj.start:]...)
+	return append(msgs, mj.msgs[:mj.start]...)
+}
+
+func (mj *messageJournal) ClearReceived() {
+	mj.Lock()
+	defer mj.Unlock()
+	mj.msgs = nil
+	mj.start = 0
+}
@@ -0,0 +1,185 @@
+package protocols
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/jhump/protoreflect/desc"
+	"github.com/jhump/protoreflect/dynamic"
+	"google.golang.org/grpc"
+)
+
+func intPtr(n int) *int {
+	return &n
+}
+
+func TestVerify(t *testing.T) {
+	msgs := []*ReceivedMessage{
+		{Method: "helloworld.Greeter.SayHello", Peer: "127.0.0.1:1000", Body: `{"name": "alice"}`, Seq: 1},
+		{Method: "grpc.examples.echo.Echo.UnaryEcho", Peer: "127.0.0.1:1000", Body: `{"message": "x"}`, Seq: 1},
+		{Method: "helloworld.Greeter.SayHello", Peer: "10.0.0.1:1000", Body: `{"name": "bob"}`, Seq: 1},
+	}
+
+	Convey("verify counts", t, func() {
+		result, err := Verify(msgs, &Verification{Expectations: []*Expectation{
+			{Method: "helloworld.Greeter.SayHello", Times: intPtr(2)},
+			{Method: "grpc.examples.echo.Echo.UnaryEcho"},
+			{Method: "helloworld.Greeter.SayHello", AtLeast: intPtr(1), AtMost: intPtr(2)},
+		}})
+		So(err, ShouldBeNil)
+		So(result.OK, ShouldBeTrue)
+		So(result.Results[0].Count, ShouldEqual, 2)
+
+		result, _ = Verify(msgs, &Verification{Expectations: []*Expectation{
+			{Method: "helloworld.Greeter.SayHello", AtMost: intPtr(1)},
+			{Method: "grpc.examples.echo.Echo.ServerStreamingEcho"},
+		}})
+		So(result.OK, ShouldBeFalse)
+		So(result.Results[0].Message, ShouldEqual, "expected at most 1 times, but got 2")
+		So(result.Results[1].Message, ShouldEqual, "expected at least once, but got 0")
+	})
+
+	Convey("verify fields and peer", t, func() {
+		result, _ := Verify(msgs, &Verification{Expectations: []*Expectation{
+			{Method: "helloworld.Greeter.SayHello", Fields: []*ValueMatcher{{Path: "$.name", Value: "bob"}}, Times: intPtr(1)},
+			{Method: "helloworld.Greeter.SayHello", Fields: []*ValueMatcher{{Path: "name", Op: "regex", Value: "^a"}}, Peer: `^127\.`, Times: intPtr(1)},
+			{Method: "helloworld.Greeter.SayHello", Peer: `^192\.`, Times: intPtr(0)},
+		}})
+		So(result.OK, ShouldBeTrue)
+	})
+
+	Convey("verify order", t, func() {
+		result, _ := Verify(msgs, &Verification{Ordered: true, Expectations: []*Expectation{
+			{Method: "helloworld.Greeter.SayHello"},
+			{Method: "grpc.examples.echo.Echo.UnaryEcho"},
+			{Method: "helloworld.Greeter.SayHello", Fields: []*ValueMatcher{{Path: "name", Value: "bob"}}},
+		}})
+		So(result.OK, ShouldBeTrue)
+
+		result, _ = Verify(msgs, &Verification{Ordered: true, Expectations: []*Expectation{
+			{Method: "helloworld.Greeter.SayHello", Fields: []*ValueMatcher{{Path: "name", Value: "bob"}}},
+			{Method: "grpc.examples.echo.Echo.UnaryEcho"},
+		}})
+		So(result.OK, ShouldBeFalse)
+		So(result.Message, ShouldEqual, "expectation 1 of grpc.examples.echo.Echo.UnaryEcho is not received in order")
+	})
+
+	Convey("invalid expectations", t, func() {
+		_, err := Verify(msgs, &Verification{Expectations: []*Expectation{{}}})
+		So(err, ShouldNotBeNil)
+		_, err = Verify(msgs, &Verification{Expectations: []*Expectation{{Method: "x", Peer: "("}}})
+		So(err, ShouldNotBeNil)
+		_, err = Verify(msgs, &Verification{Expectations: []*Expectation{{Method: "x", Fields: []*ValueMatcher{{Path: "a", Op: "unknown"}}}}})
+		So(err, ShouldNotBeNil)
+		_, err = VerifyWait(func() []*ReceivedMessage { return msgs }, &Verification{Timeout: "x"})
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestMessageJournal(t *testing.T) {
+	Convey("the latest messages are kept in received order", t, func() {
+		journal := &messageJournal{}
+		for idx := 0; idx < JOURNALSIZE+2; idx++ {
+			journal.add(&ReceivedMessage{Seq: idx})
+		}
+		msgs := journal.Received()
+		So(len(msgs), ShouldEqual, JOURNALSIZE)
+		So(msgs[0].Seq, ShouldEqual, 2)
+		So(msgs[JOURNALSIZE-1].Seq, ShouldEqual, JOURNALSIZE+1)
+
+		journal.ClearReceived()
+		So(journal.Received(), ShouldBeEmpty)
+		journal.add(&ReceivedMessage{Seq: 1})
+		So(journal.Received()[0].Seq, ShouldEqual, 1)
+	})
+}
+
+func TestGrpcServerVerify(t *testing.T) {
+	// no listener added, messages are still kept for verification
+	s, _ := NewGrpcServer(":4990", []string{"helloworld.proto", "echo.proto"})
+	s.SetMethodHandler("helloworld.Greeter.SayHello", func(in *dynamic.Message, out *dynamic.Message, stream grpc.ServerStream) error {
+		out.SetFieldByName("message", "hello")
+		return nil
+	})
+	s.SetMethodHandler("grpc.examples.echo.Echo.ClientStreamingEcho", func(in *dynamic.Message, out *dynamic.Message, stream grpc.ServerStream) error {
+		for {
+			if err := stream.RecvMsg(in); err == io.EOF {
+				break
+			} else if err != nil {
+				return err
+			}
+		}
+		out.SetFieldByName("message", "done")
+		return stream.SendMsg(out)
+	})
+	s.Start()
+	defer s.Close()
+	time.Sleep(time.Millisecond) // make sure server started
+
+	client, _ := NewGrpcClient("127.0.0.1:4990", []string{"helloworld.proto", "echo.proto"}, grpc.WithInsecure())
+	defer client.Close()
+
+	Convey("verify received requests", t, func() {
+		client.InvokeRPC("helloworld.Greeter.SayHello", map[string]interface{}{"name": "you"})
+		result, err := s.Verify(&Verification{Expectations: []*Expectation{
+			{Method: "helloworld.Greeter.SayHello", Fields: []*ValueMatcher{{Path: "name", Value: "you"}}, Times: intPtr(1)},
+		}})
+		So(err, ShouldBeNil)
+		So(result.OK, ShouldBeTrue)
+
+		s.ClearReceived()
+		result, _ = s.Verify(&Verification{Expectations: []*Expectation{{Method: "helloworld.Greeter.SayHello"}}})
+		So(result.OK, ShouldBeFalse)
+	})
+
+	Convey("verify streaming requests", t, func() {
+		s.ClearReceived()
+		_, err := client.InvokeRPC("grpc.examples.echo.Echo.ClientStreamingEcho", []map[string]interface{}{{"message": "a"}, {"message": "b"}})
+		So(err, ShouldBeNil)
+		result, err := s.Verify(&Verification{Ordered: true, Expectations: []*Expectation{
+			{Method: "grpc.examples.echo.Echo.ClientStreamingEcho", Fields: []*ValueMatcher{{Path: "message", Value: "a"}}, Times: intPtr(1)},
+			{Method: "grpc.examples.echo.Echo.ClientStreamingEcho", Fields: []*ValueMatcher{{Path: "message", Value: "b"}}, Times: intPtr(1)},
+		}})
+		So(err, ShouldBeNil)
+		So(result.OK, ShouldBeTrue)
+	})
+
+	Convey("fields are matched the same as handler rules", t, func() {
+		s.ClearReceived()
+		client.InvokeRPC("helloworld.Greeter.SayHello", map[string]interface{}{})
+		dsc, _ := s.desc.FindSymbol("helloworld.HelloRequest")
+		empty := dynamic.NewMessage(dsc.(*desc.MessageDescriptor))
+		for _, vm := range []*ValueMatcher{{Path: "$.name", Value: ""}, {Path: "name", Op: "absent"}, {Path: "name", Op: "exists"}} {
+			rm := &RuleMatcher{Fields: []*ValueMatcher{vm}}
+			So(rm.compile(), ShouldBeNil)
+			matched, err := rm.Match(context.Background(), empty, "")
+			So(err, ShouldBeNil)
+			result, err := s.Verify(&Verification{Expectations: []*Expectation{{Method: "helloworld.Greeter.SayHello", Fields: []*ValueMatcher{vm}}}})
+			So(err, ShouldBeNil)
+			So(result.OK, ShouldEqual, matched)
+		}
+		result, _ := s.Verify(&Verification{Expectations: []*Expectation{{Method: "helloworld.Greeter.SayHello", Fields: []*ValueMatcher{{Path: "name", Value: ""}}}}})
+		So(result.OK, ShouldBeTrue)
+	})
+
+	Convey("wait until expectations met", t, func() {
+		go func() {
+			time.Sleep(50 * time.Millisecond)
+			client.InvokeRPC("helloworld.Greeter.SayHello", map[string]interface{}{"name": "later"})
+		}()
+		result, err := s.Verify(&Verification{Timeout: "2s", Expectations: []*Expectation{
+			{Method: "helloworld.Greeter.SayHello", Fields: []*ValueMatcher{{Path: "name", Value: "later"}}},
+		}})
+		So(err, ShouldBeNil)
+		So(result.OK, ShouldBeTrue)
+
+		start := time.Now()
+		result, _ = s.Verify(&Verification{Timeout: "50ms", Expectations: []*Expectation{{Method: "helloworld.Greeter.SayHello", Times: intPtr(5)}}})
+		So(result.OK, ShouldBeFalse)
+		So(time.Since(start), ShouldBeGreaterThanOrEqualTo, 50*time.Millisecond)
+	})
+}
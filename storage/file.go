@@ -0,0 +1,107 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// storage in files, each value is saved in <dir>/<kind>/<id>.json
+type FileStorage struct {
+	sync.Mutex
+
+	dir string
+}
+
+func NewFileStorage(dir string) (*FileStorage, error) {
+	if dir == "" {
+		return nil, fmt.Errorf("data directory should not be empty")
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &FileStorage{dir: dir}, nil
+}
+
+func (fs *FileStorage) Save(kind string, id uint64, value interface{}) error {
+	b, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return err
+	}
+	fs.Lock()
+	defer fs.Unlock()
+	kindDir := filepath.Join(fs.dir, kind)
+	if err := os.MkdirAll(kindDir, 0755); err != nil {
+		return err
+	}
+	// write to temp file first, so a crash never leaves a broken file
+	tmp, err := ioutil.TempFile(kindDir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	if _, err = tmp.Write(b); err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), fs.path(kind, id))
+}
+
+func (fs *FileStorage) Remove(kind string, id uint64) error {
+	fs.Lock()
+	defer fs.Unlock()
+	if err := os.Remove(fs.path(kind, id)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (fs *FileStorage) LoadAll(kind string) ([][]byte, error) {
+	fs.Lock()
+	defer fs.Unlock()
+	files, err := ioutil.ReadDir(filepath.Join(fs.dir, kind))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return [][]byte{}, nil
+		}
+		return nil, err
+	}
+
+	ids := []uint64{}
+	for _, f := range files {
+		if f.IsDir() || !strings.HasSuffix(f.Name(), ".json") {
+			continue
+		}
+		id, err := strconv.ParseUint(strings.TrimSuffix(f.Name(), ".json"), 10, 64)
+		if err != nil {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(idx1, idx2 int) bool {
+		return ids[idx1] < ids[idx2]
+	})
+
+	values := make([][]byte, len(ids))
+	for idx, id := range ids {
+		if values[idx], err = ioutil.ReadFile(fs.path(kind, id)); err != nil {
+			return nil, err
+		}
+	}
+	return values, nil
+}
+
+func (fs *FileStorage) path(kind string, id uint64) string {
+	return filepath.Join(fs.dir, kind, strconv.FormatUint(id, 10)+".json")
+}
@@ -0,0 +1,56 @@
+package storage
+
+import (
+	"encoding/json"
+	"sort"
+	"sync"
+)
+
+// storage in memory, nothing left after restart
+type MemoryStorage struct {
+	sync.RWMutex
+
+	m map[string]map[uint64][]byte
+}
+
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{m: map[string]map[uint64][]byte{}}
+}
+
+func (ms *MemoryStorage) Save(kind string, id uint64, value interface{}) error {
+	b, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	ms.Lock()
+	defer ms.Unlock()
+	if _, exists := ms.m[kind]; !exists {
+		ms.m[kind] = map[uint64][]byte{}
+	}
+	ms.m[kind][id] = b
+	return nil
+}
+
+func (ms *MemoryStorage) Remove(kind string, id uint64) error {
+	ms.Lock()
+	defer ms.Unlock()
+	delete(ms.m[kind], id)
+	return nil
+}
+
+func (ms *MemoryStorage) LoadAll(kind string) ([][]byte, error) {
+	ms.RLock()
+	defer ms.RUnlock()
+	ids := make([]uint64, 0, len(ms.m[kind]))
+	for id := range ms.m[kind] {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(idx1, idx2 int) bool {
+		return ids[idx1] < ids[idx2]
+	})
+	values := make([][]byte, len(ids))
+	for idx, id := range ids {
+		values[idx] = ms.m[kind][id]
+	}
+	return values, nil
+}
@@ -0,0 +1,24 @@
+package storage
+
+import (
+	"errors"
+)
+
+// storage of definitions, values are saved as json and grouped by kind, eg. servers, clients
+type Storage interface {
+	Save(kind string, id uint64, value interface{}) error
+	Remove(kind string, id uint64) error
+	LoadAll(kind string) ([][]byte, error) // json of values in id order
+}
+
+// create storage, path is the data directory of file storage
+func New(storageType string, path string) (Storage, error) {
+	switch storageType {
+	case "file":
+		return NewFileStorage(path)
+	case "memory":
+		return NewMemoryStorage(), nil
+	default:
+		return nil, errors.New("unsupported storage: " + storageType)
+	}
+}
@@ -0,0 +1,58 @@
+package storage
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestStorage(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "simgo-storage")
+	defer os.RemoveAll(dir)
+	fileStorage, err := New("file", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, st := range map[string]Storage{"file": fileStorage, "memory": NewMemoryStorage()} {
+		Convey(name+" storage save, load and remove", t, func() {
+			values, err := st.LoadAll("servers")
+			So(err, ShouldBeNil)
+			So(values, ShouldBeEmpty)
+
+			So(st.Save("servers", 10, map[string]interface{}{"name": "s10"}), ShouldBeNil)
+			So(st.Save("servers", 2, map[string]interface{}{"name": "s2"}), ShouldBeNil)
+			So(st.Save("servers", 2, map[string]interface{}{"name": "s2-new"}), ShouldBeNil)
+			So(st.Save("clients", 1, map[string]interface{}{"name": "c1"}), ShouldBeNil)
+			values, err = st.LoadAll("servers")
+			So(err, ShouldBeNil)
+			So(len(values), ShouldEqual, 2)
+			So(string(values[0]), ShouldContainSubstring, "s2-new")
+			So(string(values[1]), ShouldContainSubstring, "s10")
+
+			So(st.Remove("servers", 2), ShouldBeNil)
+			So(st.Remove("servers", 3), ShouldBeNil)
+			values, _ = st.LoadAll("servers")
+			So(len(values), ShouldEqual, 1)
+			values, _ = st.LoadAll("clients")
+			So(len(values), ShouldEqual, 1)
+		})
+	}
+
+	Convey("file storage ignores unknown files", t, func() {
+		ioutil.WriteFile(filepath.Join(dir, "clients", "readme.txt"), []byte("x"), 0644)
+		values, err := fileStorage.LoadAll("clients")
+		So(err, ShouldBeNil)
+		So(len(values), ShouldEqual, 1)
+	})
+
+	Convey("unsupported storage", t, func() {
+		_, err := New("redis", "")
+		So(err, ShouldNotBeNil)
+		_, err = New("file", "")
+		So(err, ShouldNotBeNil)
+	})
+}
@@ -1,9 +1,13 @@
 package utils
 
 import (
+	"fmt"
+	"math/rand"
 	"strconv"
 )
 
+const letters = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
 func Min(first int, numbers ...int) int {
 	min := first
 	for _, num := range numbers {
@@ -18,3 +22,38 @@ func Min(first int, numbers ...int) int {
 func AtoUint64(s string) (uint64, error) {
 	return strconv.ParseUint(s, 10, 64)
 }
+
+// random integer in [min, max], min returned if max is less than min
+func RandInt(min, max int) int {
+	if max <= min {
+		return min
+	}
+	return min + rand.Intn(max-min+1)
+}
+
+// random string of n letters and digits
+func RandString(n int) string {
+	return randString(rand.Intn, n)
+}
+
+// random string of n letters and digits from r, reproducible if r is seeded
+func RandStringFrom(r *rand.Rand, n int) string {
+	return randString(r.Intn, n)
+}
+
+func randString(intn func(int) int, n int) string {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = letters[intn(len(letters))]
+	}
+	return string(b)
+}
+
+// random version 4 UUID
+func UUID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
@@ -1,6 +1,7 @@
 package utils
 
 import (
+	"math/rand"
 	"testing"
 
 	. "github.com/smartystreets/goconvey/convey"
@@ -46,3 +47,25 @@ func TestAtoUint64(t *testing.T) {
 		So(err, ShouldNotBeNil)
 	})
 }
+
+func TestRandom(t *testing.T) {
+	Convey("random integer in range", t, func() {
+		for i := 0; i < 100; i++ {
+			So(RandInt(1, 3), ShouldBeBetweenOrEqual, 1, 3)
+		}
+		So(RandInt(5, 1), ShouldEqual, 5)
+	})
+
+	Convey("random string", t, func() {
+		So(RandString(8), ShouldHaveLength, 8)
+		So(RandString(0), ShouldBeEmpty)
+		So(RandStringFrom(rand.New(rand.NewSource(1)), 8), ShouldEqual, RandStringFrom(rand.New(rand.NewSource(1)), 8))
+	})
+
+	Convey("uuid", t, func() {
+		id := UUID()
+		So(id, ShouldHaveLength, 36)
+		So(id[14], ShouldEqual, '4')
+		So(id, ShouldNotEqual, UUID())
+	})
+}
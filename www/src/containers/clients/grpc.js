@@ -83,7 +83,10 @@ class GrpcClientComponent extends React.Component {
           data: values.data
         })
       } catch(err) {
-        return message.error(err.response.data)
+        const data = err.response.data
+        this.response = data.result
+        this.setState({loading: false})
+        return message.error(data.error || JSON.stringify(data))
       }
 
       this.response = resp.data